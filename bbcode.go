@@ -0,0 +1,119 @@
+package mark
+
+import (
+	"html"
+	"strconv"
+	"strings"
+)
+
+// BBCode returns the BBCode representation of the given nodes, using the
+// tags that are supported by most forums(b, i, s, url, img, code, quote,
+// list). constructs without a matching tag are downgraded, e.g: headings
+// are rendered as bold lines and tables as "|" separated lines. block
+// nodes are separated by a blank line.
+func BBCode(nodes ...Node) string {
+	return bbSerializer().blocks(nodes)
+}
+
+// BBCode returns the BBCode representation of the document.
+func (d *DocumentNode) BBCode() string {
+	return BBCode(d.Nodes...)
+}
+
+// bbSerializer returns the BBCode serializer.
+func bbSerializer() serializer {
+	return serializer{block: bbBlock, inline: bbInline, cellSep: " | "}
+}
+
+// bbBlock returns the BBCode representation of a block node.
+func bbBlock(node Node) string {
+	switch n := node.(type) {
+	case *ParagraphNode:
+		return bbInline(n.Nodes)
+	case *HeadingNode:
+		return "[b]" + bbInline(n.Nodes) + "[/b]"
+	case *HrNode:
+		return "----"
+	case *CodeNode:
+		return "[code]" + codeText(n) + "[/code]"
+	case *DefLinkNode:
+		return ""
+	case *ListNode:
+		tag := "[list]"
+		if n.Ordered {
+			tag = "[list=" + strconv.Itoa(n.start()) + "]"
+		}
+		var items []string
+		for _, item := range n.Items {
+			items = append(items, "[*]"+bbSerializer().listItem(item))
+		}
+		return tag + "\n" + strings.Join(items, "\n") + "\n[/list]"
+	case *TableNode:
+		return bbSerializer().table(n)
+	case *BlockQuoteNode:
+		return "[quote]" + BBCode(n.Nodes...) + "[/quote]"
+	case *DetailsNode:
+		return "[spoiler=" + bbInline(n.Summary) + "]" + BBCode(n.Nodes...) + "[/spoiler]"
+	case *DivNode:
+		return BBCode(n.Nodes...)
+	case *TabsNode:
+		var tabs []string
+		for _, tab := range n.Tabs {
+			tabs = append(tabs, "[b]"+html.UnescapeString(tab.Title)+"[/b]\n"+BBCode(tab.Nodes...))
+		}
+		return strings.Join(tabs, "\n\n")
+	case *HTMLNode:
+		if n.Open != "" {
+			return BBCode(n.Nodes...)
+		}
+		return strings.TrimRight(n.Src, "\n")
+	case *RawNode:
+		return n.Text
+	case *ShortcodeNode:
+		return mdShortcode(n)
+	default:
+		return bbSerializer().fallback(node)
+	}
+}
+
+// bbInline returns the BBCode representation of inline nodes.
+func bbInline(nodes []Node) (s string) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *TextNode:
+			s += html.UnescapeString(n.Text)
+		case *LiteralNode:
+			s += html.UnescapeString(n.Text)
+		case *BrNode:
+			s += "\n"
+		case *EmphasisNode:
+			tag := map[itemType]string{itemStrong: "b", itemItalic: "i", itemStrike: "s", itemCode: "code"}[n.Style]
+			s += "[" + tag + "]" + bbInline(n.Nodes) + "[/" + tag + "]"
+		case *LinkNode:
+			s += "[url=" + html.UnescapeString(n.Href) + "]" + bbInline(n.Nodes) + "[/url]"
+		case *ImageNode:
+			s += "[img]" + html.UnescapeString(n.Src) + "[/img]"
+		case *RefNode:
+			s += bbInline([]Node{n.resolve()})
+		case *EmojiNode:
+			s += ":" + n.Name + ":"
+		case *RubyNode:
+			s += bbInline(n.Nodes) + "(" + html.UnescapeString(n.Text) + ")"
+		case *CheckboxNode:
+			if n.Checked {
+				s += "[x] "
+			} else {
+				s += "[ ] "
+			}
+		case *HTMLNode:
+			s += n.Src
+		case *CustomInlineNode:
+			s += n.Match[0]
+		case *RawNode:
+			s += n.Text
+		case *ShortcodeNode:
+			s += mdShortcode(n)
+		}
+	}
+	return
+}
@@ -0,0 +1,41 @@
+package mark
+
+import "testing"
+
+func TestBBCode(t *testing.T) {
+	cases := map[string]string{
+		"# Title\n\nfoo **bar** _baz_ ~~qux~~ `a<b`":  "[b]Title[/b]\n\nfoo [b]bar[/b] [i]baz[/i] [s]qux[/s] [code]a<b[/code]",
+		"[x](http://a.com?b=1&c=2) ![y](/z.png)":      "[url=http://a.com?b=1&c=2]x[/url] [img]/z.png[/img]",
+		"- foo\n- [x] bar\n\nlist:\n\n1. one\n2. two": "[list]\n[*]foo\n[*][x] bar\n[/list]\n\nlist:\n\n[list=1]\n[*]one\n[*]two\n[/list]",
+		"```go\nif a < b {}\n```":                     "[code]if a < b {}[/code]",
+		"> quote\n\n***\n\nit's &amp; ok":             "[quote]quote[/quote]\n\n----\n\nit's & ok",
+		"a | b\n--|--\n1 | 2":                         "a | b\n1 | 2",
+		"foo  \nbar":                                  "foo\nbar",
+		"[ref][r]\n\n[r]: /r":                         "[url=/r]ref[/url]",
+		"- foo\n    - bar":                            "[list]\n[*]foo\n[list]\n[*]bar\n[/list]\n[/list]",
+	}
+	for input, expected := range cases {
+		if actual := Parse(input, nil).BBCode(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+}
+
+func TestBBCodeNodes(t *testing.T) {
+	cases := []struct {
+		node     Node
+		expected string
+	}{
+		{NewListItem(NewText("foo"), NewParagraph(NewText("bar"))), "foo\nbar"},
+		{NewRow(NewCell(Data, None, NewText("a")), NewCell(Data, None, NewText("b"))), "a | b"},
+		{NewTab("t", NewParagraph(NewText("foo"))), "foo"},
+		{NewDocument(NewParagraph(NewText("a")), NewHr()), "a\n\n----"},
+		{NewParagraph(NewText("a"), NewRaw("<b>"), userNode{NodeType: 100}), "a<b>"},
+		{userNode{NodeType: 100}, ""},
+	}
+	for _, c := range cases {
+		if actual := BBCode(c.node); actual != c.expected {
+			t.Errorf("%T: got\n\t%+v\nexpected\n\t%+v", c.node, actual, c.expected)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package mark
+
+// cloneNodes returns a deep copy of the given nodes. nodes that don't
+// implement Clone are shared.
+func cloneNodes(nodes []Node) []Node {
+	if nodes == nil {
+		return nil
+	}
+	cp := make([]Node, len(nodes))
+	for i, n := range nodes {
+		if c, ok := n.(interface{ Clone() Node }); ok {
+			cp[i] = c.Clone()
+		} else {
+			cp[i] = n
+		}
+	}
+	return cp
+}
+
+// Clone returns a deep copy of the document. the source positions of
+// the original nodes are not copied.
+func (d *DocumentNode) Clone() *DocumentNode {
+	cp := *d
+	cp.Nodes, cp.spans = cloneNodes(d.Nodes), nil
+	cp.Diagnostics = append([]Diagnostic(nil), d.Diagnostics...)
+	if d.Links != nil {
+		cp.Links = make(map[string]*DefLinkNode, len(d.Links))
+		for name, l := range d.Links {
+			cp.Links[name] = l.Clone().(*DefLinkNode)
+		}
+	}
+	return &cp
+}
+
+// Clone returns a deep copy of the node.
+func (n *ParagraphNode) Clone() Node {
+	cp := *n
+	cp.Nodes = cloneNodes(n.Nodes)
+	return &cp
+}
+
+// Clone returns a copy of the node.
+func (n *TextNode) Clone() Node {
+	cp := *n
+	return &cp
+}
+
+// Clone returns a deep copy of the node.
+func (n *HTMLNode) Clone() Node {
+	cp := *n
+	cp.Nodes = cloneNodes(n.Nodes)
+	return &cp
+}
+
+// Clone returns a copy of the node.
+func (n *CustomInlineNode) Clone() Node {
+	cp := *n
+	cp.Match = append([]string(nil), n.Match...)
+	return &cp
+}
+
+// Clone returns a copy of the node.
+func (n *LiteralNode) Clone() Node {
+	cp := *n
+	return &cp
+}
+
+// Clone returns a copy of the node.
+func (n *RawNode) Clone() Node {
+	cp := *n
+	return &cp
+}
+
+// Clone returns a deep copy of the node.
+func (n *ShortcodeNode) Clone() Node {
+	cp := *n
+	cp.Args = make(map[string]string, len(n.Args))
+	for key, value := range n.Args {
+		cp.Args[key] = value
+	}
+	cp.Nodes = cloneNodes(n.Nodes)
+	return &cp
+}
+
+// Clone returns a copy of the node.
+func (n *HrNode) Clone() Node {
+	cp := *n
+	return &cp
+}
+
+// Clone returns a copy of the node.
+func (n *BrNode) Clone() Node {
+	cp := *n
+	return &cp
+}
+
+// Clone returns a deep copy of the node.
+func (n *EmphasisNode) Clone() Node {
+	cp := *n
+	cp.Nodes = cloneNodes(n.Nodes)
+	return &cp
+}
+
+// Clone returns a deep copy of the node.
+func (n *DetailsNode) Clone() Node {
+	cp := *n
+	cp.Summary = cloneNodes(n.Summary)
+	cp.Nodes = cloneNodes(n.Nodes)
+	return &cp
+}
+
+// Clone returns a deep copy of the node.
+func (n *DivNode) Clone() Node {
+	cp := *n
+	cp.Classes = append([]string(nil), n.Classes...)
+	cp.Attrs = make(map[string]string, len(n.Attrs))
+	for key, value := range n.Attrs {
+		cp.Attrs[key] = value
+	}
+	cp.Nodes = cloneNodes(n.Nodes)
+	return &cp
+}
+
+// Clone returns a deep copy of the node.
+func (n *TabsNode) Clone() Node {
+	cp := *n
+	cp.Tabs = nil
+	for _, tab := range n.Tabs {
+		cp.Tabs = append(cp.Tabs, tab.Clone().(*TabNode))
+	}
+	return &cp
+}
+
+// Clone returns a deep copy of the node.
+func (n *TabNode) Clone() Node {
+	cp := *n
+	cp.Nodes = cloneNodes(n.Nodes)
+	return &cp
+}
+
+// Clone returns a deep copy of the node.
+func (n *RubyNode) Clone() Node {
+	cp := *n
+	cp.Nodes = cloneNodes(n.Nodes)
+	return &cp
+}
+
+// Clone returns a deep copy of the node.
+func (n *HeadingNode) Clone() Node {
+	cp := *n
+	cp.Nodes = cloneNodes(n.Nodes)
+	return &cp
+}
+
+// Clone returns a copy of the node.
+func (n *EmojiNode) Clone() Node {
+	cp := *n
+	return &cp
+}
+
+// Clone returns a copy of the node.
+func (n *CodeNode) Clone() Node {
+	cp := *n
+	return &cp
+}
+
+// Clone returns a deep copy of the node.
+func (n *LinkNode) Clone() Node {
+	cp := *n
+	cp.Nodes = cloneNodes(n.Nodes)
+	return &cp
+}
+
+// Clone returns a deep copy of the node. the clone resolves its
+// reference using the same link definitions.
+func (n *RefNode) Clone() Node {
+	cp := *n
+	cp.Nodes = cloneNodes(n.Nodes)
+	return &cp
+}
+
+// Clone returns a copy of the node.
+func (n *DefLinkNode) Clone() Node {
+	cp := *n
+	return &cp
+}
+
+// Clone returns a copy of the node.
+func (n *ImageNode) Clone() Node {
+	cp := *n
+	return &cp
+}
+
+// Clone returns a deep copy of the node.
+func (n *ListNode) Clone() Node {
+	cp := *n
+	cp.Items = nil
+	for _, item := range n.Items {
+		cp.Items = append(cp.Items, item.Clone().(*ListItemNode))
+	}
+	return &cp
+}
+
+// Clone returns a deep copy of the node.
+func (l *ListItemNode) Clone() Node {
+	cp := *l
+	cp.Nodes = cloneNodes(l.Nodes)
+	return &cp
+}
+
+// Clone returns a deep copy of the node.
+func (n *TableNode) Clone() Node {
+	cp := *n
+	cp.Rows = nil
+	for _, row := range n.Rows {
+		cp.Rows = append(cp.Rows, row.Clone().(*RowNode))
+	}
+	return &cp
+}
+
+// Clone returns a deep copy of the node.
+func (n *RowNode) Clone() Node {
+	cp := *n
+	cp.Cells = nil
+	for _, cell := range n.Cells {
+		cp.Cells = append(cp.Cells, cell.Clone().(*CellNode))
+	}
+	return &cp
+}
+
+// Clone returns a deep copy of the node.
+func (c *CellNode) Clone() Node {
+	cp := *c
+	cp.Nodes = cloneNodes(c.Nodes)
+	return &cp
+}
+
+// Clone returns a deep copy of the node.
+func (n *BlockQuoteNode) Clone() Node {
+	cp := *n
+	cp.Nodes = cloneNodes(n.Nodes)
+	return &cp
+}
+
+// Clone returns a copy of the node.
+func (n *CheckboxNode) Clone() Node {
+	cp := *n
+	return &cp
+}
@@ -16,6 +16,7 @@ var (
 	output    = flag.String("o", "", "")
 	smarty    = flag.Bool("smartypants", false, "")
 	fractions = flag.Bool("fractions", false, "")
+	locale    = flag.String("locale", "", "")
 )
 
 var usage = `Usage: mark [options...] <input>
@@ -28,11 +29,12 @@ Options:
   -smartypants  Use "smart" typograhic punctuation for things like 
                 quotes and dashes.
   -fractions    Traslate fraction like to suitable HTML elements
+  -locale       Quotation marks style used by smartypants(e.g: fr, de, ja).
 `
 
 func main() {
 	flag.Usage = func() {
-		fmt.Fprint(os.Stderr, fmt.Sprintf(usage))
+		fmt.Fprint(os.Stderr, usage)
 	}
 	flag.Parse()
 	// read
@@ -77,6 +79,7 @@ func main() {
 	opts := mark.DefaultOptions()
 	opts.Smartypants = *smarty
 	opts.Fractions = *fractions
+	opts.Locale = *locale
 	m := mark.New(data, opts)
 	if _, err := file.WriteString(m.Render()); err != nil {
 		usageAndExit(fmt.Sprintf("error writing output to: %s.", file.Name()))
@@ -85,7 +88,7 @@ func main() {
 
 func usageAndExit(msg string) {
 	if msg != "" {
-		fmt.Fprintf(os.Stderr, msg)
+		fmt.Fprint(os.Stderr, msg)
 		fmt.Fprintf(os.Stderr, "\n\n")
 	}
 	flag.Usage()
@@ -0,0 +1,208 @@
+package mark
+
+import (
+	"regexp"
+	"strings"
+)
+
+// reWords splits text into words and whitespaces, the tokens of inline diffs.
+var reWords = regexp.MustCompile(`\s+|\S+`)
+
+// Diff renders the changes between two markdown inputs as html, e.g: to
+// show the edit history of a wiki page. the blocks that were removed are
+// wrapped with <del>, and the blocks that were added are wrapped with
+// <ins>. a paragraph or a heading that was changed in place is rendered
+// once, with its removed and added words marked inline.
+func Diff(from, to string, opts *Options) string {
+	a, b := New(from, opts), New(to, opts)
+	ra, rb := a.Document(), b.Document()
+	ar, br := a.renderer(), b.renderer()
+	var ablocks, bblocks []Node
+	var akeys, bkeys []string
+	for _, n := range ra.Nodes {
+		if s := ar.render(n); s != "" {
+			ablocks, akeys = append(ablocks, n), append(akeys, s)
+		}
+	}
+	for _, n := range rb.Nodes {
+		if s := br.render(n); s != "" {
+			bblocks, bkeys = append(bblocks, n), append(bkeys, s)
+		}
+	}
+	var out []string
+	ops := diffOps(akeys, bkeys)
+	for i := 0; i < len(ops); {
+		if ops[i].kind == '=' {
+			out = append(out, bkeys[ops[i].b])
+			i++
+			continue
+		}
+		// a run of changes. removed and added blocks of the same kind
+		// are paired, and diffed word by word.
+		var dels, inss []diffOp
+		for ; i < len(ops) && ops[i].kind != '='; i++ {
+			if ops[i].kind == '-' {
+				dels = append(dels, ops[i])
+			} else {
+				inss = append(inss, ops[i])
+			}
+		}
+		for len(dels) > 0 || len(inss) > 0 {
+			switch {
+			case len(dels) > 0 && len(inss) > 0 && diffable(ablocks[dels[0].a], bblocks[inss[0].b]):
+				out = append(out, diffBlock(ar, br, ablocks[dels[0].a], bblocks[inss[0].b]))
+				dels, inss = dels[1:], inss[1:]
+			case len(dels) > 0:
+				out = append(out, wrap("del", akeys[dels[0].a]))
+				dels = dels[1:]
+			default:
+				out = append(out, wrap("ins", bkeys[inss[0].b]))
+				inss = inss[1:]
+			}
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// diffable tests if the change between the given blocks can be marked inline.
+func diffable(a, b Node) bool {
+	switch a := a.(type) {
+	case *ParagraphNode:
+		_, ok := b.(*ParagraphNode)
+		return ok
+	case *HeadingNode:
+		b, ok := b.(*HeadingNode)
+		return ok && a.Level == b.Level
+	}
+	return false
+}
+
+// diffBlock renders the new block with the words that were changed from
+// the old block marked with <del> and <ins>.
+func diffBlock(ar, br *renderer, a, b Node) string {
+	from, to := diffTokens(ar, Children(a)), diffTokens(br, Children(b))
+	var s string
+	ops := diffOps(from, to)
+	for i := 0; i < len(ops); {
+		kind := ops[i].kind
+		var run string
+		for ; i < len(ops) && ops[i].kind == kind; i++ {
+			if kind == '-' {
+				run += from[ops[i].a]
+			} else {
+				run += to[ops[i].b]
+			}
+		}
+		switch kind {
+		case '-':
+			s += wrap("del", run)
+		case '+':
+			s += wrap("ins", run)
+		default:
+			s += run
+		}
+	}
+	switch b := b.(type) {
+	case *ParagraphNode:
+		cp := *b
+		cp.Nodes = []Node{NewRaw(s)}
+		return br.render(&cp)
+	case *HeadingNode:
+		cp := *b
+		cp.Nodes = []Node{NewRaw(s)}
+		return br.render(&cp)
+	}
+	return s
+}
+
+// diffTokens returns the rendered inline nodes. text is split into words,
+// and the other nodes(e.g: links) are single tokens.
+func diffTokens(r *renderer, nodes []Node) (tokens []string) {
+	for _, n := range nodes {
+		if t, ok := n.(*TextNode); ok {
+			tokens = append(tokens, reWords.FindAllString(r.render(t), -1)...)
+		} else {
+			tokens = append(tokens, r.render(n))
+		}
+	}
+	return
+}
+
+// diffOp is an edit operation. kind is '=' for elements that are kept, '-'
+// for removed elements of a, and '+' for added elements of b.
+type diffOp struct {
+	kind byte
+	a, b int
+}
+
+// maxDiffCells bounds the size of the lcs table of diffOps, that is the
+// product of the lengths of its inputs without their common prefix and
+// suffix. larger inputs are diffed as a removal followed by an addition.
+const maxDiffCells = 1 << 20
+
+// diffOps returns the shortest edit script from a to b, that is based on
+// their longest common subsequence. the common prefix and suffix are kept
+// without building the lcs table.
+func diffOps(a, b []string) (ops []diffOp) {
+	pre := 0
+	for ; pre < len(a) && pre < len(b) && a[pre] == b[pre]; pre++ {
+		ops = append(ops, diffOp{'=', pre, pre})
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	n, m := len(a)-pre-suf, len(b)-pre-suf
+	if n > 0 && m > maxDiffCells/n {
+		for i := 0; i < n; i++ {
+			ops = append(ops, diffOp{'-', pre + i, pre})
+		}
+		for j := 0; j < m; j++ {
+			ops = append(ops, diffOp{'+', pre + n, pre + j})
+		}
+	} else {
+		for _, op := range lcsOps(a[pre:pre+n], b[pre:pre+m]) {
+			ops = append(ops, diffOp{op.kind, pre + op.a, pre + op.b})
+		}
+	}
+	for k := 0; k < suf; k++ {
+		ops = append(ops, diffOp{'=', pre + n + k, pre + m + k})
+	}
+	return
+}
+
+// lcsOps returns the shortest edit script from a to b, using the table of
+// the lengths of their longest common subsequences.
+func lcsOps(a, b []string) (ops []diffOp) {
+	// lcs[i][j] is the length of the lcs of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{'=', i, j})
+			i, j = i+1, j+1
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', i, j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', i, j})
+			j++
+		}
+	}
+	return
+}
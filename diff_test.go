@@ -0,0 +1,45 @@
+package mark
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	cases := []struct {
+		from, to string
+		expected string
+	}{
+		{"foo\n\nbar", "foo\n\nbar", "<p>foo</p>\n<p>bar</p>"},
+		{"foo bar baz", "foo qux baz", "<p>foo <del>bar</del><ins>qux</ins> baz</p>"},
+		{"# Title", "# New Title", "<h1 id=\"new-title\"><ins>New </ins>Title</h1>"},
+		{"# Title", "## Title", "<del><h1 id=\"title\">Title</h1></del>\n<ins><h2 id=\"title\">Title</h2></ins>"},
+		{"a\n\nb", "a", "<p>a</p>\n<del><p>b</p></del>"},
+		{"a", "- a", "<del><p>a</p></del>\n<ins><ul>\n<li>a</li>\n</ul></ins>"},
+		{"see [a](/a) now", "see [b](/b) now", "<p>see <del><a href=\"/a\">a</a></del><ins><a href=\"/b\">b</a></ins> now</p>"},
+		{"x\n\n[r]: /r", "x", "<p>x</p>"},
+	}
+	for _, c := range cases {
+		if actual := Diff(c.from, c.to, nil); actual != c.expected {
+			t.Errorf("%q -> %q: got\n\t%+v\nexpected\n\t%+v", c.from, c.to, actual, c.expected)
+		}
+	}
+}
+
+// large inputs are diffed without a quadratic lcs table.
+func TestDiffLarge(t *testing.T) {
+	from, to := strings.Repeat("a ", 20000), strings.Repeat("b ", 20000)
+	start := time.Now()
+	actual := Diff(from, to, nil)
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Diff: took %v", d)
+	}
+	if expected := "<p><del>" + strings.TrimSpace(from) + "</del><ins>" + strings.TrimSpace(to) + "</ins></p>"; actual != expected {
+		t.Errorf("Diff: got\n\t%.60s...\nexpected\n\t%.60s...", actual, expected)
+	}
+	ops := diffOps([]string{"x", "a", "b", "y"}, []string{"x", "c", "y"})
+	if len(ops) != 5 || ops[0].kind != '=' || ops[4] != (diffOp{'=', 3, 2}) {
+		t.Errorf("diffOps: got\n\t%+v", ops)
+	}
+}
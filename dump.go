@@ -0,0 +1,101 @@
+package mark
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Dump writes an indented tree of the document nodes to w. each line
+// contains the node type, its position and a short excerpt of its content.
+// used for debugging extensions and reporting parser bugs.
+func (d *DocumentNode) Dump(w io.Writer) error {
+	for _, n := range d.Nodes {
+		if err := d.dump(w, n, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String returns the dump of the document.
+func (d *DocumentNode) String() string {
+	var b bytes.Buffer
+	d.Dump(&b)
+	return b.String()
+}
+
+func (d *DocumentNode) dump(w io.Writer, n Node, depth int) error {
+	pos := "-"
+	if span, ok := d.spans[n]; ok {
+		pos = span.String()
+	} else if p, ok := n.(interface{ Position() Pos }); ok {
+		pos = fmt.Sprintf("@%d", p.Position())
+	}
+	name := strings.TrimPrefix(fmt.Sprintf("%T", n), "*mark.")
+	line := strings.Repeat("  ", depth) + name + " " + pos
+	if s := excerpt(n); s != "" {
+		line += " " + s
+	}
+	if _, err := fmt.Fprintln(w, line); err != nil {
+		return err
+	}
+	for _, child := range Children(n) {
+		if err := d.dump(w, child, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// excerpt returns a short description of the node content.
+func excerpt(n Node) string {
+	quote := func(s string) string {
+		if r := []rune(s); len(r) > 30 {
+			s = string(r[:30]) + "..."
+		}
+		return fmt.Sprintf("%q", s)
+	}
+	switch n := n.(type) {
+	case *TextNode:
+		return quote(n.Text)
+	case *HTMLNode:
+		return quote(n.Src)
+	case *CustomInlineNode:
+		return quote(n.Match[0])
+	case *RawNode:
+		return quote(n.Text)
+	case *ShortcodeNode:
+		return fmt.Sprintf("name=%q", n.Name)
+	case *HeadingNode:
+		return fmt.Sprintf("level=%d", n.Level)
+	case *CodeNode:
+		return fmt.Sprintf("lang=%q %s", n.Lang, quote(n.Text))
+	case *EmphasisNode:
+		return "tag=" + n.Tag()
+	case *LinkNode:
+		return fmt.Sprintf("href=%q", n.Href)
+	case *ImageNode:
+		return fmt.Sprintf("src=%q", n.Src)
+	case *RefNode:
+		return fmt.Sprintf("ref=%q", n.Ref)
+	case *DefLinkNode:
+		return fmt.Sprintf("name=%q href=%q", n.Name, n.Href)
+	case *ListNode:
+		return fmt.Sprintf("ordered=%v", n.Ordered)
+	case *EmojiNode:
+		return fmt.Sprintf("name=%q src=%q", n.Name, n.Src)
+	case *RubyNode:
+		return "rt=" + quote(n.Text)
+	case *BlockQuoteNode:
+		if n.Alert != "" {
+			return fmt.Sprintf("alert=%q", n.Alert)
+		}
+	case *TabNode:
+		return quote(n.Title)
+	case *CheckboxNode:
+		return fmt.Sprintf("checked=%v", n.Checked)
+	}
+	return ""
+}
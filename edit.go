@@ -0,0 +1,184 @@
+package mark
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Edit replaces the bytes of the input from Start to End(exclusive) with Text.
+type Edit struct {
+	Start, End int
+	Text       string
+}
+
+// Apply applies the given edits to the input, and updates the parsed
+// document, e.g: for refactoring tools that rename a reference label or
+// renumber a list. the edits are offsets in the current input(Mark.Input),
+// and they must not overlap. only the top-level blocks that were touched
+// by the edits, and their neighbors, are parsed again. the rest of the
+// nodes are reused, and their positions are shifted.
+func (m *Mark) Apply(edits ...Edit) error {
+	if err := m.parseOnce(context.Background()); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fromDoc {
+		return fmt.Errorf("mark: can't apply edits to a document that wasn't parsed from its input")
+	}
+	edits = append([]Edit(nil), edits...)
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].Start < edits[j].Start })
+	for i, e := range edits {
+		switch {
+		case e.Start < 0 || e.End < e.Start || e.End > len(m.Input):
+			return fmt.Errorf("mark: edit [%d:%d] is out of range", e.Start, e.End)
+		case i > 0 && e.Start < edits[i-1].End:
+			return fmt.Errorf("mark: edit [%d:%d] overlaps edit [%d:%d]", e.Start, e.End, edits[i-1].Start, edits[i-1].End)
+		}
+	}
+	if len(edits) == 0 {
+		return nil
+	}
+	nodes := m.Nodes
+	lo, hi := edits[0].Start, edits[len(edits)-1].End
+	// the touched blocks are nodes[i:j]. the previous block is parsed again
+	// as well(e.g: a paragraph followed by a new "===" line becomes a
+	// heading), and so is the next one, to check that the edits didn't
+	// change the blocks that follow(e.g: an unclosed fence).
+	i := sort.Search(len(nodes), func(k int) bool { return int(position(nodes[k])) > lo }) - 2
+	if i < 0 {
+		i = 0
+	}
+	j := sort.Search(len(nodes), func(k int) bool { return int(position(nodes[k])) > hi }) + 1
+	if j > len(nodes) {
+		j = len(nodes)
+	}
+	if !m.reparse(edits, i, j) {
+		m.reparse(edits, i, len(nodes))
+	}
+	m.mappings = nil
+	return nil
+}
+
+// reparse applies the edits to the input of the blocks nodes[i:j], and
+// replaces them with the result of parsing it again. it reports false,
+// and keeps the document as is, if the last block changed, that means
+// that the next blocks may have changed as well.
+func (m *Mark) reparse(edits []Edit, i, j int) bool {
+	nodes, old := m.Nodes, m.Input
+	start, end := 0, len(old)
+	if i > 0 {
+		start = int(position(nodes[i]))
+	}
+	if j < len(nodes) {
+		end = int(position(nodes[j]))
+	}
+	var b strings.Builder
+	last := start
+	for _, e := range edits {
+		b.WriteString(old[last:e.Start] + e.Text)
+		last = e.End
+	}
+	b.WriteString(old[last:end])
+	region := indentTabs(b.String())
+	delta := len(region) - (end - start)
+	m.Input = old[:start] + region + old[end:]
+	m.parse.input = m.Input
+	tr := m.newSubParse(region, Pos(start))
+	tr.depth = m.depth
+	tr.parse()
+	for _, n := range tr.Nodes {
+		shiftPosition(n, Pos(start))
+	}
+	if n := len(tr.Nodes); j < len(nodes) && j > 0 && (n == 0 || tr.Nodes[n-1].Type() != nodes[j-1].Type() ||
+		position(tr.Nodes[n-1]) != position(nodes[j-1])+Pos(delta)) {
+		m.dropSpans(tr.Nodes)
+		m.Input, m.parse.input = old, old
+		return false
+	}
+	m.dropSpans(nodes[i:j])
+	// the blocks that follow are shifted
+	lines := strings.Count(region, "\n") - strings.Count(old[start:end], "\n")
+	for _, n := range nodes[j:] {
+		shiftPosition(n, Pos(delta))
+		Walk(n, func(n Node) bool {
+			if span, ok := m.spans[n]; ok {
+				span.StartLine += lines
+				span.EndLine += lines
+				m.spans[n] = span
+			}
+			return true
+		})
+	}
+	m.Nodes = append(append(append([]Node(nil), nodes[:i]...), tr.Nodes...), nodes[j:]...)
+	// the link definitions are collected again, the first one wins. the
+	// map is replaced, since it may be shared with a returned Document.
+	m.links = make(map[string]*DefLinkNode)
+	for _, n := range Selection(m.Nodes).Select(NodeDefLink) {
+		l := n.(*DefLinkNode)
+		if _, ok := m.links[l.Name]; !ok {
+			m.links[l.Name] = l
+		}
+	}
+	return true
+}
+
+// dropSpans removes the source positions of the given nodes and their descendants.
+func (m *Mark) dropSpans(nodes []Node) {
+	for _, n := range nodes {
+		Walk(n, func(n Node) bool {
+			delete(m.spans, n)
+			return true
+		})
+	}
+}
+
+// position returns the position of the given node.
+func position(n Node) Pos {
+	if p, ok := n.(interface{ Position() Pos }); ok {
+		return p.Position()
+	}
+	return 0
+}
+
+// shiftPosition adds delta to the position of the given block node, and
+// to the positions of its descendants that were parsed from the same input:
+// list items and their checkboxes, table rows, cells and the extra cells
+// that are kept as literal text. the positions of the other descendants
+// are relative to their parent block.
+func shiftPosition(n Node, delta Pos) {
+	setPosition(n, position(n)+delta)
+	switch n := n.(type) {
+	case *ListNode:
+		for _, item := range n.Items {
+			item.Pos += delta
+			if len(item.Nodes) > 0 {
+				if c, ok := item.Nodes[0].(*CheckboxNode); ok {
+					c.Pos += delta
+				}
+			}
+		}
+	case *TableNode:
+		for _, row := range n.Rows {
+			row.Pos += delta
+			for _, cell := range row.Cells {
+				cell.Pos += delta
+				for _, node := range cell.Nodes {
+					if l, ok := node.(*LiteralNode); ok {
+						l.Pos += delta
+					}
+				}
+			}
+		}
+	}
+}
+
+// setPosition sets the position of the given node, if it has one.
+func setPosition(n Node, pos Pos) {
+	if v := reflect.ValueOf(n).Elem().FieldByName("Pos"); v.IsValid() && v.CanSet() {
+		v.SetInt(int64(pos))
+	}
+}
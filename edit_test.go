@@ -0,0 +1,96 @@
+package mark
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	input := "# Title\n\nfoo [a][ref] bar\n\n- one\n- two\n\n> quote\n\nlast paragraph\n\n[ref]: /old\n"
+	cases := []struct {
+		name  string
+		edits []Edit
+	}{
+		{"replace a word", []Edit{{22, 25, "baz"}}},
+		{"rename a reference", []Edit{{17, 20, "new"}, {66, 69, "new"}}},
+		{"change a definition", []Edit{{72, 76, "/new"}}},
+		{"insert at the start", []Edit{{0, 0, "intro\n\n"}}},
+		{"append at the end", []Edit{{len(input), len(input), "\nmore *text*"}}},
+		{"setext heading", []Edit{{26, 26, "===\n"}}},
+		{"join paragraphs", []Edit{{47, 49, "\n"}}},
+		{"unclosed fence", []Edit{{9, 9, "```\n"}}},
+		{"delete everything", []Edit{{0, len(input), ""}}},
+		{"renumber a list", []Edit{{27, 28, "1."}, {33, 34, "2."}}},
+	}
+	for _, c := range cases {
+		opts := &Options{SourcePos: true, Gfm: true}
+		m := New(input, opts)
+		m.Render()
+		if err := m.Apply(c.edits...); err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+		var b strings.Builder
+		last := 0
+		for _, e := range c.edits {
+			b.WriteString(input[last:e.Start] + e.Text)
+			last = e.End
+		}
+		b.WriteString(input[last:])
+		if m.Input != b.String() {
+			t.Errorf("%s: got input\n\t%q\nexpected\n\t%q", c.name, m.Input, b.String())
+		}
+		if actual, expected := m.Render(), New(b.String(), opts).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", c.name, actual, expected)
+		}
+	}
+}
+
+func TestApplyPositions(t *testing.T) {
+	input := "# Title\n\nfoo\n\n- [ ] one\n- two\n\n| a |\n|---|\n| b |\n"
+	m := New(input, &Options{Gfm: true, Tables: true})
+	m.Render()
+	if err := m.Apply(Edit{2, 2, "New "}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var actual, expected []Pos
+	for i, nodes := range [][]Node{m.Nodes, New(m.Input, &Options{Gfm: true, Tables: true}).Document().Nodes} {
+		for _, n := range nodes {
+			Walk(n, func(n Node) bool {
+				if i == 0 {
+					actual = append(actual, position(n))
+				} else {
+					expected = append(expected, position(n))
+				}
+				return true
+			})
+		}
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("positions: got\n\t%v\nexpected\n\t%v", actual, expected)
+	}
+	// a returned Document keeps its links
+	m = New("[a]\n\n[a]: /a", nil)
+	doc := m.Document()
+	if err := m.Apply(Edit{6, 7, "b"}); err != nil || doc.Links["a"] == nil || m.Document().Links["b"] == nil {
+		t.Errorf("links: got %v and %v(%v)", doc.Links, m.Document().Links, err)
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	m := New("foo bar", nil)
+	for _, edits := range [][]Edit{
+		{{-1, 2, ""}},
+		{{2, 1, ""}},
+		{{0, 8, ""}},
+		{{0, 3, "a"}, {2, 4, "b"}},
+	} {
+		if err := m.Apply(edits...); err == nil {
+			t.Errorf("%+v: expected an error", edits)
+		}
+	}
+	if m.Input != "foo bar" {
+		t.Errorf("Input: got %q, expected the input as is after an error", m.Input)
+	}
+}
@@ -0,0 +1,64 @@
+package mark
+
+// EventKind identifies the kind of an event.
+type EventKind int
+
+// Event kinds
+const (
+	EventStart EventKind = iota // Start of a node that holds children(paragraph, list, etc.)
+	EventEnd                    // End of a node that holds children
+	EventLeaf                   // A node without children(text, code-block, hr, etc.)
+)
+
+// Event is emitted for each node while walking a document.
+type Event struct {
+	Kind EventKind
+	Node Node
+}
+
+// Events parses the input and returns a function that streams its events in
+// document order, stopping when yield returns false. the input is parsed one
+// block at a time, so the whole tree is never held in memory.
+// it can be used as a range-over-func iterator, e.g:
+//
+//	for e := range mark.Events(input, nil) {
+//		...
+//	}
+//
+// Note: reference links are resolved only against the link definitions
+// that were already seen.
+func Events(input string, opts *Options) func(yield func(Event) bool) {
+	return func(yield func(Event) bool) {
+		p := New(input, opts).parse
+		for n := p.parseBlock(); n != nil; n = p.parseBlock() {
+			if !walkEvents(n, yield) {
+				return
+			}
+		}
+	}
+}
+
+// walkEvents emits the events of the given node and its children.
+// it returns false if the walking was stopped.
+func walkEvents(n Node, yield func(Event) bool) bool {
+	// html blocks are containers only if their content was parsed
+	if h, ok := n.(*HTMLNode); ok && h.Open == "" {
+		return yield(Event{EventLeaf, n})
+	}
+	switch n.(type) {
+	case *ParagraphNode, *EmphasisNode, *HeadingNode, *LinkNode, *RefNode, *ListNode,
+		*ListItemNode, *TableNode, *RowNode, *CellNode, *BlockQuoteNode, *RubyNode, *DetailsNode,
+		*DivNode, *TabsNode, *TabNode, *ShortcodeNode, *DocumentNode, *HTMLNode:
+		if !yield(Event{EventStart, n}) {
+			return false
+		}
+		for _, child := range Children(n) {
+			if !walkEvents(child, yield) {
+				return false
+			}
+		}
+		return yield(Event{EventEnd, n})
+	default:
+		return yield(Event{EventLeaf, n})
+	}
+}
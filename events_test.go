@@ -0,0 +1,42 @@
+package mark
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	var events []string
+	Events("# hi\n\nfoo __bar__\n\n- a", nil)(func(e Event) bool {
+		var kind string
+		switch e.Kind {
+		case EventStart:
+			kind = "start"
+		case EventEnd:
+			kind = "end"
+		default:
+			kind = "leaf"
+		}
+		events = append(events, kind+":"+reflect.TypeOf(e.Node).Elem().Name())
+		return true
+	})
+	expected := []string{
+		"start:HeadingNode", "leaf:TextNode", "end:HeadingNode",
+		"start:ParagraphNode", "leaf:TextNode", "start:EmphasisNode", "leaf:TextNode", "end:EmphasisNode", "end:ParagraphNode",
+		"start:ListNode", "start:ListItemNode", "leaf:TextNode", "end:ListItemNode", "end:ListNode",
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Events: got\n\t%+v\nexpected\n\t%+v", events, expected)
+	}
+}
+
+func TestEventsStop(t *testing.T) {
+	var count int
+	Events("foo\n\nbar\n\nbaz", nil)(func(e Event) bool {
+		count++
+		return count < 2
+	})
+	if count != 2 {
+		t.Errorf("Events: got %d events after stopping, expected 2", count)
+	}
+}
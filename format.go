@@ -0,0 +1,293 @@
+package mark
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// outElement is an element of the rendered output, that is re-printed by
+// the output formatters(e.g: Options.Indent). its tags are kept as is.
+type outElement struct {
+	name        string
+	open, close string
+	children    []interface{} // *outElement or string
+}
+
+// Elements that are formatted as blocks, elements without content, and
+// elements whose content is kept as is.
+var (
+	outBlockTags = map[string]bool{
+		"address": true, "article": true, "aside": true, "blockquote": true, "caption": true, "dd": true,
+		"details": true, "dialog": true, "div": true, "dl": true, "dt": true, "fieldset": true,
+		"figcaption": true, "figure": true, "footer": true, "form": true, "h1": true, "h2": true,
+		"h3": true, "h4": true, "h5": true, "h6": true, "header": true, "hr": true, "li": true,
+		"main": true, "nav": true, "ol": true, "p": true, "pre": true, "section": true, "summary": true,
+		"table": true, "tbody": true, "td": true, "tfoot": true, "th": true, "thead": true, "tr": true,
+		"ul": true,
+	}
+	outVoidTags = map[string]bool{
+		"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+		"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+	}
+	outRawTags = map[string]bool{"pre": true, "script": true, "style": true, "textarea": true}
+)
+
+// reOutTag matches an opening or a closing tag of the rendered output.
+var reOutTag = regexp.MustCompile(`<(/?)([a-zA-Z][\w-]*)((?:"[^"]*"|'[^']*'|[^'">])*)>`)
+
+// parseOutput returns the element tree of the rendered output. unmatched
+// closing tags are kept as text, so printing the tree as is returns the
+// same output.
+func parseOutput(s string) *outElement {
+	root := &outElement{}
+	stack := []*outElement{root}
+	for s != "" {
+		top := stack[len(stack)-1]
+		loc := reOutTag.FindStringSubmatchIndex(s)
+		if loc == nil {
+			top.children = append(top.children, s)
+			break
+		}
+		if loc[0] > 0 {
+			top.children = append(top.children, s[:loc[0]])
+		}
+		tag, name := s[loc[0]:loc[1]], strings.ToLower(s[loc[4]:loc[5]])
+		s = s[loc[1]:]
+		switch {
+		case loc[3] > loc[2]:
+			i := len(stack) - 1
+			for i > 0 && stack[i].name != name {
+				i--
+			}
+			if i == 0 {
+				top.children = append(top.children, tag)
+				continue
+			}
+			stack[i].close, stack = tag, stack[:i]
+		case outVoidTags[name] || strings.HasSuffix(tag, "/>"):
+			top.children = append(top.children, &outElement{name: name, open: tag})
+		default:
+			el := &outElement{name: name, open: tag}
+			top.children = append(top.children, el)
+			stack = append(stack, el)
+			// the content of raw elements is a single text node
+			if outRawTags[name] {
+				end := strings.Index(strings.ToLower(s), "</"+name)
+				if end == -1 {
+					end = len(s)
+				}
+				if end > 0 {
+					el.children = append(el.children, s[:end])
+				}
+				s = s[end:]
+			}
+		}
+	}
+	return root
+}
+
+// String returns the element as is.
+func (el *outElement) String() string {
+	var b strings.Builder
+	el.write(&b)
+	return b.String()
+}
+
+func (el *outElement) write(b *strings.Builder) {
+	b.WriteString(el.open)
+	for _, child := range el.children {
+		writeOutput(b, child)
+	}
+	b.WriteString(el.close)
+}
+
+// writeOutput writes a child of the element tree as is.
+func writeOutput(b *strings.Builder, child interface{}) {
+	if el, ok := child.(*outElement); ok {
+		el.write(b)
+	} else {
+		b.WriteString(child.(string))
+	}
+}
+
+// isOutBlock tests if the given child is a block element.
+func isOutBlock(child interface{}) bool {
+	el, ok := child.(*outElement)
+	return ok && outBlockTags[el.name]
+}
+
+// hasBlock tests if the element contains a block element.
+func (el *outElement) hasBlock() bool {
+	for _, child := range el.children {
+		if isOutBlock(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// indentOutput re-prints the rendered output with each block element on
+// its own line. the content of blocks that contain other blocks is indented
+// with the given indent, and the content of the other blocks is kept as is.
+func indentOutput(s, indent string) string {
+	var b strings.Builder
+	writeIndented(&b, parseOutput(s).children, 0, indent)
+	return b.String()
+}
+
+func writeIndented(b *strings.Builder, children []interface{}, depth int, indent string) {
+	line := func(s string) {
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(strings.Repeat(indent, depth) + s)
+	}
+	var inline strings.Builder
+	flush := func() {
+		// whitespaces around blocks aren't rendered
+		if s := strings.TrimSpace(inline.String()); s != "" {
+			line(s)
+		}
+		inline.Reset()
+	}
+	for _, child := range children {
+		if !isOutBlock(child) {
+			writeOutput(&inline, child)
+			continue
+		}
+		flush()
+		el := child.(*outElement)
+		if outRawTags[el.name] || !el.hasBlock() {
+			line(el.String())
+			continue
+		}
+		line(el.open)
+		writeIndented(b, el.children, depth+1, indent)
+		if el.close != "" {
+			line(el.close)
+		}
+	}
+	flush()
+}
+
+// compactOutput removes the whitespaces around block elements, that aren't
+// rendered. the content of raw elements(e.g: <pre>) is kept as is. block
+// reports whether the output ends with a block element.
+func compactOutput(s string) (out string, block bool) {
+	root := parseOutput(s)
+	compactChildren(root, true)
+	if n := len(root.children); n > 0 {
+		block = isOutBlock(root.children[n-1])
+	}
+	return root.String(), block
+}
+
+// compactChildren removes the whitespaces around the block children of
+// the element, and at the edges of block elements.
+func compactChildren(el *outElement, block bool) {
+	if outRawTags[el.name] {
+		return
+	}
+	var children []interface{}
+	for i, child := range el.children {
+		if c, ok := child.(*outElement); ok {
+			compactChildren(c, outBlockTags[c.name])
+			children = append(children, c)
+			continue
+		}
+		s := child.(string)
+		if i == 0 && block || i > 0 && isOutBlock(el.children[i-1]) {
+			s = strings.TrimLeft(s, " \t\r\n\f")
+		}
+		if i == len(el.children)-1 && block || i < len(el.children)-1 && isOutBlock(el.children[i+1]) {
+			s = strings.TrimRight(s, " \t\r\n\f")
+		}
+		if s != "" {
+			children = append(children, s)
+		}
+	}
+	el.children = children
+}
+
+// reEntityAny matches a named or a numeric character reference.
+var reEntityAny = regexp.MustCompile(`&(?:#[0-9]+|#[xX][0-9a-fA-F]+|[a-zA-Z][a-zA-Z0-9]*);`)
+
+// entityOutput converts the non-ASCII characters of the rendered output to
+// the given style. the content of <script> and <style> elements, where
+// entities aren't decoded, is kept as is.
+func entityOutput(s string, style EntityStyle) string {
+	convert := asciiEntities
+	switch style {
+	case EntitiesUTF8:
+		convert = utf8Entities
+	case EntitiesXML:
+		convert = xmlEntities
+	}
+	var walk func(el *outElement)
+	walk = func(el *outElement) {
+		el.open, el.close = convert(el.open), convert(el.close)
+		if el.name == "script" || el.name == "style" {
+			return
+		}
+		for i, child := range el.children {
+			if c, ok := child.(*outElement); ok {
+				walk(c)
+			} else {
+				el.children[i] = convert(child.(string))
+			}
+		}
+	}
+	root := parseOutput(s)
+	walk(root)
+	return root.String()
+}
+
+// asciiEntities replaces the non-ASCII characters of s with numeric entities.
+func asciiEntities(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			b.WriteRune(r)
+		} else {
+			fmt.Fprintf(&b, "&#%d;", r)
+		}
+	}
+	return b.String()
+}
+
+// xmlEntities replaces the named entities of s that XML doesn't define
+// with numeric entities. unknown names are escaped.
+func xmlEntities(s string) string {
+	return reEntityAny.ReplaceAllStringFunc(s, func(e string) string {
+		switch {
+		case e[1] == '#', e == "&amp;", e == "&lt;", e == "&gt;", e == "&quot;", e == "&apos;":
+			return e
+		}
+		d := html.UnescapeString(e)
+		if d == e {
+			return "&amp;" + e[1:]
+		}
+		var b strings.Builder
+		for _, r := range d {
+			fmt.Fprintf(&b, "&#%d;", r)
+		}
+		return b.String()
+	})
+}
+
+// utf8Entities decodes the entities of non-ASCII characters in s. the
+// entities of ASCII characters(e.g: &amp;) are kept.
+func utf8Entities(s string) string {
+	return reEntityAny.ReplaceAllStringFunc(s, func(e string) string {
+		d := html.UnescapeString(e)
+		for _, r := range d {
+			if r < utf8.RuneSelf {
+				return e
+			}
+		}
+		return d
+	})
+}
@@ -0,0 +1,63 @@
+package mark
+
+import "testing"
+
+func TestIndent(t *testing.T) {
+	cases := map[string]string{
+		"# Title\n\npara *em*\nline":                     "<h1 id=\"title\">Title</h1>\n<p>para <em>em</em>\nline</p>",
+		"- a\n- b\n  - c\n  - d":                         "<ul>\n  <li>a</li>\n  <li>\n    b\n    <ul>\n      <li>c</li>\n      <li>d</li>\n    </ul>\n  </li>\n</ul>",
+		"> quote\n>\n> ```\n> code\n>   indented\n> ```": "<blockquote>\n  <p>quote</p>\n  <pre><code>\ncode\n  indented\n</code></pre>\n</blockquote>",
+		"| a | b |\n|---|---|\n| 1 | 2 |":                "<table>\n  <thead>\n    <tr>\n      <th>a</th>\n      <th>b</th>\n    </tr>\n  </thead>\n  <tbody>\n    <tr>\n      <td>1</td>\n      <td>2</td>\n    </tr>\n  </tbody>\n</table>",
+		"<div>\n<p>raw</p></div>":                        "<div>\n  <p>raw</p>\n</div>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, &Options{Indent: "  "}).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+}
+
+func TestParseOutput(t *testing.T) {
+	for _, s := range []string{
+		"<p>a</p>\n<ul><li>b<br>c</li></ul>",
+		"</div>text<p>unclosed <em>x",
+		"<pre><code><p>not a tag</p></code></pre><p a=\"x>y\">z</p>",
+		"<!-- comment --><hr/><img src=\"a\" />",
+	} {
+		if actual := parseOutput(s).String(); actual != s {
+			t.Errorf("%s: got\n\t%+v", s, actual)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	cases := map[string]string{
+		"# Title\n\npara *em*\nline":                "<h1 id=\"title\">Title</h1><p>para <em>em</em>\nline</p>",
+		"- a\n- b\n  - c\n\n> quote":                "<ul><li>a</li><li>b<ul><li>c</li></ul></li></ul><blockquote><p>quote</p></blockquote>",
+		"```\n  code\n\n```\n\n| a |\n|---|\n| 1 |": "<pre><code>\n  code\n\n</code></pre><table><thead><tr><th>a</th></tr></thead><tbody><tr><td>1</td></tr></tbody></table>",
+		"<!-- a -->\n\n<!-- b -->\n\nc":             "<!-- a -->\n<!-- b -->\n<p>c</p>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, &Options{Compact: true}).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+}
+
+func TestEntities(t *testing.T) {
+	input := "# Café\n\n\"Crème\" &eacute; &amp; &copy; ![naïve](ü.png)"
+	cases := map[EntityStyle]string{
+		EntitiesAsIs:  "<h1 id=\"caf-\">Café</h1>\n<p>“Crème” &eacute; &amp; &copy; <img src=\"ü.png\" alt=\"naïve\"></p>",
+		EntitiesASCII: "<h1 id=\"caf-\">Caf&#233;</h1>\n<p>&#8220;Cr&#232;me&#8221; &eacute; &amp; &copy; <img src=\"&#252;.png\" alt=\"na&#239;ve\"></p>",
+		EntitiesUTF8:  "<h1 id=\"caf-\">Café</h1>\n<p>“Crème” é &amp; © <img src=\"ü.png\" alt=\"naïve\"></p>",
+		EntitiesXML:   "<h1 id=\"caf-\">Café</h1>\n<p>“Crème” &#233; &amp; &#169; <img src=\"ü.png\" alt=\"naïve\"></p>",
+	}
+	for style, expected := range cases {
+		if actual := New(input, &Options{Entities: style, Smartypants: true}).Render(); actual != expected {
+			t.Errorf("Entities(%d): got\n\t%+v\nexpected\n\t%+v", style, actual, expected)
+		}
+	}
+	if actual := entityOutput("<script>var s = \"é\"</script>", EntitiesASCII); actual != "<script>var s = \"é\"</script>" {
+		t.Errorf("script: got\n\t%+v", actual)
+	}
+}
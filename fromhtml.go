@@ -0,0 +1,293 @@
+package mark
+
+import (
+	"encoding/xml"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// htmlElement is an element of the tree that FromHTML builds.
+type htmlElement struct {
+	name     string
+	attrs    map[string]string
+	children []interface{} // *htmlElement or string
+}
+
+// FromHTML parses a constrained subset of HTML(paragraphs, headings, lists,
+// tables, blockquotes, code blocks, links, images and emphasis) and returns
+// it as a document, that can be converted to markdown using its Markdown
+// method. unknown elements are replaced with their content, and scripts,
+// styles and comments are dropped. unclosed elements are closed by the
+// end tag of their parent.
+func FromHTML(src string) (*DocumentNode, error) {
+	root, err := parseHTML(src)
+	if err != nil {
+		return nil, err
+	}
+	return NewDocument(htmlBlocks(root.children)...), nil
+}
+
+// parseHTML returns the element tree of the given html.
+func parseHTML(src string) (*htmlElement, error) {
+	d := xml.NewDecoder(strings.NewReader(src))
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+	root := &htmlElement{}
+	stack := []*htmlElement{root}
+	for {
+		tok, err := d.Token()
+		// unclosed elements at the end of the input are closed
+		if e, ok := err.(*xml.SyntaxError); err == io.EOF || ok && e.Msg == "unexpected EOF" {
+			return root, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		top := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			el := &htmlElement{name: strings.ToLower(t.Name.Local), attrs: make(map[string]string)}
+			for _, attr := range t.Attr {
+				el.attrs[strings.ToLower(attr.Name.Local)] = attr.Value
+			}
+			top.children = append(top.children, el)
+			stack = append(stack, el)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			top.children = append(top.children, string(t))
+		}
+	}
+}
+
+// Elements that hold blocks, and elements that are dropped with their content.
+var (
+	htmlBlockTags = map[string]bool{
+		"p": true, "div": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+		"ul": true, "ol": true, "li": true, "blockquote": true, "pre": true, "hr": true, "table": true,
+		"section": true, "article": true, "header": true, "footer": true, "main": true, "nav": true,
+		"aside": true, "figure": true, "body": true, "html": true,
+	}
+	htmlDroppedTags = map[string]bool{"head": true, "script": true, "style": true, "title": true, "template": true}
+)
+
+// isHTMLBlock tests if the given child is a block element.
+func isHTMLBlock(child interface{}) bool {
+	el, ok := child.(*htmlElement)
+	return ok && htmlBlockTags[el.name]
+}
+
+// hasHTMLBlock tests if the given children contain a block element.
+func hasHTMLBlock(children []interface{}) bool {
+	for _, child := range children {
+		if isHTMLBlock(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// htmlBlocks converts the given children to block nodes. runs of inline
+// children are wrapped with a paragraph.
+func htmlBlocks(children []interface{}) (nodes []Node) {
+	var inline []interface{}
+	flush := func() {
+		if n := htmlInline(inline); len(n) > 0 {
+			nodes = append(nodes, NewParagraph(n...))
+		}
+		inline = nil
+	}
+	for _, child := range children {
+		if !isHTMLBlock(child) {
+			inline = append(inline, child)
+			continue
+		}
+		flush()
+		nodes = append(nodes, htmlBlock(child.(*htmlElement))...)
+	}
+	flush()
+	return
+}
+
+// htmlBlock converts a block element.
+func htmlBlock(el *htmlElement) []Node {
+	switch el.name {
+	case "p":
+		if n := htmlInline(el.children); len(n) > 0 {
+			return []Node{NewParagraph(n...)}
+		}
+		return nil
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level, _ := strconv.Atoi(el.name[1:])
+		return []Node{NewHeading(level, htmlInline(el.children)...)}
+	case "hr":
+		return []Node{NewHr()}
+	case "pre":
+		var lang string
+		for _, child := range el.children {
+			if c, ok := child.(*htmlElement); ok && c.name == "code" {
+				for _, class := range strings.Fields(c.attrs["class"]) {
+					if strings.HasPrefix(class, "language-") || strings.HasPrefix(class, "lang-") {
+						lang = class[strings.Index(class, "-")+1:]
+					}
+				}
+			}
+		}
+		return []Node{NewCode(lang, strings.TrimSuffix(htmlText(el), "\n"))}
+	case "blockquote":
+		return []Node{NewBlockQuote(htmlBlocks(el.children)...)}
+	case "ul", "ol":
+		list := NewList(el.name == "ol")
+		if start, err := strconv.Atoi(el.attrs["start"]); err == nil {
+			list.Start, list.HasStart = start, true
+		}
+		for _, child := range el.children {
+			if c, ok := child.(*htmlElement); ok && c.name == "li" {
+				list.Items = append(list.Items, htmlListItem(c))
+			}
+		}
+		return []Node{list}
+	case "li":
+		return []Node{NewList(false, htmlListItem(el))}
+	case "table":
+		table := NewTable()
+		htmlRows(el, table)
+		if len(table.Rows) == 0 {
+			return nil
+		}
+		return []Node{table}
+	default:
+		return htmlBlocks(el.children)
+	}
+}
+
+// htmlListItem converts a list item. the content of items without block
+// elements is added as is(tight item).
+func htmlListItem(el *htmlElement) *ListItemNode {
+	item := NewListItem()
+	children := el.children
+	for i, child := range children {
+		if c, ok := child.(*htmlElement); ok && c.name == "input" && c.attrs["type"] == "checkbox" {
+			_, checked := c.attrs["checked"]
+			item.Nodes = append(item.Nodes, NewCheckbox(checked))
+			children = append(children[:i:i], children[i+1:]...)
+			break
+		}
+	}
+	if hasHTMLBlock(children) {
+		item.Nodes = append(item.Nodes, htmlBlocks(children)...)
+	} else {
+		item.Nodes = append(item.Nodes, htmlInline(children)...)
+	}
+	return item
+}
+
+// reTextAlign matches the text-align property of style attribute.
+var reTextAlign = regexp.MustCompile(`text-align\s*:\s*(left|right|center)`)
+
+// htmlRows appends the rows of the given table element(and its sections) to table.
+func htmlRows(el *htmlElement, table *TableNode) {
+	for _, child := range el.children {
+		c, ok := child.(*htmlElement)
+		if !ok {
+			continue
+		}
+		if c.name != "tr" {
+			htmlRows(c, table)
+			continue
+		}
+		row := NewRow()
+		for _, child := range c.children {
+			cell, ok := child.(*htmlElement)
+			if !ok || cell.name != "th" && cell.name != "td" {
+				continue
+			}
+			kind := Data
+			if cell.name == "th" || len(table.Rows) == 0 {
+				kind = Header
+			}
+			align := cell.attrs["align"]
+			if m := reTextAlign.FindStringSubmatch(cell.attrs["style"]); m != nil {
+				align = m[1]
+			}
+			alignType := map[string]AlignType{"left": Left, "right": Right, "center": Center}[strings.ToLower(align)]
+			row.Cells = append(row.Cells, NewCell(kind, alignType, htmlInline(cell.children)...))
+		}
+		table.Rows = append(table.Rows, row)
+	}
+}
+
+// reSpaces matches a run of whitespaces.
+var reSpaces = regexp.MustCompile(`\s+`)
+
+// htmlInline converts the given children to inline nodes. whitespaces are
+// collapsed, and trimmed at the edges and after line breaks.
+func htmlInline(children []interface{}) []Node {
+	nodes := htmlInlineNodes(children)
+	for i, n := range nodes {
+		if t, ok := n.(*TextNode); ok && (i == 0 || nodes[i-1].Type() == NodeBr) {
+			t.Text = strings.TrimLeft(t.Text, " ")
+		}
+	}
+	if len(nodes) > 0 {
+		if t, ok := nodes[len(nodes)-1].(*TextNode); ok {
+			t.Text = strings.TrimRight(t.Text, " ")
+		}
+	}
+	var res []Node
+	for _, n := range nodes {
+		if t, ok := n.(*TextNode); !ok || t.Text != "" {
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
+// htmlInlineNodes converts the given children to inline nodes, as is.
+func htmlInlineNodes(children []interface{}) (nodes []Node) {
+	for _, child := range children {
+		el, ok := child.(*htmlElement)
+		if !ok {
+			nodes = append(nodes, NewText(reSpaces.ReplaceAllString(child.(string), " ")))
+			continue
+		}
+		switch el.name {
+		case "strong", "b":
+			nodes = append(nodes, NewStrong(htmlInlineNodes(el.children)...))
+		case "em", "i":
+			nodes = append(nodes, NewItalic(htmlInlineNodes(el.children)...))
+		case "s", "del", "strike":
+			nodes = append(nodes, NewStrike(htmlInlineNodes(el.children)...))
+		case "code":
+			nodes = append(nodes, NewInlineCode(htmlText(el)))
+		case "a":
+			nodes = append(nodes, NewLink(el.attrs["href"], el.attrs["title"], htmlInlineNodes(el.children)...))
+		case "img":
+			nodes = append(nodes, NewImage(el.attrs["src"], el.attrs["title"], el.attrs["alt"]))
+		case "br":
+			nodes = append(nodes, NewBr())
+		default:
+			if !htmlDroppedTags[el.name] {
+				nodes = append(nodes, htmlInlineNodes(el.children)...)
+			}
+		}
+	}
+	return
+}
+
+// htmlText returns the text content of the element, as is.
+func htmlText(el *htmlElement) (s string) {
+	for _, child := range el.children {
+		if c, ok := child.(*htmlElement); ok {
+			s += htmlText(c)
+		} else {
+			s += child.(string)
+		}
+	}
+	return
+}
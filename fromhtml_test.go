@@ -0,0 +1,28 @@
+package mark
+
+import "testing"
+
+func TestFromHTML(t *testing.T) {
+	cases := map[string]string{
+		"<h1>Title</h1>\n<p>foo <b>bar</b> <em>baz</em> <del>qux</del></p>":                                                                                   "# Title\n\nfoo **bar** *baz* ~~qux~~",
+		"<p>a <a href=\"/x\" title=\"t\">link</a><br>\n<img src=\"/i.png\" alt=\"img\"></p>":                                                                  "a [link](/x \"t\")  \n![img](/i.png)",
+		"<ul><li>one</li><li><input type=\"checkbox\" checked> two</li></ul>":                                                                                 "- one\n- [x] two",
+		"<ol>\n  <li><p>one</p></li>\n  <li><p>two</p></li>\n</ol>":                                                                                           "1. one\n\n2. two",
+		"<pre><code class=\"language-go\">if a &lt; b {\n}\n</code></pre>":                                                                                    "```go\nif a < b {\n}\n```",
+		"<blockquote><p>quote</p></blockquote><hr>":                                                                                                           "> quote\n\n***",
+		"<table><thead><tr><th>a</th><th align=\"right\">b</th></tr></thead><tbody><tr><td>1</td><td style=\"text-align: right\">2</td></tr></tbody></table>": "| a | b |\n| --- | ---: |\n| 1 | 2 |",
+		"<div>loose <span>text</span><script>alert(1)</script><!-- comment --></div>":                                                                         "loose text",
+		"<p>5 &gt; 3 &amp; *not* em&nbsp;</p>":                                                                                                                "5 > 3 & \\*not\\* em\u00a0",
+		"<p>unclosed <b>bold</p><p>next":                                                                                                                      "unclosed **bold**\n\nnext",
+	}
+	for input, expected := range cases {
+		doc, err := FromHTML(input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", input, err)
+			continue
+		}
+		if actual := doc.Markdown(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+}
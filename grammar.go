@@ -1,8 +1,8 @@
 package mark
 
 import (
-	"fmt"
 	"regexp"
+	"strings"
 )
 
 // Block Grammar
@@ -10,21 +10,23 @@ var (
 	reHr         = regexp.MustCompile(`^(?:(?:\* *){3,}|(?:_ *){3,}|(?:- *){3,}) *(?:\n+|$)`)
 	reHeading    = regexp.MustCompile(`^ *(#{1,6})(?: +#*| +([^\n]*?)|)(?: +#*|) *(?:\n|$)`)
 	reLHeading   = regexp.MustCompile(`^([^\n]+?) *\n {0,3}(=|-){1,} *(?:\n+|$)`)
-	reBlockQuote = regexp.MustCompile(`^ *>[^\n]*(\n[^\n]+)*\n*`)
-	reDefLink    = regexp.MustCompile(`(?s)^ *\[([^\]]+)\]: *\n? *<?([^\s>]+)>?(?: *\n? *["'(](.+?)['")])? *(?:\n+|$)`)
-	reSpaceGen   = func(i int) *regexp.Regexp {
-		return regexp.MustCompile(fmt.Sprintf(`(?m)^ {1,%d}`, i))
-	}
+	reBlockQuote = regexp.MustCompile(`^ *>`)
+	// reInterrupt matches the lines that start a block, that interrupts a
+	// paragraph(and ends a lazy continuation): headings, code fences,
+	// blockquotes and list items. html blocks are tested by interrupts.
+	reInterrupt = regexp.MustCompile("^ {0,3}(?:#{1,6}(?:[ \t]|$)|```|~~~|>|(?:[*+-]|1[.)])[ \t]+\\S)")
+	reFence     = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})")
+	reIndentTab = regexp.MustCompile("(?m)^ {0,3}\t")
+	reDefLink   = regexp.MustCompile(`(?s)^ *\[([^\]]+)\]: *\n? *<?([^\s>]+)>?(?: *\n? *["'(](.+?)['")])? *(?:\n+|$)`)
 )
 
 var reList = struct {
-	item, marker, loose   *regexp.Regexp
-	scanLine, scanNewLine func(src string) string
+	item, marker, loose *regexp.Regexp
+	scanNewLine         func(src string) string
 }{
 	regexp.MustCompile(`^( *)(?:[*+-]|\d{1,9}\.) (.*)(?:\n|)`),
 	regexp.MustCompile(`^ *([*+-]|\d+\.) +`),
 	regexp.MustCompile(`(?m)\n\n(.*)`),
-	regexp.MustCompile(`^(.*)(?:\n|)`).FindString,
 	regexp.MustCompile(`^\n{1,}`).FindString,
 }
 
@@ -36,57 +38,116 @@ var reCodeBlock = struct {
 	regexp.MustCompile("(?m)^( {0,4})").ReplaceAllLiteralString,
 }
 
-var reGfmCode = struct {
-	*regexp.Regexp
-	endGen func(end string, i int) *regexp.Regexp
-}{
-	regexp.MustCompile("^( {0,3})([`~]{3,}) *(\\S*)?(?:.*)"),
-	func(end string, i int) *regexp.Regexp {
-		return regexp.MustCompile(fmt.Sprintf(`(?s)(.*?)(?:((?m)^ {0,3}%s{%d,} *$)|$)`, end, i))
-	},
-}
+var reGfmCode = regexp.MustCompile("^( {0,3})([`~]{3,}) *(\\S*)?(?:.*)")
+
+var reTab = regexp.MustCompile(`^=== +"([^"\n]*)" *(?:\n|$)((?:(?: *\n)*(?: {4}[^\n]*(?:\n|$)))*)`)
+
+// Fenced divs, pandoc-style. the opening fence holds the attributes in
+// braces, e.g: `::: {#id .class key="value"}`, or a single class name.
+var (
+	reDiv     = regexp.MustCompile(`^:{3,}[ \t]*(?:\{([^}\n]*)\}|([\w-]+))[ \t]*:*[ \t]*(?:\n|$)`)
+	reDivEnd  = regexp.MustCompile(`^:{3,}[ \t]*(?:\n|$)`)
+	reDivAttr = regexp.MustCompile(`([#.])([^\s#.=]+)|([\w:-]+)(?:=("[^"]*"|'[^']*'|\S+))?`)
+	// the attributes of a div that are rendered
+	reDivSafeAttr = regexp.MustCompile(`(?i)^(?:data-[a-z0-9_.-]+|title|lang|dir)$`)
+)
+
+// reMore matches the excerpt separator, <!--more-->.
+var reMore = regexp.MustCompile(`(?i)^<!--\s*more\s*-->$`)
+
+var reFrontMatter = regexp.MustCompile(`^---\n((?s).*?)\n---(?:\n|$)`)
+
+var reAlert = regexp.MustCompile(`^(?i)\[!(note|tip|important|warning|caution)\] *(?:\n|$)`)
 
 var reTable = struct {
 	item, itemLp *regexp.Regexp
-	split        func(s string, n int) []string
-	trim         func(src, repl string) string
 }{
 	regexp.MustCompile(`^ *(\S.*\|.*)\n *([-:]+ *\|[-| :]*)\n((?:.*\|.*(?:\n|$))*)\n*`),
 	regexp.MustCompile(`(^ *\|.+)\n( *\| *[-:]+[-| :]*)\n((?: *\|.*(?:\n|$))*)\n*`),
-	regexp.MustCompile(` *\| *`).Split,
-	regexp.MustCompile(`^ *\| *| *\| *$`).ReplaceAllString,
 }
 
+// reMarkdownAttr matches the markdown="1" attribute of an html block tag.
+var reMarkdownAttr = regexp.MustCompile(`\s+markdown=(?:"1"|'1'|1\b)`)
+
 var reHTML = struct {
 	CDATA_OPEN, CDATA_CLOSE  string
 	item, comment, tag, span *regexp.Regexp
-	endTagGen                func(tag string) *regexp.Regexp
 }{
 	`![CDATA[`,
-	"?\\]\\]",
-	regexp.MustCompile(`^<(\w+|!\[CDATA\[)(?:"[^"]*"|'[^']*'|[^'">])*?>`),
+	"]]>",
+	regexp.MustCompile(`^<(\w+|!\[CDATA\[)(?:"[^"]*"|'[^']*'|[^'"<>])*?>`),
 	regexp.MustCompile(`(?sm)<!--.*?-->`),
-	regexp.MustCompile(`^<!--.*?-->|^<\/?\w+(?:"[^"]*"|'[^']*'|[^'">])*?>`),
+	regexp.MustCompile(`^(?:<!--.*?-->|<\/?\w+(?:"[^"]*"|'[^']*'|[^'"<>])*?>)`),
 	// TODO: Add all span-tags and move to config.
 	regexp.MustCompile(`^(a|em|strong|small|s|q|data|time|code|sub|sup|i|b|u|span|br|del|img)$`),
-	func(tag string) *regexp.Regexp {
-		return regexp.MustCompile(fmt.Sprintf(`(?s)(.+?)<\/%s> *`, tag))
-	},
 }
 
+// Helpers used while parsing and rendering text
+var (
+	reEscape      = regexp.MustCompile("^\\\\([\\`*{}\\[\\]()#+\\-.!_>~|])")
+	reTrimSpaces  = regexp.MustCompile(`(?m)^ +| +(\n|$)`)
+	reQuotePrefix = regexp.MustCompile(`(?m)^ *> ?`)
+	reQuoteMarks  = regexp.MustCompile(`^(?: *> ?)+`)
+	reHeadingID   = regexp.MustCompile(`[^\w]+`)
+	reEntityRef   = regexp.MustCompile(`^&\w+;`)
+	reCharRef     = regexp.MustCompile(`^&(?:\w+|#[0-9]+|#[xX][0-9a-fA-F]+);`)
+	reApostrophe  = regexp.MustCompile(`([\pL\pN])'(\pL)`)
+	reDashes      = regexp.MustCompile(`-{2,3}`)
+	reFraction    = regexp.MustCompile(`(\d+)(/\d+)(/\d+|)`)
+)
+
+// Shortcode tags, {{< name args >}} and {{% name args %}}. the closing
+// tag of a paired shortcode is matched by shortcodeEnd.
+var (
+	reShortcode    = regexp.MustCompile(`^\{\{([<%])\s*(/?)([\w./-]+)((?:\s+(?:[\w-]+=)?(?:"[^"]*"|[^\s"]+?))*?)\s*([>%])\}\}`)
+	reShortcodeArg = regexp.MustCompile(`(?:([\w-]+)=)?("[^"]*"|\S+)`)
+)
+
+// shortcodeEnd returns the location of the first closing tag of the given
+// shortcode in s, {{< /name >}} or {{% /name %}}, or nil if there is none.
+// the name comes from the document, so it's matched without a regexp.
+func shortcodeEnd(s, name string) []int {
+	for i := 0; ; i++ {
+		j := strings.Index(s[i:], "{{")
+		if j < 0 {
+			return nil
+		}
+		i += j
+		t := s[i+2:]
+		if t == "" || (t[0] != '<' && t[0] != '%') {
+			continue
+		}
+		t = strings.TrimLeft(t[1:], " \t\n\f\r")
+		if !strings.HasPrefix(t, "/"+name) {
+			continue
+		}
+		t = strings.TrimLeft(t[len(name)+1:], " \t\n\f\r")
+		if strings.HasPrefix(t, ">}}") || strings.HasPrefix(t, "%}}") {
+			return []int{i, len(s) - len(t) + 3}
+		}
+	}
+}
+
+// Liquid/Jinja tags, used by the TemplateTags option
+var reTemplateTag = regexp.MustCompile(`(?s)^(?:\{%.*?%\}|\{\{.*?\}\})`)
+
+// Data urls that are safe to use in links and images
+var reSafeData = regexp.MustCompile(`^data:image/(?:png|gif|jpeg|webp);`)
+
+// Disallowed raw html tags(GFM tagfilter)
+var reTagFilter = regexp.MustCompile(`(?i)<(/?(?:title|textarea|style|xmp|iframe|noembed|noframes|script|plaintext)(?:[\s/>]|$))`)
+
 // Inline Grammar
 var (
-	reBr        = regexp.MustCompile(`^(?: {2,}|\\)\n`)
-	reLinkText  = `(?:\[[^\]]*\]|[^\[\]]|\])*`
-	reLinkHref  = `\s*<?(.*?)>?(?:\s+['"\(](.*?)['"\)])?\s*`
-	reGfmLink   = regexp.MustCompile(`^(https?:\/\/[^\s<]+[^<.,:;"')\]\s])`)
-	reLink      = regexp.MustCompile(fmt.Sprintf(`(?s)^!?\[(%s)\]\(%s\)`, reLinkText, reLinkHref))
-	reAutoLink  = regexp.MustCompile(`^<([^ >]+(@|:\/)[^ >]+)>`)
-	reRefLink   = regexp.MustCompile(`^!?\[((?:\[[^\]]*\]|[^\[\]]|\])*)\](?:\s*\[([^\]]*)\])?`)
-	reImage     = regexp.MustCompile(fmt.Sprintf(`(?s)^!?\[(%s)\]\(%s\)`, reLinkText, reLinkHref))
-	reCode      = regexp.MustCompile("(?s)^`{1,2}\\s*(.*?[^`])\\s*`{1,2}")
-	reStrike    = regexp.MustCompile(`(?s)^~{2}(.+?)~{2}`)
-	reEmphasise = `(?s)^_{%[1]d}(\S.*?_*)_{%[1]d}|^\*{%[1]d}(\S.*?\**)\*{%[1]d}`
-	reItalic    = regexp.MustCompile(fmt.Sprintf(reEmphasise, 1))
-	reStrong    = regexp.MustCompile(fmt.Sprintf(reEmphasise, 2))
+	reBr       = regexp.MustCompile(`^(?: {2,}|\\)\n`)
+	reLinkHref = `\s*<?(.*?)>?(?:\s+['"\(](.*?)['"\)])?\s*`
+	reGfmLink  = regexp.MustCompile(`^https?:\/\/[^\s<]+`)
+	reRuby     = regexp.MustCompile(`^(?:\{([^{}|\n]+)\|([^{}\n]+)\}|\[([^\[\]\n]+)\]\{([^{}\n]+)\})`)
+	reEmoji    = regexp.MustCompile(`^:([\w+-]+):`)
+	reEntity   = regexp.MustCompile(`&\w+;$`)
+	reLinkDest = regexp.MustCompile(`(?s)^\(` + reLinkHref + `\)`)
+	reAutoLink = regexp.MustCompile(`^<([^ <>]+(@|:\/)[^ <>]+)>`)
+	reRefLabel = regexp.MustCompile(`^\s*\[([^\]]*)\]`)
+	reCode     = regexp.MustCompile("(?s)^`{1,2}\\s*(.*?[^`])\\s*`{1,2}")
+	reStrike   = regexp.MustCompile(`(?s)^~{2}(.+?)~{2}`)
 )
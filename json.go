@@ -0,0 +1,274 @@
+package mark
+
+import (
+	"bytes"
+	"encoding/json"
+	"html"
+)
+
+// JSONNode is a node of the portable block/inline JSON format, that is
+// similar to the ProseMirror document format. block nodes hold their
+// children in Content, and inline styles(strong, em, link, ...) are marks
+// of the text nodes, e.g:
+//
+//	{"type": "paragraph", "content": [
+//		{"type": "text", "text": "foo", "marks": [{"type": "strong"}]}
+//	]}
+type JSONNode struct {
+	Type    string                 `json:"type"`
+	Attrs   map[string]interface{} `json:"attrs,omitempty"`
+	Content []*JSONNode            `json:"content,omitempty"`
+	Text    string                 `json:"text,omitempty"`
+	Marks   []*JSONMark            `json:"marks,omitempty"`
+}
+
+// JSONMark is an inline style of a text node.
+type JSONMark struct {
+	Type  string                 `json:"type"`
+	Attrs map[string]interface{} `json:"attrs,omitempty"`
+}
+
+// JSON returns the JSON representation of the given nodes. the text is
+// unescaped, and reference links are resolved.
+func JSON(nodes ...Node) []*JSONNode {
+	var content []*JSONNode
+	for _, node := range nodes {
+		content = append(content, jsonBlock(node)...)
+	}
+	return content
+}
+
+// JSON returns the JSON encoding of the document, that is a "doc" node.
+// html characters aren't escaped.
+func (d *DocumentNode) JSON() ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(&JSONNode{Type: "doc", Content: JSON(d.Nodes...)}); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
+
+// jsonBlock returns the JSON representation of a block node. inline nodes
+// are returned as is(see jsonInline).
+func jsonBlock(node Node) []*JSONNode {
+	switch n := node.(type) {
+	case *ParagraphNode:
+		return []*JSONNode{{Type: "paragraph", Content: jsonInline(n.Nodes, nil)}}
+	case *HeadingNode:
+		return []*JSONNode{{Type: "heading", Attrs: map[string]interface{}{"level": n.Level}, Content: jsonInline(n.Nodes, nil)}}
+	case *HrNode:
+		return []*JSONNode{{Type: "horizontal_rule"}}
+	case *CodeNode:
+		code := &JSONNode{Type: "code_block", Attrs: map[string]interface{}{"language": n.Lang}}
+		text := codeText(n)
+		if text != "" {
+			code.Content = []*JSONNode{{Type: "text", Text: text}}
+		}
+		return []*JSONNode{code}
+	case *DefLinkNode:
+		return nil
+	case *ListNode:
+		list := &JSONNode{Type: "bullet_list"}
+		if n.Ordered {
+			list.Type = "ordered_list"
+			list.Attrs = map[string]interface{}{"order": n.start()}
+		}
+		for _, item := range n.Items {
+			list.Content = append(list.Content, jsonListItem(item))
+		}
+		return []*JSONNode{list}
+	case *TableNode:
+		table := &JSONNode{Type: "table"}
+		for _, row := range n.Rows {
+			table.Content = append(table.Content, jsonRow(row))
+		}
+		return []*JSONNode{table}
+	case *BlockQuoteNode:
+		quote := &JSONNode{Type: "blockquote", Content: JSON(n.Nodes...)}
+		if n.Alert != "" {
+			quote.Attrs = map[string]interface{}{"alert": n.Alert}
+		}
+		return []*JSONNode{quote}
+	case *DetailsNode:
+		summary := &JSONNode{Type: "details_summary", Content: jsonInline(n.Summary, nil)}
+		return []*JSONNode{{Type: "details", Content: append([]*JSONNode{summary}, JSON(n.Nodes...)...)}}
+	case *DivNode:
+		div := &JSONNode{Type: "div", Content: JSON(n.Nodes...)}
+		if n.ID != "" || len(n.Classes) > 0 || len(n.Attrs) > 0 {
+			div.Attrs = map[string]interface{}{"id": n.ID, "classes": n.Classes, "attrs": n.Attrs}
+		}
+		return []*JSONNode{div}
+	case *TabsNode:
+		tabs := &JSONNode{Type: "tabs"}
+		for _, tab := range n.Tabs {
+			tabs.Content = append(tabs.Content, jsonTab(tab))
+		}
+		return []*JSONNode{tabs}
+	case *HTMLNode:
+		if n.Open != "" {
+			return []*JSONNode{{Type: "html_container", Attrs: map[string]interface{}{"open": n.Open, "close": n.Close}, Content: JSON(n.Nodes...)}}
+		}
+		return []*JSONNode{{Type: "html", Text: n.Src}}
+	case *RawNode:
+		return []*JSONNode{{Type: "raw", Text: n.Text}}
+	case *ShortcodeNode:
+		return []*JSONNode{jsonShortcode(n)}
+	case *DocumentNode:
+		return []*JSONNode{{Type: "doc", Content: JSON(n.Nodes...)}}
+	case *ListItemNode:
+		return []*JSONNode{jsonListItem(n)}
+	case *RowNode:
+		return []*JSONNode{jsonRow(n)}
+	case *CellNode:
+		return []*JSONNode{jsonCell(n)}
+	case *TabNode:
+		return []*JSONNode{jsonTab(n)}
+	default:
+		if isInline(node) {
+			return jsonInline([]Node{node}, nil)
+		}
+		return nil
+	}
+}
+
+// jsonShortcode returns the JSON representation of shortcode.
+func jsonShortcode(n *ShortcodeNode) *JSONNode {
+	return &JSONNode{Type: "shortcode", Attrs: map[string]interface{}{"name": n.Name, "args": n.Args}, Text: n.Inner}
+}
+
+// jsonTab returns the JSON representation of tab.
+func jsonTab(tab *TabNode) *JSONNode {
+	title := html.UnescapeString(tab.Title)
+	return &JSONNode{Type: "tab", Attrs: map[string]interface{}{"title": title}, Content: JSON(tab.Nodes...)}
+}
+
+// jsonRow returns the JSON representation of table row.
+func jsonRow(row *RowNode) *JSONNode {
+	tr := &JSONNode{Type: "table_row"}
+	for _, cell := range row.Cells {
+		tr.Content = append(tr.Content, jsonCell(cell))
+	}
+	return tr
+}
+
+// jsonListItem returns the JSON representation of list item. the inline
+// nodes of tight items are wrapped with a paragraph, and the checkbox of
+// task items is stored in the "checked" attribute.
+func jsonListItem(item *ListItemNode) *JSONNode {
+	li := &JSONNode{Type: "list_item"}
+	var inline []Node
+	flush := func() {
+		if len(inline) > 0 {
+			li.Content = append(li.Content, &JSONNode{Type: "paragraph", Content: jsonInline(inline, nil)})
+			inline = nil
+		}
+	}
+	for _, node := range item.Nodes {
+		if c, ok := node.(*CheckboxNode); ok {
+			li.Attrs = map[string]interface{}{"checked": c.Checked}
+			continue
+		}
+		if isInline(node) {
+			inline = append(inline, node)
+			continue
+		}
+		flush()
+		li.Content = append(li.Content, jsonBlock(node)...)
+	}
+	flush()
+	return li
+}
+
+// jsonCell returns the JSON representation of table cell.
+func jsonCell(cell *CellNode) *JSONNode {
+	c := &JSONNode{Type: "table_cell"}
+	if cell.Kind == Header {
+		c.Type = "table_header"
+	}
+	switch cell.Align() {
+	case Left:
+		c.Attrs = map[string]interface{}{"align": "left"}
+	case Right:
+		c.Attrs = map[string]interface{}{"align": "right"}
+	case Center:
+		c.Attrs = map[string]interface{}{"align": "center"}
+	}
+	var inline []Node
+	for _, node := range cell.Nodes {
+		if isInline(node) {
+			inline = append(inline, node)
+		} else {
+			c.Content = append(c.Content, jsonBlock(node)...)
+		}
+	}
+	if len(inline) > 0 {
+		c.Content = append([]*JSONNode{{Type: "paragraph", Content: jsonInline(inline, nil)}}, c.Content...)
+	}
+	return c
+}
+
+// jsonInline returns the JSON representation of inline nodes. marks are
+// the styles of the parent nodes, that are added to the text nodes.
+func jsonInline(nodes []Node, marks []*JSONMark) (content []*JSONNode) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *TextNode:
+			if n.Text != "" {
+				content = append(content, &JSONNode{Type: "text", Text: html.UnescapeString(n.Text), Marks: marks})
+			}
+		case *LiteralNode:
+			content = append(content, &JSONNode{Type: "text", Text: html.UnescapeString(n.Text), Marks: marks})
+		case *BrNode:
+			content = append(content, &JSONNode{Type: "hard_break"})
+		case *EmphasisNode:
+			mark := map[itemType]string{itemStrong: "strong", itemItalic: "em", itemStrike: "strike", itemCode: "code"}[n.Style]
+			content = append(content, jsonInline(n.Nodes, withMark(marks, &JSONMark{Type: mark}))...)
+		case *LinkNode:
+			content = append(content, jsonInline(n.Nodes, withMark(marks, jsonLink(n.Href, n.Title)))...)
+		case *ImageNode:
+			content = append(content, jsonImage(n.Src, n.Alt, n.Title))
+		case *RefNode:
+			content = append(content, jsonInline([]Node{n.resolve()}, marks)...)
+		case *EmojiNode:
+			content = append(content, &JSONNode{Type: "emoji", Attrs: map[string]interface{}{"name": n.Name, "src": n.Src}})
+		case *RubyNode:
+			content = append(content, &JSONNode{Type: "ruby", Attrs: map[string]interface{}{"text": html.UnescapeString(n.Text)}, Content: jsonInline(n.Nodes, marks)})
+		case *CheckboxNode:
+			content = append(content, &JSONNode{Type: "checkbox", Attrs: map[string]interface{}{"checked": n.Checked}})
+		case *HTMLNode:
+			content = append(content, &JSONNode{Type: "html_inline", Text: n.Src})
+		case *CustomInlineNode:
+			content = append(content, &JSONNode{Type: "text", Text: n.Match[0], Marks: marks})
+		case *RawNode:
+			content = append(content, &JSONNode{Type: "raw", Text: n.Text})
+		case *ShortcodeNode:
+			content = append(content, jsonShortcode(n))
+		}
+	}
+	return
+}
+
+// withMark returns a copy of marks with the given mark appended.
+func withMark(marks []*JSONMark, mark *JSONMark) []*JSONMark {
+	return append(marks[:len(marks):len(marks)], mark)
+}
+
+// jsonLink returns a link mark.
+func jsonLink(href, title string) *JSONMark {
+	attrs := map[string]interface{}{"href": html.UnescapeString(href)}
+	if title != "" {
+		attrs["title"] = html.UnescapeString(title)
+	}
+	return &JSONMark{Type: "link", Attrs: attrs}
+}
+
+// jsonImage returns an image node.
+func jsonImage(src, alt, title string) *JSONNode {
+	attrs := map[string]interface{}{"src": html.UnescapeString(src), "alt": html.UnescapeString(alt)}
+	if title != "" {
+		attrs["title"] = html.UnescapeString(title)
+	}
+	return &JSONNode{Type: "image", Attrs: attrs}
+}
@@ -0,0 +1,44 @@
+package mark
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestJSON(t *testing.T) {
+	cases := map[string]string{
+		"# foo":                           `{"type":"doc","content":[{"type":"heading","attrs":{"level":1},"content":[{"type":"text","text":"foo"}]}]}`,
+		"a **b _c_** &amp;":               `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"a "},{"type":"text","text":"b ","marks":[{"type":"strong"}]},{"type":"text","text":"c","marks":[{"type":"strong"},{"type":"em"}]},{"type":"text","text":" &"}]}]}`,
+		"[x](/y \"t\")  \nz":              `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"text","text":"x","marks":[{"type":"link","attrs":{"href":"/y","title":"t"}}]},{"type":"hard_break"},{"type":"text","text":"z"}]}]}`,
+		"- [x] a\n- b":                    `{"type":"doc","content":[{"type":"bullet_list","content":[{"type":"list_item","attrs":{"checked":true},"content":[{"type":"paragraph","content":[{"type":"text","text":"a"}]}]},{"type":"list_item","content":[{"type":"paragraph","content":[{"type":"text","text":"b"}]}]}]}]}`,
+		"```go\nx < 1\n```":               `{"type":"doc","content":[{"type":"code_block","attrs":{"language":"go"},"content":[{"type":"text","text":"x < 1"}]}]}`,
+		"a | b\n--|--:\n1 | 2":            `{"type":"doc","content":[{"type":"table","content":[{"type":"table_row","content":[{"type":"table_header","content":[{"type":"paragraph","content":[{"type":"text","text":"a"}]}]},{"type":"table_header","attrs":{"align":"right"},"content":[{"type":"paragraph","content":[{"type":"text","text":"b"}]}]}]},{"type":"table_row","content":[{"type":"table_cell","content":[{"type":"paragraph","content":[{"type":"text","text":"1"}]}]},{"type":"table_cell","attrs":{"align":"right"},"content":[{"type":"paragraph","content":[{"type":"text","text":"2"}]}]}]}]}]}`,
+		"![a][b] [c][d]\n\n[b]: /img.png": `{"type":"doc","content":[{"type":"paragraph","content":[{"type":"image","attrs":{"alt":"a","src":"/img.png"}},{"type":"text","text":" "},{"type":"text","text":"[c][d]"}]}]}`,
+	}
+	for input, expected := range cases {
+		b, err := Parse(input, nil).JSON()
+		if actual := string(b); err != nil || actual != expected {
+			t.Errorf("%s: got\n\t%+v(%v)\nexpected\n\t%+v", input, actual, err, expected)
+		}
+	}
+}
+
+func TestJSONNodes(t *testing.T) {
+	cases := []struct {
+		node     Node
+		expected string
+	}{
+		{NewListItem(NewText("a")), `[{"type":"list_item","content":[{"type":"paragraph","content":[{"type":"text","text":"a"}]}]}]`},
+		{NewRow(NewCell(Data, None, NewText("a"))), `[{"type":"table_row","content":[{"type":"table_cell","content":[{"type":"paragraph","content":[{"type":"text","text":"a"}]}]}]}]`},
+		{NewTab("t"), `[{"type":"tab","attrs":{"title":"t"}}]`},
+		{NewDocument(NewHr()), `[{"type":"doc","content":[{"type":"horizontal_rule"}]}]`},
+		{NewParagraph(NewRaw("<b>"), userNode{NodeType: 100}), `[{"type":"paragraph","content":[{"type":"raw","text":"\u003cb\u003e"}]}]`},
+		{userNode{NodeType: 100}, `null`},
+	}
+	for _, c := range cases {
+		b, _ := json.Marshal(JSON(c.node))
+		if actual := string(b); actual != c.expected {
+			t.Errorf("%T: got\n\t%+v\nexpected\n\t%+v", c.node, actual, c.expected)
+		}
+	}
+}
@@ -1,16 +1,27 @@
 package mark
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 )
 
 // type position
 type Pos int
 
-// itemType identifies the type of lex items.
-type itemType int
+// Position returns itself and provides an easy default implementation
+// for embedding in a Node.
+func (p Pos) Position() Pos {
+	return p
+}
+
+// TokenType identifies the type of lex items(tokens), e.g: in traces.
+type TokenType int
+
+// itemType is the type of lex items inside the package.
+type itemType = TokenType
 
 // Item represent a token or text string returned from the scanner
 type item struct {
@@ -19,7 +30,7 @@ type item struct {
 	val string   // The value of this item.
 }
 
-const eof = -1 // Zero value so closed channel delivers EOF
+const eof = -1
 
 const (
 	itemError itemType = iota // Error occurred; value is text of error
@@ -29,6 +40,11 @@ const (
 	itemHeading
 	itemLHeading
 	itemBlockQuote
+	itemDetails
+	itemDiv
+	itemLiteral
+	itemRaw
+	itemTabs
 	itemList
 	itemListItem
 	itemLooseItem
@@ -50,12 +66,72 @@ const (
 	itemGfmLink
 	itemImage
 	itemRefImage
+	itemRuby
+	itemEmoji
+	itemShortcode
 	itemText
 	itemBr
 	itemPipe
 	itemIndent
+	itemCustomInline
+	itemTemplate
 )
 
+// TokenNames maps the token types to their names, used by traces and
+// debuggers. the names are stable, and aren't changed between versions.
+var TokenNames = map[TokenType]string{
+	itemError:        "Error",
+	itemEOF:          "EOF",
+	itemNewLine:      "NewLine",
+	itemHTML:         "HTML",
+	itemHeading:      "Heading",
+	itemLHeading:     "LHeading",
+	itemBlockQuote:   "BlockQuote",
+	itemDetails:      "Details",
+	itemDiv:          "Div",
+	itemLiteral:      "Literal",
+	itemRaw:          "Raw",
+	itemTabs:         "Tabs",
+	itemList:         "List",
+	itemListItem:     "ListItem",
+	itemLooseItem:    "LooseItem",
+	itemCodeBlock:    "CodeBlock",
+	itemGfmCodeBlock: "GfmCodeBlock",
+	itemHr:           "Hr",
+	itemTable:        "Table",
+	itemLpTable:      "LpTable",
+	itemTableRow:     "TableRow",
+	itemTableCell:    "TableCell",
+	itemText:         "Text",
+	itemLink:         "Link",
+	itemDefLink:      "DefLink",
+	itemRefLink:      "RefLink",
+	itemAutoLink:     "AutoLink",
+	itemGfmLink:      "GfmLink",
+	itemStrong:       "Strong",
+	itemItalic:       "Italic",
+	itemStrike:       "Strike",
+	itemCode:         "Code",
+	itemImage:        "Image",
+	itemRefImage:     "RefImage",
+	itemRuby:         "Ruby",
+	itemEmoji:        "Emoji",
+	itemShortcode:    "Shortcode",
+	itemBr:           "Br",
+	itemPipe:         "Pipe",
+	itemIndent:       "Indent",
+	itemCustomInline: "CustomInline",
+	itemTemplate:     "Template",
+}
+
+func (i TokenType) String() string {
+	s := TokenNames[i]
+	if s == "" {
+		return fmt.Sprintf("item%d", int(i))
+	}
+	return s
+}
+
 // stateFn represents the state of the scanner as a function that returns the next state.
 type stateFn func(*lexer) stateFn
 
@@ -64,43 +140,75 @@ type Lexer interface {
 	nextItem() item
 }
 
-// lexer holds the state of the scanner.
+// lexer holds the state of the scanner. it's a pull lexer, the state
+// functions run only when the parser asks for the next item.
 type lexer struct {
-	input   string    // the string being scanned
-	state   stateFn   // the next lexing function to enter
-	pos     Pos       // current position in the input
-	start   Pos       // start position of this item
-	width   Pos       // width of last rune read from input
-	lastPos Pos       // position of most recent item returned by nextItem
-	items   chan item // channel of scanned items
+	input   string              // the string being scanned
+	state   stateFn             // the next lexing function to enter
+	pos     Pos                 // current position in the input
+	start   Pos                 // start position of this item
+	width   Pos                 // width of last rune read from input
+	lastPos Pos                 // position of most recent item returned by nextItem
+	items   []item              // scanned items that weren't returned by nextItem yet
+	options *Options            // enabled block extensions
+	last    map[string]int      // last index of closing delimiters(see closes)
+	runs    map[byte]*delimRuns // delimiter runs of the inline input(see delims)
+	match   map[int]int         // matching bracket of each '['(see bracket)
+	ends    map[string]int      // last index of closing html tags(see closesTag)
+	tags    map[int]int         // end of the element opened at each index(see elementEnd)
+	custom  [][2]int            // next match of each CustomInline rule(see matchCustom)
+	noCode  bool                // indented code blocks are disabled(see Options.NoListCode)
 }
 
 // lex creates a new lexer for the input string.
-func lex(input string) *lexer {
-	l := &lexer{
-		input: input,
-		items: make(chan item),
+// nil options means that no block extension is enabled.
+func lex(input string, opts *Options) *lexer {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return &lexer{
+		input:   input,
+		state:   lexAny,
+		options: opts,
 	}
-	go l.run()
-	return l
 }
 
-// lexInline create a new lexer for one phase lexing(inline blocks).
-func lexInline(input string) *lexer {
-	l := &lexer{
-		input: input,
-		items: make(chan item),
+// reset makes the lexer scan the given input from its start, as a block
+// lexer. the buffers of the lexer are reused.
+func (l *lexer) reset(input string) {
+	for delim := range l.last {
+		delete(l.last, delim)
 	}
-	go l.lexInline()
-	return l
+	*l = lexer{input: input, state: lexAny, items: l.items[:0], options: l.options, last: l.last, noCode: l.noCode}
 }
 
-// run runs the state machine for the lexer.
-func (l *lexer) run() {
-	for l.state = lexAny; l.state != nil; {
-		l.state = l.state(l)
+// lexInline create a new lexer for one phase lexing(inline blocks).
+// nil options means that the GFM inline rules are disabled.
+func lexInline(input string, opts *Options) *lexer {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return &lexer{
+		input:   input,
+		options: opts,
+		state: func(l *lexer) stateFn {
+			l.lexInline()
+			return nil
+		},
 	}
-	close(l.items)
+}
+
+// step runs the current state function. a panic in the state function is
+// turned into an error item, so it's handled by the parser instead of
+// crashing the program.
+func (l *lexer) step() {
+	defer func() {
+		if e := recover(); e != nil {
+			l.items = append(l.items, item{itemError, l.pos, fmt.Sprint(e)})
+			l.state = nil
+		}
+	}()
+	l.state = l.state(l)
 }
 
 // next return the next rune in the input
@@ -118,6 +226,21 @@ func (l *lexer) next() rune {
 // lexAny scanner is kind of forwarder, it get the current char in the text
 // and forward it to the appropriate scanner based on some conditions.
 func lexAny(l *lexer) stateFn {
+	if len(l.options.RawDelims) > 0 {
+		if state := lexRaw(l); state != nil {
+			return state
+		}
+	}
+	if l.options.Shortcodes && strings.HasPrefix(l.input[l.pos:], "{{") {
+		if state := lexShortcode(l); state != nil {
+			return state
+		}
+	}
+	if l.options.TemplateTags && strings.HasPrefix(l.input[l.pos:], "{%") {
+		if state := lexTemplateTag(l); state != nil {
+			return state
+		}
+	}
 	switch r := l.peek(); r {
 	case '*', '-', '_':
 		return lexHr
@@ -134,7 +257,7 @@ func lexAny(l *lexer) stateFn {
 	case '`', '~':
 		return lexGfmCode
 	case ' ':
-		if reCodeBlock.MatchString(l.input[l.pos:]) {
+		if !l.noCode && reCodeBlock.MatchString(l.input[l.pos:]) {
 			return lexCode
 		} else if reGfmCode.MatchString(l.input[l.pos:]) {
 			return lexGfmCode
@@ -145,13 +268,26 @@ func lexAny(l *lexer) stateFn {
 		l.emit(itemIndent)
 		return lexAny
 	case '|':
-		if m := reTable.itemLp.MatchString(l.input[l.pos:]); m {
+		if isTable(reTable.itemLp, l.input[l.pos:]) {
 			l.emit(itemLpTable)
 			return lexTable
 		}
 		fallthrough
+	case '=':
+		if l.options.Tabs && reTab.MatchString(l.input[l.pos:]) {
+			return lexTabs
+		}
+		fallthrough
+	case ':':
+		if l.options.Details && strings.HasPrefix(l.input[l.pos:], ":::details") {
+			return lexContainer(itemDetails)
+		}
+		if l.options.Divs && reDiv.MatchString(l.input[l.pos:]) {
+			return lexContainer(itemDiv)
+		}
+		fallthrough
 	default:
-		if m := reTable.item.MatchString(l.input[l.pos:]); m {
+		if isTable(reTable.item, l.input[l.pos:]) {
 			l.emit(itemTable)
 			return lexTable
 		}
@@ -159,6 +295,101 @@ func lexAny(l *lexer) stateFn {
 	}
 }
 
+// lexRaw scans a raw region, if the input starts with one of the raw
+// delimiters. the content of the region is emitted as is, without its
+// delimiters and the rest of the closing line.
+func lexRaw(l *lexer) stateFn {
+	input := l.input[l.pos:]
+	var open string
+	for delim := range l.options.RawDelims {
+		if strings.HasPrefix(input, delim) && len(delim) > len(open) {
+			open = delim
+		}
+	}
+	if open == "" {
+		return nil
+	}
+	end := strings.Index(input[len(open):], l.options.RawDelims[open])
+	if end == -1 {
+		return nil
+	}
+	content := input[len(open) : len(open)+end]
+	end += len(open) + len(l.options.RawDelims[open])
+	if i := strings.IndexByte(input[end:], '\n'); i != -1 {
+		end += i + 1
+	} else {
+		end = len(input)
+	}
+	l.pos += Pos(end)
+	l.emit(itemRaw, strings.TrimSuffix(strings.TrimPrefix(content, "\n"), "\n"))
+	return lexAny
+}
+
+// lexShortcode scans a shortcode that stands alone on its lines(block
+// shortcode). shortcodes within text are scanned by the inline lexer.
+func lexShortcode(l *lexer) stateFn {
+	input := l.input[l.pos:]
+	n := matchShortcode(input)
+	if n == 0 {
+		return nil
+	}
+	end := strings.IndexByte(input[n:], '\n')
+	if end == -1 {
+		end = len(input) - n
+	}
+	if strings.TrimSpace(input[n:n+end]) != "" {
+		return nil
+	}
+	l.pos += Pos(n + end)
+	if l.pos < Pos(len(l.input)) {
+		l.pos++
+	}
+	l.emit(itemShortcode, input[:n])
+	return lexAny
+}
+
+// lexTemplateTag scans a line that holds only a {% ... %} tag, that is
+// emitted as a raw item. tags within text are scanned by the inline lexer.
+func lexTemplateTag(l *lexer) stateFn {
+	input := l.input[l.pos:]
+	m := reTemplateTag.FindString(input)
+	if m == "" {
+		return nil
+	}
+	end := strings.IndexByte(input[len(m):], '\n')
+	if end == -1 {
+		end = len(input) - len(m)
+	}
+	if strings.TrimSpace(input[len(m):len(m)+end]) != "" {
+		return nil
+	}
+	l.pos += Pos(len(m) + end)
+	if l.pos < Pos(len(l.input)) {
+		l.pos++
+	}
+	l.emit(itemTemplate, m)
+	return lexAny
+}
+
+// matchShortcode returns the length of the shortcode at the start of input,
+// including its inner content and closing tag if it has one, or 0 if the
+// input doesn't start with a shortcode. the closing tag is optional, and a
+// shortcode that ends with "/>}}" has no inner content.
+func matchShortcode(input string) int {
+	m := reShortcode.FindStringSubmatch(input)
+	if m == nil || m[2] != "" || (m[1] == "<") != (m[5] == ">") {
+		return 0
+	}
+	n := len(m[0])
+	if strings.HasSuffix(m[4], "/") {
+		return n
+	}
+	if loc := shortcodeEnd(input[n:], m[3]); loc != nil {
+		n += loc[1]
+	}
+	return n
+}
+
 // lexHeading test if the current text position is an heading item.
 // is so, it will emit an item and return back to lenAny function
 // else, lex it as a simple text value
@@ -191,16 +422,16 @@ func lexHr(l *lexer) stateFn {
 func lexGfmCode(l *lexer) stateFn {
 	if match := reGfmCode.FindStringSubmatch(l.input[l.pos:]); len(match) != 0 {
 		l.pos += Pos(len(match[0]))
-		fence := match[2]
-		// Generate Regexp based on fence type[`~] and length
-		reGfmEnd := reGfmCode.endGen(fence[0:1], len(fence))
-		infoContainer := reGfmEnd.FindStringSubmatch(l.input[l.pos:])
-		l.pos += Pos(len(infoContainer[0]))
-		infoString := infoContainer[1]
+		infoString, closing := closeFence(l.input[l.pos:], match[2])
+		// the opening line of an unclosed fence is literal text
+		if closing == "" && l.options.LiteralErrors {
+			l.emit(itemLiteral)
+			return lexAny
+		}
+		l.pos += Pos(len(infoString) + len(closing))
 		// Remove leading and trailing spaces
 		if indent := len(match[1]); indent > 0 {
-			reSpace := reSpaceGen(indent)
-			infoString = reSpace.ReplaceAllString(infoString, "")
+			infoString = trimIndent(infoString, indent)
 		}
 		l.emit(itemGfmCodeBlock, match[0]+infoString)
 		return lexAny
@@ -231,7 +462,7 @@ Loop:
 		switch r := l.peek(); r {
 		case eof:
 			emit(itemEOF, Pos(0))
-			break Loop
+			return nil
 		case '\n':
 			// CM 4.4: An indented code block cannot interrupt a paragraph.
 			if l.pos > l.start && strings.HasPrefix(l.input[l.pos+1:], "    ") {
@@ -241,7 +472,12 @@ Loop:
 			emit(itemNewLine, l.width)
 			break Loop
 		default:
-			// Test for Setext-style headers
+			// Test for Setext-style headers. a match in the middle of the
+			// line is also a match at its start, so test only there.
+			if l.pos > l.start && l.input[l.pos-1] != '\n' {
+				l.next()
+				continue
+			}
 			if m := reLHeading.FindString(l.input[l.pos:]); m != "" {
 				emit(itemLHeading, Pos(len(m)))
 				break Loop
@@ -252,6 +488,338 @@ Loop:
 	return lexAny
 }
 
+// closes tests if the closing delimiter appears in the input after the
+// current position and the given offset. inline constructs that can't be
+// closed are skipped without running their regexp, that otherwise scans the
+// rest of the input at each position(e.g: "[[[[..." or "****...").
+func (l *lexer) closes(delim string, offset int) bool {
+	if l.last == nil {
+		l.last = make(map[string]int)
+	}
+	i, ok := l.last[delim]
+	if !ok {
+		i = strings.LastIndex(l.input, delim)
+		l.last[delim] = i
+	}
+	return i >= int(l.pos)+offset
+}
+
+// find returns the match of re at the current position, or an empty string
+// if the closing delimiter doesn't appear after the given offset.
+func (l *lexer) find(re *regexp.Regexp, delim string, offset int) string {
+	if !l.closes(delim, offset) {
+		return ""
+	}
+	return re.FindString(l.input[l.pos:])
+}
+
+// maxLabelLen is the maximum length of link labels(CommonMark 4.7), that
+// also bounds the text of reference links.
+const maxLabelLen = 999
+
+// matchLink returns the length of the link or the image at the current
+// position, or 0 if there's no such link. its text ends at the bracket that
+// matches the opening one, and it must be followed by the destination. the
+// destination is matched up to its second closing parenthesis(the one that
+// may close a title), so it doesn't scan the rest of the input.
+func (l *lexer) matchLink() int {
+	open := int(l.pos)
+	if l.input[open] == '!' {
+		open++
+	}
+	end := l.bracket(open)
+	if end == -1 || end+1 >= len(l.input) || l.input[end+1] != '(' || !l.closes(")", end+2-int(l.pos)) {
+		return 0
+	}
+	dest := l.input[end+1:]
+	i := strings.IndexByte(dest, ')')
+	if i == -1 {
+		return 0
+	}
+	if j := strings.IndexByte(dest[i+1:], ')'); j != -1 {
+		dest = dest[:i+j+2]
+	}
+	loc := reLinkDest.FindStringIndex(dest)
+	if loc == nil {
+		return 0
+	}
+	return end + 1 + loc[1] - int(l.pos)
+}
+
+// matchRefLink returns the reference link at the current position. its
+// text ends at the bracket that matches the opening one, and it may be
+// followed by a label. the label is matched within its maximum length, so
+// unclosed labels aren't scanned to the end of the input.
+func (l *lexer) matchRefLink() string {
+	open := int(l.pos)
+	if l.input[open] == '!' {
+		open++
+	}
+	end := l.bracket(open)
+	if end == -1 || end-open-1 > maxLabelLen {
+		return ""
+	}
+	label := l.input[end+1:]
+	if n := len(label) - len(strings.TrimLeft(label, " \t\n\f\r")) + maxLabelLen + 2; len(label) > n {
+		label = label[:n]
+	}
+	return l.input[l.pos:end+1] + reRefLabel.FindString(label)
+}
+
+// bracket returns the index of the bracket that matches the '[' at the
+// given index, or -1 if it's not closed. the brackets of the input are
+// matched once, using a stack. escaped brackets are skipped.
+func (l *lexer) bracket(i int) int {
+	if l.match == nil {
+		l.match = make(map[int]int)
+		var stack []int
+		for j := 0; j < len(l.input); j++ {
+			switch l.input[j] {
+			case '\\':
+				j++
+			case '[':
+				stack = append(stack, j)
+			case ']':
+				if n := len(stack); n > 0 {
+					l.match[stack[n-1]], stack = j, stack[:n-1]
+				}
+			}
+		}
+	}
+	if j, ok := l.match[i]; ok {
+		return j
+	}
+	return -1
+}
+
+// splitLink splits the source of a link, an image or a reference link into
+// its text and the rest(the destination or the label). the text ends at the
+// bracket that matches the opening one, like in lexer.bracket.
+func splitLink(src string) (text, rest string) {
+	open := strings.IndexByte(src, '[')
+	depth := 0
+	for i := open; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			if depth--; depth == 0 {
+				return src[open+1 : i], src[i+1:]
+			}
+		}
+	}
+	return src[open+1:], ""
+}
+
+// delimRun is a run of delimiter characters. nonWord reports whether
+// it's not followed by a letter or a digit.
+type delimRun struct {
+	start, end int
+	nonWord    bool
+}
+
+// delimRuns indexes the runs of a delimiter character in the inline input,
+// so the closing delimiter of emphasis, strikethrough and code spans is
+// found in constant time, instead of scanning the rest of the input at
+// each opening delimiter.
+type delimRuns struct {
+	runs []delimRun
+	// first[i] is the index of the first run that starts at or after i.
+	first []int
+	// next[f][i] is the index of the first run at or after runs[i] that
+	// passes the filter f: two or more delimiters, not followed by a word
+	// character, or both.
+	next [3][]int
+	// same[i] is the index of the next run that has the length of runs[i],
+	// or len(runs) if there's no such run.
+	same []int
+}
+
+// Filters of delimRuns.next.
+const (
+	runLong = iota
+	runNonWord
+	runLongNonWord
+)
+
+// newDelimRuns returns the runs of the given character in input.
+func newDelimRuns(input string, c byte) *delimRuns {
+	d := &delimRuns{first: make([]int, len(input)+1)}
+	for i := 0; i < len(input); i++ {
+		d.first[i] = len(d.runs)
+		if input[i] != c {
+			continue
+		}
+		j := i + 1
+		for j < len(input) && input[j] == c {
+			d.first[j] = len(d.runs) + 1
+			j++
+		}
+		r, _ := utf8.DecodeRuneInString(input[j:])
+		d.runs = append(d.runs, delimRun{i, j, !isWordRune(r)})
+		i = j - 1
+	}
+	d.first[len(input)] = len(d.runs)
+	for f := range d.next {
+		next := make([]int, len(d.runs)+1)
+		next[len(d.runs)] = len(d.runs)
+		for i := len(d.runs) - 1; i >= 0; i-- {
+			r := d.runs[i]
+			if long, nonWord := r.end-r.start > 1, r.nonWord; f == runLong && long || f == runNonWord && nonWord || f == runLongNonWord && long && nonWord {
+				next[i] = i
+			} else {
+				next[i] = next[i+1]
+			}
+		}
+		d.next[f] = next
+	}
+	d.same = make([]int, len(d.runs))
+	last := make(map[int]int)
+	for i := len(d.runs) - 1; i >= 0; i-- {
+		n := d.runs[i].end - d.runs[i].start
+		if j, ok := last[n]; ok {
+			d.same[i] = j
+		} else {
+			d.same[i] = len(d.runs)
+		}
+		last[n] = i
+	}
+	return d
+}
+
+// closer returns the first run that has n(1 or 2) delimiters at or after
+// the index k. if nonWord is set, runs that are followed by a word character
+// are skipped.
+func (d *delimRuns) closer(k, n int, nonWord bool) (delimRun, bool) {
+	if k >= len(d.first) {
+		return delimRun{}, false
+	}
+	i := d.first[k]
+	// the run that contains k
+	if i > 0 {
+		if r := d.runs[i-1]; r.end-k >= n && (r.nonWord || !nonWord) {
+			return r, true
+		}
+	}
+	switch {
+	case n > 1 && nonWord:
+		i = d.next[runLongNonWord][i]
+	case n > 1:
+		i = d.next[runLong][i]
+	case nonWord:
+		i = d.next[runNonWord][i]
+	}
+	if i == len(d.runs) {
+		return delimRun{}, false
+	}
+	return d.runs[i], true
+}
+
+// delims returns the runs of the given delimiter character in the input.
+func (l *lexer) delims(c byte) *delimRuns {
+	if l.runs == nil {
+		l.runs = make(map[byte]*delimRuns)
+	}
+	d, ok := l.runs[c]
+	if !ok {
+		d = newDelimRuns(l.input, c)
+		l.runs[c] = d
+	}
+	return d
+}
+
+// isSpace tests if the given byte is a whitespace(\s in regexp).
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+// matchEmphasis returns the type and the length of the emphasis, the
+// strikethrough or the code span that starts with the delimiter c at the
+// current position, or 0 if it isn't closed. emphasis ends at the run that
+// holds its first closing delimiter. GFM underscores are extended to the
+// first run that isn't followed by a word character.
+func (l *lexer) matchEmphasis(c byte) (itemType, int) {
+	input, pos := l.input, int(l.pos)
+	d := l.delims(c)
+	at := func(i int) byte {
+		if i < len(input) {
+			return input[i]
+		}
+		return 0
+	}
+	switch c {
+	case '~':
+		if at(pos+1) != '~' {
+			return 0, 0
+		}
+		if r, ok := d.closer(pos+3, 2, false); ok {
+			if r.start < pos+3 {
+				r.start = pos + 3
+			}
+			return itemStrike, r.start + 2 - pos
+		}
+	case '`':
+		return itemCode, l.matchCode(d)
+	default:
+		nonWord := c == '_' && l.options.Gfm
+		if at(pos+1) == c && pos+2 < len(input) && !isSpace(input[pos+2]) {
+			if r, ok := d.closer(pos+3, 2, nonWord); ok {
+				return itemStrong, r.end - pos
+			}
+		}
+		if pos+1 < len(input) && !isSpace(input[pos+1]) {
+			if r, ok := d.closer(pos+2, 1, nonWord); ok {
+				return itemItalic, r.end - pos
+			}
+		}
+	}
+	return 0, 0
+}
+
+// matchCode returns the length of the code span at the current position,
+// or 0 if it isn't closed. a span that is opened by a run of backticks is
+// closed by the next run of the same length. otherwise, it's opened by one
+// or two backticks, and closed by one or two backticks that follow a
+// non-backtick character.
+func (l *lexer) matchCode(d *delimRuns) int {
+	input, pos := l.input, int(l.pos)
+	if i := d.first[pos]; i < len(d.runs) && d.runs[i].start == pos {
+		if j := d.same[i]; j < len(d.runs) {
+			return d.runs[j].end - pos
+		}
+	}
+	opens := []int{1}
+	if pos+1 < len(input) && input[pos+1] == '`' {
+		opens = []int{2, 1}
+	}
+	for _, open := range opens {
+		start := pos + open
+		for start < len(input) && isSpace(input[start]) {
+			start++
+		}
+		// the content starts after the leading whitespaces, or with the
+		// last of them if there's no other closing delimiter.
+		for _, start := range []int{start, start - 1} {
+			if start < pos+open {
+				break
+			}
+			if start+1 >= len(d.first) {
+				continue
+			}
+			if i := d.first[start+1]; i < len(d.runs) {
+				end := d.runs[i].start + 1
+				if end < len(input) && input[end] == '`' {
+					end++
+				}
+				return end - pos
+			}
+		}
+	}
+	return 0
+}
+
 // backup steps back one rune. Can only be called once per call of next.
 func (l *lexer) backup() {
 	l.pos -= l.width
@@ -269,20 +837,32 @@ func (l *lexer) emit(t itemType, s ...string) {
 	if len(s) == 0 {
 		s = append(s, l.input[l.start:l.pos])
 	}
-	l.items <- item{t, l.start, s[0]}
+	l.items = append(l.items, item{t, l.start, s[0]})
 	l.start = l.pos
 }
 
-// lexItem return the next item token, called by the parser.
-func (l *lexer) nextItem() item {
-	item := <-l.items
-	l.lastPos = l.pos
-	return item
+// nextItem returns the next item token, called by the parser. it runs the
+// state functions until an item is emitted, and returns itemEOF when the
+// lexing is done.
+func (l *lexer) nextItem() (it item) {
+	for len(l.items) == 0 {
+		if l.state == nil {
+			return item{itemEOF, l.pos, ""}
+		}
+		l.step()
+	}
+	it, l.items = l.items[0], l.items[1:]
+	l.lastPos = it.pos
+	return it
+}
+
+// isWordRune tests if the given rune is a letter or a digit.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
 }
 
 // One phase lexing(inline reason)
 func (l *lexer) lexInline() {
-	escape := regexp.MustCompile("^\\\\([\\`*{}\\[\\]()#+\\-.!_>~|])")
 	// Drain text before emitting
 	emit := func(item itemType, pos int) {
 		if l.pos > l.start {
@@ -293,6 +873,10 @@ func (l *lexer) lexInline() {
 	}
 Loop:
 	for {
+		if n := l.matchCustom(); n > 0 {
+			emit(itemCustomInline, n)
+			continue
+		}
 		switch r := l.peek(); r {
 		case eof:
 			if l.pos > l.start {
@@ -301,7 +885,7 @@ Loop:
 			break Loop
 		// backslash escaping
 		case '\\':
-			if m := escape.FindStringSubmatch(l.input[l.pos:]); len(m) != 0 {
+			if m := reEscape.FindStringSubmatch(l.input[l.pos:]); len(m) != 0 {
 				if l.pos > l.start {
 					l.emit(itemText)
 				}
@@ -318,41 +902,33 @@ Loop:
 			}
 			l.next()
 		case '_', '*', '~', '`':
-			input := l.input[l.pos:]
-			// Strong
-			if m := reStrong.FindString(input); m != "" {
-				emit(itemStrong, len(m))
-				break
-			}
-			// Italic
-			if m := reItalic.FindString(input); m != "" {
-				emit(itemItalic, len(m))
-				break
-			}
-			// Strike
-			if m := reStrike.FindString(input); m != "" {
-				emit(itemStrike, len(m))
+			// GFM: underscores inside words don't open emphasis(snake_case_name)
+			if prev, _ := utf8.DecodeLastRuneInString(l.input[:l.pos]); r == '_' && l.options.Gfm && isWordRune(prev) {
+				for l.peek() == '_' {
+					l.next()
+				}
 				break
 			}
-			// InlineCode
-			if m := reCode.FindString(input); m != "" {
-				emit(itemCode, len(m))
+			if typ, n := l.matchEmphasis(byte(r)); n > 0 {
+				emit(typ, n)
 				break
 			}
 			l.next()
 		// itemLink, itemImage, itemRefLink, itemRefImage
 		case '[', '!':
-			input := l.input[l.pos:]
-			if m := reLink.FindString(input); m != "" {
-				pos := len(m)
+			if n := l.matchLink(); n > 0 {
 				if r == '[' {
-					emit(itemLink, pos)
+					emit(itemLink, n)
 				} else {
-					emit(itemImage, pos)
+					emit(itemImage, n)
 				}
 				break
 			}
-			if m := reRefLink.FindString(input); m != "" {
+			if m := l.find(reRuby, "]{", 2); r == '[' && m != "" {
+				emit(itemRuby, len(m))
+				break
+			}
+			if m := l.matchRefLink(); m != "" {
 				pos := len(m)
 				if r == '[' {
 					emit(itemRefLink, pos)
@@ -362,26 +938,82 @@ Loop:
 				break
 			}
 			l.next()
+		// itemShortcode, itemTemplate, itemRuby
+		case '{':
+			if l.options.Shortcodes {
+				if n := matchShortcode(l.input[l.pos:]); n > 0 {
+					emit(itemShortcode, n)
+					break
+				}
+			}
+			if l.options.TemplateTags {
+				if m := reTemplateTag.FindString(l.input[l.pos:]); m != "" {
+					emit(itemTemplate, len(m))
+					break
+				}
+			}
+			if m := l.find(reRuby, "}", 4); m != "" {
+				emit(itemRuby, len(m))
+				break
+			}
+			l.next()
+		// itemEmoji
+		case ':':
+			if m := l.find(reEmoji, ":", 2); m != "" {
+				emit(itemEmoji, len(m))
+				break
+			}
+			l.next()
 		// itemAutoLink, htmlBlock
 		case '<':
-			if m := reAutoLink.FindString(l.input[l.pos:]); m != "" {
+			if m := l.find(reAutoLink, ">", 4); m != "" {
 				emit(itemAutoLink, len(m))
 				break
 			}
-			if match, res := l.matchHTML(l.input[l.pos:]); match {
-				emit(itemHTML, len(res))
-				break
+			if l.closes(">", 2) {
+				if match, res := l.matchHTML(l.input[l.pos:]); match {
+					emit(itemHTML, len(res))
+					break
+				}
 			}
 			l.next()
 		default:
-			if m := reGfmLink.FindString(l.input[l.pos:]); m != "" {
+			if m := gfmLink(l.input[l.pos:]); m != "" {
 				emit(itemGfmLink, len(m))
 				break
 			}
 			l.next()
 		}
 	}
-	close(l.items)
+}
+
+// matchCustom returns the length of the first CustomInline match at the
+// current position, or 0 if there's no such match. the next match of each
+// rule is cached, so the input isn't searched again at each position.
+func (l *lexer) matchCustom() int {
+	rules := l.options.CustomInline
+	if len(rules) == 0 {
+		return 0
+	}
+	if l.custom == nil {
+		l.custom = make([][2]int, len(rules))
+	}
+	pos := int(l.pos)
+	for i, rule := range rules {
+		next := &l.custom[i]
+		// the cached match was skipped, or it's the first search
+		if next[0] != -1 && (next[0] < pos || next[1] == 0) {
+			if loc := rule.Pattern.FindStringIndex(l.input[pos:]); loc != nil {
+				*next = [2]int{pos + loc[0], pos + loc[1]}
+			} else {
+				*next = [2]int{-1, -1}
+			}
+		}
+		if next[0] == pos && next[1] > pos {
+			return next[1] - pos
+		}
+	}
+	return 0
 }
 
 // lexHTML.
@@ -396,8 +1028,11 @@ func lexHTML(l *lexer) stateFn {
 
 // Test if the given input is match the HTML pattern(blocks only)
 func (l *lexer) matchHTML(input string) (bool, string) {
-	if m := reHTML.comment.FindString(input); m != "" {
-		return true, m
+	if strings.HasPrefix(input, "<!--") {
+		if !l.closes("-->", 4) {
+			return false, ""
+		}
+		return true, input[:4+strings.Index(input[4:], "-->")+3]
 	}
 	if m := reHTML.item.FindStringSubmatch(input); len(m) != 0 {
 		el, name := m[0], m[1]
@@ -409,17 +1044,128 @@ func (l *lexer) matchHTML(input string) (bool, string) {
 		if strings.HasSuffix(el, "/>") && !reAutoLink.MatchString(el) {
 			return true, el
 		}
-		if name == reHTML.CDATA_OPEN {
-			name = reHTML.CDATA_CLOSE
+		// the content of markdown blocks may hold elements of the same name
+		if l.options.MarkdownInHTML && reMarkdownAttr.MatchString(el) {
+			if end := l.elementEnd(); end != -1 {
+				return true, trimEndTag(input, end-int(l.pos))
+			}
 		}
-		reEndTag := reHTML.endTagGen(name)
-		if m := reEndTag.FindString(input); m != "" {
-			return true, m
+		end := "</" + name + ">"
+		if name == reHTML.CDATA_OPEN {
+			end = reHTML.CDATA_CLOSE
+			if !l.closes(end, 1) {
+				return false, ""
+			}
+		} else if !l.closesTag(name) {
+			return false, ""
 		}
+		return true, trimEndTag(input, 1+strings.Index(input[1:], end)+len(end))
 	}
 	return false, ""
 }
 
+// trimEndTag returns the html block of input that ends at n, and the
+// spaces that follow its end tag.
+func trimEndTag(input string, n int) string {
+	for n < len(input) && input[n] == ' ' {
+		n++
+	}
+	return input[:n]
+}
+
+// closesTag tests if the closing tag of the given element appears in the
+// input after the current position. the closing tags are indexed once, so
+// unclosed elements are skipped without searching the rest of the input.
+func (l *lexer) closesTag(name string) bool {
+	if l.ends == nil {
+		l.ends = make(map[string]int)
+		scanTags(l.input, func(i, _ int, name string, closing bool) {
+			if closing {
+				l.ends[name] = i
+			}
+		})
+	}
+	i, ok := l.ends[name]
+	return ok && i > int(l.pos)
+}
+
+// elementEnd returns the end of the closing tag of the element that is
+// opened at the current position, or -1 if it's not closed. nested elements
+// of the same name are matched first, using a stack for each name.
+func (l *lexer) elementEnd() int {
+	if l.tags == nil {
+		l.tags = make(map[int]int)
+		stacks := make(map[string][]int)
+		scanTags(l.input, func(i, end int, name string, closing bool) {
+			stack := stacks[name]
+			switch n := len(stack); {
+			case !closing:
+				stacks[name] = append(stack, i)
+			case n > 0:
+				l.tags[stack[n-1]], stacks[name] = end, stack[:n-1]
+			}
+		})
+	}
+	if end, ok := l.tags[int(l.pos)]; ok {
+		return end
+	}
+	return -1
+}
+
+// scanTags calls fn with the html tags of s, their index, the index after
+// the tag(or after the name of opening tags), their name and whether they
+// are closing tags.
+func scanTags(s string, fn func(i, end int, name string, closing bool)) {
+	for i := strings.IndexByte(s, '<'); i != -1; {
+		j := i + 1
+		closing := j < len(s) && s[j] == '/'
+		if closing {
+			j++
+		}
+		k := j
+		for k < len(s) && isWordByte(s[k]) {
+			k++
+		}
+		switch {
+		case k == j || k == len(s):
+		case closing && s[k] == '>':
+			fn(i, k+1, s[j:k], true)
+		case !closing && (s[k] == '>' || s[k] == '/' || isSpace(s[k])):
+			fn(i, k, s[j:k], false)
+		}
+		next := strings.IndexByte(s[i+1:], '<')
+		if next == -1 {
+			break
+		}
+		i += 1 + next
+	}
+}
+
+// tagCloser returns a function that is called with the lines of an html
+// block, starting with its opening line, and reports whether the element
+// of the given name is closed. nested elements of the same name are
+// counted, like in lexer.elementEnd.
+func tagCloser(name string) func(line string) bool {
+	var depth int
+	return func(line string) bool {
+		scanTags(line, func(_, _ int, tag string, closing bool) {
+			switch {
+			case tag != name:
+			case closing:
+				depth--
+			default:
+				depth++
+			}
+		})
+		return depth <= 0
+	}
+}
+
+// isWordByte tests if the given byte is a word character(\w in regexp).
+func isWordByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
 // lexDefLink scans link definition
 func lexDefLink(l *lexer) stateFn {
 	if m := reDefLink.FindString(l.input[l.pos:]); m != "" {
@@ -450,9 +1196,10 @@ func lexList(l *lexer) stateFn {
 		item = reList.marker.ReplaceAllString(item, "")
 		// Indented
 		if strings.Contains(item, "\n ") {
-			space -= len(item)
-			reSpace := reSpaceGen(space)
-			item = reSpace.ReplaceAllString(item, "")
+			item = trimIndent(item, l.listIndent(space-len(item)))
+			// the tabs that were kept after the indentation of code blocks
+			// (see indentTabs) may be part of the indentation of the item
+			item = reIndentTab.ReplaceAllLiteralString(item, "    ")
 		}
 		// If current is loose
 		for _, l := range reList.loose.FindAllString(item, -1) {
@@ -476,59 +1223,204 @@ func (l *lexer) matchList(input string) (bool, []string) {
 	if !reItem.MatchString(input) {
 		return false, res
 	}
-	// First item
+	// First item. the items are slices of the input, the current
+	// one is src[start:pos].
 	m := reItem.FindStringSubmatch(input)
-	item, depth := m[0], len(m[1])
-	input = input[len(item):]
-	// Loop over the input
+	src, depth := input, len(m[1])
+	start, pos := 0, len(m[0])
+	input = src[pos:]
+	// the state of the current item, used to find its lazy continuation lines
+	var s blockState
+	s.next(m[2])
+	width := l.listIndent(len(strings.TrimSuffix(m[0], "\n")) - len(m[2]))
+	// Loop over the input. the items are matched line by line, so
+	// the regexps don't scan the rest of the input.
+Loop:
 	for len(input) > 0 {
 		// Count new-lines('\n')
 		if m := reList.scanNewLine(input); m != "" {
-			item += m
-			input = input[len(m):]
-			if len(m) >= 2 || !reItem.MatchString(input) && !strings.HasPrefix(input, " ") {
+			pos += len(m)
+			input = src[pos:]
+			if len(m) >= 2 || !reItem.MatchString(firstLine(input)) && !strings.HasPrefix(input, " ") {
 				break
 			}
+			s.next("")
 		}
+		line := firstLine(input)
 		// DefLink or hr
-		if reDefLink.MatchString(input) || reHr.MatchString(input) {
+		if reHr.MatchString(line) || strings.HasPrefix(strings.TrimLeft(line, " "), "[") && reDefLink.MatchString(input) {
 			break
 		}
+		m := reItem.FindStringSubmatch(line)
+		text := strings.TrimSuffix(line, "\n")
+		switch {
 		// It's list in the same depth
-		if m := reItem.FindStringSubmatch(input); len(m) > 0 && len(m[1]) == depth {
-			if item != "" {
-				res = append(res, item)
+		case len(m) > 0 && len(m[1]) == depth:
+			if pos > start {
+				res = append(res, src[start:pos])
 			}
-			item = m[0]
-			input = input[len(item):]
-		} else {
-			m := reList.scanLine(input)
-			item += m
-			input = input[len(m):]
+			start, pos = pos, pos+len(m[0])
+			s = blockState{}
+			s.next(m[2])
+			width = l.listIndent(len(strings.TrimSuffix(m[0], "\n")) - len(m[2]))
+			input = src[pos:]
+			continue
+		// nested list item
+		case len(m) > 0:
+			s.next(m[2])
+		// lazy continuation line of a paragraph
+		case !strings.HasPrefix(line, " "):
+			if !s.para || interrupts(text) {
+				break Loop
+			}
+		default:
+			indent := len(text) - len(strings.TrimLeft(text, " "))
+			if indent > width {
+				indent = width
+			}
+			s.next(text[indent:])
 		}
+		pos += len(line)
+		input = src[pos:]
 	}
 	// Drain res
-	if item != "" {
-		res = append(res, item)
+	if pos > start {
+		res = append(res, src[start:pos])
 	}
 	return true, res
 }
 
-// Test if the given input match blockquote
+// listIndent returns the indentation of the continuation lines of a list
+// item, whose marker has the given width(see Options.ListIndent).
+func (l *lexer) listIndent(width int) int {
+	if l.options.ListIndent > 0 {
+		return l.options.ListIndent
+	}
+	return width
+}
+
+// closeFence returns the content of the fenced code block that starts
+// at the given input(after its opening line), and the line that closes it.
+// the closing line is empty if the code block isn't closed.
+func closeFence(input, fence string) (code, closing string) {
+	for i := strings.IndexByte(input, '\n'); i != -1; {
+		line := input[i+1:]
+		j := strings.IndexByte(line, '\n')
+		if j != -1 {
+			line = line[:j]
+		}
+		if closesFence(line, fence) {
+			return input[:i+1], line
+		}
+		if j == -1 {
+			break
+		}
+		i += 1 + j
+	}
+	return input, ""
+}
+
+// trimIndent removes up to n leading spaces from each line of s.
+func trimIndent(s string, n int) string {
+	var b strings.Builder
+	for len(s) > 0 {
+		line := firstLine(s)
+		s = s[len(line):]
+		i := 0
+		for i < n && i < len(line) && line[i] == ' ' {
+			i++
+		}
+		b.WriteString(line[i:])
+	}
+	return b.String()
+}
+
+// firstLine returns the first line of s, including its new-line.
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i != -1 {
+		return s[:i+1]
+	}
+	return s
+}
+
+// Test if the given input match blockquote. the lines without a ">"
+// marker are part of the blockquote only if they continue its paragraph
+// (lazy continuation lines).
 func (l *lexer) matchBlockQuote(input string) (bool, string) {
-	match := reBlockQuote.FindString(input)
-	if match == "" {
-		return false, match
+	if !reBlockQuote.MatchString(input) {
+		return false, ""
 	}
-	lines := strings.Split(match, "\n")
-	for i, line := range lines {
+	var s blockState
+	var end int
+Loop:
+	for pos := 0; pos < len(input); {
+		line := firstLine(input[pos:])
+		text := strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.TrimSpace(text) == "":
+			break Loop
+		case reBlockQuote.MatchString(text):
+			s.next(text[len(reQuoteMarks.FindString(text)):])
 		// if line is a link-definition or horizontal role, we cut the match until this point
-		if reDefLink.MatchString(line) || reHr.MatchString(line) {
-			match = strings.Join(lines[0:i], "\n")
-			break
+		case !s.para || reDefLink.MatchString(line) || reHr.MatchString(line) || interrupts(text):
+			break Loop
+		}
+		pos += len(line)
+		end = pos - (len(line) - len(text))
+	}
+	// the blank lines that follow the blockquote
+	for end < len(input) && input[end] == '\n' {
+		end++
+	}
+	return true, input[:end]
+}
+
+// blockState tracks the innermost block of a container(e.g: blockquote),
+// line by line, to find its lazy continuation lines.
+type blockState struct {
+	fence string // the open code fence, if any
+	para  bool   // the last line is a paragraph line
+}
+
+// next updates the state with a line of the container content, without
+// its container markers.
+func (s *blockState) next(line string) {
+	trimmed := strings.TrimLeft(line, " ")
+	indent := len(line) - len(trimmed)
+	switch {
+	case s.fence != "":
+		if indent < 4 && strings.HasPrefix(trimmed, s.fence) && strings.Trim(trimmed, s.fence[:1]+" ") == "" {
+			s.fence = ""
 		}
+		s.para = false
+	case strings.TrimSpace(line) == "":
+		s.para = false
+	case indent >= 4:
+		// indented code, or a paragraph line
+	case reFence.MatchString(line):
+		s.fence, s.para = reFence.FindStringSubmatch(line)[1], false
+	case reHr.MatchString(trimmed) || reInterrupt.MatchString(line) && trimmed[0] == '#':
+		s.para = false
+	default:
+		s.para = true
 	}
-	return true, match
+}
+
+// interrupts tests if the given line starts a block that interrupts a
+// paragraph, and can't be its lazy continuation.
+func interrupts(line string) bool {
+	if reInterrupt.MatchString(line) {
+		return true
+	}
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 || !strings.HasPrefix(trimmed, "<") {
+		return false
+	}
+	if strings.HasPrefix(trimmed, "<!--") {
+		return true
+	}
+	m := reHTML.item.FindStringSubmatch(trimmed)
+	return m != nil && !reHTML.span.MatchString(m[1])
 }
 
 // lexBlockQuote
@@ -541,10 +1433,80 @@ func lexBlockQuote(l *lexer) stateFn {
 	return lexText
 }
 
+// lexContainer scans a fenced container, a details block or a div, from
+// its opening line until its closing ":::" line, or the end of the input.
+func lexContainer(t itemType) stateFn {
+	return func(l *lexer) stateFn {
+		closed := containerCloser(l.options)
+		for _, line := range strings.SplitAfter(l.input[l.pos:], "\n") {
+			l.pos += Pos(len(line))
+			if closed(line) {
+				break
+			}
+		}
+		l.emit(t)
+		return lexAny
+	}
+}
+
+// containerCloser returns a function that is called with the lines of a
+// fenced container, starting with its opening line, and reports whether
+// the container is closed. nested containers are counted, and the lines
+// of fenced code blocks are skipped.
+func containerCloser(opts *Options) func(line string) bool {
+	var depth int
+	var fence string
+	return func(line string) bool {
+		switch {
+		case fence != "":
+			if closesFence(line, fence) {
+				fence = ""
+			}
+		case opensContainer(line, opts):
+			depth++
+		case closesContainer(line):
+			depth--
+		default:
+			if m := reFence.FindStringSubmatch(line); m != nil {
+				fence = m[1]
+			}
+		}
+		return depth == 0
+	}
+}
+
+// opensContainer tests if the given line opens a fenced container.
+func opensContainer(line string, opts *Options) bool {
+	return opts.Details && strings.HasPrefix(line, ":::details") || opts.Divs && reDiv.MatchString(line)
+}
+
+// closesContainer tests if the given line is a closing fence, three or
+// more colons.
+func closesContainer(line string) bool {
+	return reDivEnd.MatchString(strings.TrimSpace(line))
+}
+
+// lexTabs scans a group of consecutive tabs. each tab starts with
+// a `=== "Title"` line, followed by its content indented by 4 spaces.
+func lexTabs(l *lexer) stateFn {
+	for {
+		l.pos += Pos(len(reTab.FindString(l.input[l.pos:])))
+		// tabs may be separated by blank lines
+		input := l.input[l.pos:]
+		blank := len(input) - len(strings.TrimLeft(input, " \n"))
+		if !reTab.MatchString(input[blank:]) {
+			break
+		}
+		l.pos += Pos(blank)
+	}
+	l.emit(itemTabs)
+	return lexAny
+}
+
 // lexTable
 func lexTable(l *lexer) stateFn {
 	re := reTable.item
-	if l.peek() == '|' {
+	if l.peek() == '|' && isTable(reTable.itemLp, l.input[l.pos:]) {
 		re = reTable.itemLp
 	}
 	table := re.FindStringSubmatch(l.input[l.pos:])
@@ -557,12 +1519,70 @@ func lexTable(l *lexer) stateFn {
 			continue
 		}
 		l.emit(itemTableRow)
-		rawCells := reTable.trim(row, "")
-		cells := reTable.split(rawCells, -1)
 		// Emit cells in the current row
-		for _, cell := range cells {
+		for _, cell := range splitCells(row) {
 			l.emit(itemTableCell, cell)
 		}
 	}
 	return lexAny
 }
+
+// gfmLink returns the bare url at the start of the given input, without
+// its trailing punctuation. a trailing ")" is kept only if it closes an
+// opening "(" in the url, and a trailing entity reference is not part of it.
+func gfmLink(input string) string {
+	s := reGfmLink.FindString(input)
+	for s != "" {
+		switch c := s[len(s)-1]; {
+		case strings.IndexByte("?!.,:*_~'\"]", c) != -1:
+			s = s[:len(s)-1]
+		case c == ')' && strings.Count(s, ")") > strings.Count(s, "("):
+			s = s[:len(s)-1]
+		case c == ';':
+			if m := reEntity.FindString(s); m != "" {
+				s = s[:len(s)-len(m)]
+			} else {
+				s = s[:len(s)-1]
+			}
+		default:
+			// the url must have a host
+			if strings.HasSuffix(s, "://") {
+				return ""
+			}
+			return s
+		}
+	}
+	return s
+}
+
+// isTable tests if the given input starts with a table. the header row
+// must match the delimiter row in the number of cells.
+func isTable(re *regexp.Regexp, input string) bool {
+	m := re.FindStringSubmatch(input)
+	return m != nil && len(splitCells(m[1])) == len(splitCells(m[2]))
+}
+
+// splitCells splits a table row into cells by its unescaped pipes, ignoring
+// the leading and the trailing ones. escaped pipes("\|") are unescaped, even
+// inside code spans, so they don't break the column splitting.
+func splitCells(row string) (cells []string) {
+	row = strings.TrimSpace(row)
+	row = strings.TrimPrefix(row, "|")
+	if strings.HasSuffix(row, "|") && !strings.HasSuffix(row, "\\|") {
+		row = row[:len(row)-1]
+	}
+	var cell string
+	for i := 0; i < len(row); i++ {
+		switch {
+		case row[i] == '\\' && i+1 < len(row) && row[i+1] == '|':
+			cell += "|"
+			i++
+		case row[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell))
+			cell = ""
+		default:
+			cell += row[i : i+1]
+		}
+	}
+	return append(cells, strings.TrimSpace(cell))
+}
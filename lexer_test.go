@@ -1,52 +1,11 @@
 package mark
 
 import (
-	"fmt"
+	"reflect"
+	"runtime"
 	"testing"
 )
 
-var itemName = map[itemType]string{
-	itemError:        "Error",
-	itemEOF:          "EOF",
-	itemNewLine:      "NewLine",
-	itemHTML:         "HTML",
-	itemHeading:      "Heading",
-	itemLHeading:     "LHeading",
-	itemBlockQuote:   "BlockQuote",
-	itemList:         "List",
-	itemListItem:     "ListItem",
-	itemLooseItem:    "LooseItem",
-	itemCodeBlock:    "CodeBlock",
-	itemGfmCodeBlock: "GfmCodeBlock",
-	itemHr:           "Hr",
-	itemTable:        "Table",
-	itemLpTable:      "LpTable",
-	itemTableRow:     "TableRow",
-	itemTableCell:    "TableCell",
-	itemText:         "Text",
-	itemLink:         "Link",
-	itemDefLink:      "DefLink",
-	itemRefLink:      "RefLink",
-	itemAutoLink:     "AutoLink",
-	itemGfmLink:      "GfmLink",
-	itemStrong:       "Strong",
-	itemItalic:       "Italic",
-	itemStrike:       "Strike",
-	itemCode:         "Code",
-	itemImage:        "Image",
-	itemRefImage:     "RefImage",
-	itemBr:           "Br",
-	itemPipe:         "Pipe",
-}
-
-func (i itemType) String() string {
-	s := itemName[i]
-	if s == "" {
-		return fmt.Sprintf("item%d", int(i))
-	}
-	return s
-}
-
 type lexTest struct {
 	name  string
 	input string
@@ -246,11 +205,16 @@ var inlineTests = []lexTest{
 
 // collect gathers the emitted items into a slice.
 func collect(t *lexTest, isInline bool) (items []item) {
-	l := lex(t.input)
+	l := lex(t.input, nil)
 	if isInline {
-		l = lexInline(t.input)
+		l = lexInline(t.input, nil)
 	}
-	for item := range l.items {
+	for {
+		item := l.nextItem()
+		// the inline lexer doesn't emit itemEOF
+		if isInline && item.typ == itemEOF {
+			break
+		}
 		items = append(items, item)
 		if item.typ == itemEOF || item.typ == itemError {
 			break
@@ -319,3 +283,56 @@ func TestPos(t *testing.T) {
 		}
 	}
 }
+
+// abandoning a lexer midway must not leave anything running behind.
+func TestAbandonedLexer(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		lex("# foo\n\nbar *baz*\n\n- a\n- b\n", nil).nextItem()
+		lexInline("foo **bar** [baz](qux)", nil).nextItem()
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("goroutines: got\n\t%+v\nexpected\n\t%+v", after, before)
+	}
+	l := lex("foo", nil)
+	for i := 0; i < 3; i++ {
+		l.nextItem()
+	}
+	if item := l.nextItem(); item.typ != itemEOF {
+		t.Errorf("exhausted lexer: got\n\t%+v\nexpected\n\t%+v", item, itemEOF)
+	}
+}
+
+// the closing tags of shortcodes are matched without building a regexp
+// from the (untrusted) names in the document.
+func TestShortcodeEnd(t *testing.T) {
+	for _, c := range []struct {
+		input, name string
+		expected    []int
+	}{
+		{"x{{< /a >}}y", "a", []int{1, 11}},
+		{"{{%/a%}}", "a", []int{0, 8}},
+		{"{{<\t/a.b\n>}}", "a.b", []int{0, 12}},
+		{"{{< /ab >}}{{< /a >}}", "a", []int{11, 21}},
+		{"{{{< /a >}}", "a", []int{1, 11}},
+		{"{{< a >}}{{ /a }}", "a", nil},
+		{"{{< /a >}", "a", nil},
+		{"{{< /(a|b) >}}", "a", nil},
+		{"{{< /(a|b) >}}", "(a|b)", []int{0, 14}},
+	} {
+		if actual := shortcodeEnd(c.input, c.name); !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", c.input, actual, c.expected)
+		}
+	}
+}
+
+func TestTokenNames(t *testing.T) {
+	for typ := itemError; typ <= itemTemplate; typ++ {
+		if TokenNames[typ] == "" {
+			t.Errorf("TokenNames: missing name for item%d", int(typ))
+		}
+	}
+	if actual := TokenType(itemStrong).String(); actual != "Strong" {
+		t.Errorf("TokenType.String: got\n\t%+v\nexpected\n\t%+v", actual, "Strong")
+	}
+}
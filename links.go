@@ -0,0 +1,180 @@
+package mark
+
+import (
+	"fmt"
+	"html"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+)
+
+// BrokenLink is an internal link whose target document or heading
+// doesn't exist.
+type BrokenLink struct {
+	Doc    string // The name of the document that holds the link
+	Href   string
+	Span   Span // The source position of the innermost block that holds the link
+	Reason string
+}
+
+// String returns the broken link in the "doc:span: href: reason" format.
+func (l BrokenLink) String() string {
+	return fmt.Sprintf("%s:%s: %s: %s", l.Doc, l.Span, l.Href, l.Reason)
+}
+
+// IDs returns the set of the heading ids of the document.
+func (d *DocumentNode) IDs() map[string]bool {
+	ids := make(map[string]bool)
+	for _, n := range d.Select(NodeHeading) {
+		ids[n.(*HeadingNode).ID()] = true
+	}
+	return ids
+}
+
+// CheckLinks validates the internal links of the given documents, that are
+// keyed by their slash-separated paths(e.g: "guide/intro.md"), and returns
+// the links whose target document or heading doesn't exist, ordered by
+// document name. fragment links("#section") are checked against the
+// headings of their document, and relative links("../other.md#section")
+// are resolved against the path of their document. links to files with
+// another extension(e.g: images) and external links are ignored.
+func CheckLinks(docs map[string]*DocumentNode) (broken []BrokenLink) {
+	ids := make(map[string]map[string]bool, len(docs))
+	names := make([]string, 0, len(docs))
+	for name, d := range docs {
+		ids[name] = d.IDs()
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		docs[name].walkLinks(func(href string, image bool, span Span) {
+			if image {
+				return
+			}
+			if reason := checkLink(name, href, ids); reason != "" {
+				broken = append(broken, BrokenLink{name, href, span, reason})
+			}
+		})
+	}
+	return
+}
+
+// CheckFiles parses the markdown files(".md" and ".markdown") of fsys, and
+// returns their local links and images whose target doesn't exist in fsys,
+// ordered by file name. relative targets are resolved against the directory
+// of their file, and absolute targets("/img/a.png") against the root of fsys.
+func CheckFiles(fsys fs.FS, opts *Options) ([]BrokenLink, error) {
+	var broken []BrokenLink
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if ext := path.Ext(name); ext != ".md" && ext != ".markdown" {
+			return nil
+		}
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		Parse(string(b), opts).walkLinks(func(href string, _ bool, span Span) {
+			if reason := checkFile(fsys, name, href); reason != "" {
+				broken = append(broken, BrokenLink{name, href, span, reason})
+			}
+		})
+		return nil
+	})
+	return broken, err
+}
+
+// checkFile returns the reason the given link of the named file is broken,
+// or an empty string if its target exists or it isn't local.
+func checkFile(fsys fs.FS, name, href string) string {
+	target, ok := localPath(href)
+	if !ok {
+		return ""
+	}
+	if path.IsAbs(target) {
+		target = target[1:]
+	} else {
+		target = path.Join(path.Dir(name), target)
+	}
+	if target == "" {
+		target = "."
+	}
+	if !fs.ValidPath(target) {
+		return fmt.Sprintf("file %q is outside of the file system", target)
+	}
+	if _, err := fs.Stat(fsys, target); err != nil {
+		return fmt.Sprintf("file %q not found", target)
+	}
+	return ""
+}
+
+// checkLink returns the reason the given link of the named document is
+// broken, or an empty string if it isn't broken or isn't internal.
+func checkLink(name, href string, ids map[string]map[string]bool) string {
+	u, err := url.Parse(html.UnescapeString(href))
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Opaque != "" {
+		return ""
+	}
+	target := name
+	if u.Path != "" {
+		if path.Ext(u.Path) != path.Ext(name) {
+			return ""
+		}
+		if path.IsAbs(u.Path) {
+			target = path.Clean(u.Path[1:])
+		} else {
+			target = path.Join(path.Dir(name), u.Path)
+		}
+		if _, ok := ids[target]; !ok {
+			return fmt.Sprintf("document %q not found", target)
+		}
+	}
+	if u.Fragment != "" && !ids[target][u.Fragment] {
+		return fmt.Sprintf("heading %q not found in %q", u.Fragment, target)
+	}
+	return ""
+}
+
+// localPath returns the cleaned path of the given url, if it's a local
+// path(without a scheme or a host).
+func localPath(src string) (string, bool) {
+	u, err := url.Parse(html.UnescapeString(src))
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Opaque != "" || u.Path == "" {
+		return "", false
+	}
+	return path.Clean(u.Path), true
+}
+
+// walkLinks calls fn with the href of each link and image in the
+// document(including resolved references), and the source position of the
+// innermost block that holds it.
+func (d *DocumentNode) walkLinks(fn func(href string, image bool, span Span)) {
+	var walk func(n Node, span Span)
+	walk = func(n Node, span Span) {
+		if s, ok := d.spans[n]; ok {
+			span = s
+		}
+		switch n := n.(type) {
+		case *LinkNode:
+			fn(n.Href, false, span)
+		case *ImageNode:
+			fn(n.Src, true, span)
+		case *RefNode:
+			switch l := n.resolve().(type) {
+			case *LinkNode:
+				fn(l.Href, false, span)
+			case *ImageNode:
+				fn(l.Src, true, span)
+			}
+		}
+		for _, child := range Children(n) {
+			walk(child, span)
+		}
+	}
+	for _, n := range d.Nodes {
+		walk(n, Span{})
+	}
+}
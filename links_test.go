@@ -0,0 +1,54 @@
+package mark
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCheckLinks(t *testing.T) {
+	docs := map[string]*DocumentNode{
+		"index.md": Parse("# Home\n\nSee [intro](guide/intro.md), [setup](guide/intro.md#setup) and [top](#home).\n\n"+
+			"> [gone](missing.md) and [typo][ref]\n\n[ref]: guide/intro.md#stup", nil),
+		"guide/intro.md": Parse("# Intro\n\n## Setup\n\n- [back](../index.md#home)\n- [bad](#nothing)\n\n"+
+			"![img](img.png) [site](https://example.com/x.md) [abs](/index.md)", nil),
+	}
+	var actual []string
+	for _, l := range CheckLinks(docs) {
+		actual = append(actual, l.String())
+	}
+	expected := []string{
+		`guide/intro.md:6:1-6:17: #nothing: heading "nothing" not found in "guide/intro.md"`,
+		`index.md:5:3-5:36: missing.md: document "missing.md" not found`,
+		`index.md:5:3-5:36: guide/intro.md#stup: heading "stup" not found in "guide/intro.md"`,
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("CheckLinks: got\n\t%s\nexpected\n\t%s", strings.Join(actual, "\n\t"), strings.Join(expected, "\n\t"))
+	}
+}
+
+func TestCheckFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"README.md":          {Data: []byte("[guide](guide/intro.md) [dir](guide/) [top](#top) [gone](gone.md)\n\n![logo](/img/logo%20big.png)")},
+		"guide/intro.md":     {Data: []byte("- ![a](../img/a.png)\n- [b][b] [web](https://example.com/x.md)\n\n[b]: ../../b.md")},
+		"guide/notes.txt":    {Data: []byte("[ignored](nothing.md)")},
+		"img/logo big.png":   {},
+		"img/not-a-link.png": {},
+	}
+	report, err := CheckFiles(fsys, nil)
+	if err != nil {
+		t.Fatalf("CheckFiles: unexpected error: %v", err)
+	}
+	var actual []string
+	for _, l := range report {
+		actual = append(actual, l.String())
+	}
+	expected := []string{
+		`README.md:1:1-1:65: gone.md: file "gone.md" not found`,
+		`guide/intro.md:1:1-1:20: ../img/a.png: file "img/a.png" not found`,
+		`guide/intro.md:2:1-2:40: ../../b.md: file "../b.md" is outside of the file system`,
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("CheckFiles: got\n\t%s\nexpected\n\t%s", strings.Join(actual, "\n\t"), strings.Join(expected, "\n\t"))
+	}
+}
@@ -1,60 +1,1024 @@
 package mark
 
-import "strings"
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
 
 // Mark
 type Mark struct {
 	*parse
-	Input string
+	Input    string
+	mu       sync.Mutex
+	parsed   bool
+	fromDoc  bool // created by FromDocument
+	mappings []Mapping
+	metrics  Metrics // parse measurements, used by Options.Metrics
 }
 
-// Mark options used to configure your Mark object
-// set `Smartypants` and `Fractions` to true to enable
-// smartypants and smartfractions rendering.
+// Metrics are the measurements of a render, passed to Options.Metrics.
+// the input is parsed only once per Mark, so Lex and Parse are the same
+// for all its renders.
+type Metrics struct {
+	Lex    time.Duration // time spent in the lexers
+	Parse  time.Duration // time spent in the parser, without lexing
+	Render time.Duration
+	Nodes  int // number of nodes in the document, including nested ones
+}
+
+// Cache stores rendered outputs by a key, that is the hash of the input
+// and the options. it must be safe for concurrent use.
+type Cache interface {
+	Get(key string) (string, bool)
+	Set(key, value string)
+}
+
+// VoidStyle is the style of the emitted void elements(<br>, <hr>, <img>
+// and <input>).
+type VoidStyle int
+
+// Void element styles
+const (
+	VoidHTML       VoidStyle = iota // <br>
+	VoidSlash                       // <br/>
+	VoidSpaceSlash                  // <br />
+	VoidXHTML                       // <br />, and boolean attributes with values(checked="checked")
+)
+
+// EntityStyle is the output form of the non-ASCII characters.
+type EntityStyle int
+
+// Entity styles
+const (
+	EntitiesAsIs  EntityStyle = iota // as they appear in the input
+	EntitiesASCII                    // numeric entities(&#233;), for ASCII-only sinks
+	EntitiesUTF8                     // UTF-8, entities of non-ASCII characters(&eacute;) are decoded
+	EntitiesXML                      // numeric entities(&#233;) for the named entities that XML doesn't define
+)
+
+// SlugStyle is the algorithm that generates the heading ids.
+type SlugStyle int
+
+// Slug styles
+const (
+	SlugASCII         SlugStyle = iota // runs of characters other than ASCII letters, digits and "_" become "-"(Café to caf-)
+	SlugUnicode                        // GitHub: letters and digits of any script are kept, and spaces become "-"(Café to café)
+	SlugTransliterate                  // like SlugUnicode, with Latin and Cyrillic letters transliterated to ASCII(Café to cafe)
+)
+
+// Symbols is a set of typographic symbol replacements, used by
+// Options.Symbols. the replacements can be combined with "|".
+type Symbols int
+
+// Symbol replacements
+const (
+	SymbolRightArrow     Symbols = 1 << iota // -> to →
+	SymbolLeftArrow                          // <- to ←
+	SymbolLeftRightArrow                     // <-> to ↔
+	SymbolCopyright                          // (c) to ©
+	SymbolTrademark                          // (tm) to ™
+	SymbolRegistered                         // (r) to ®
+	SymbolPlusMinus                          // +- to ±
+
+	SymbolArrows = SymbolRightArrow | SymbolLeftArrow | SymbolLeftRightArrow
+	SymbolsAll   = SymbolArrows | SymbolCopyright | SymbolTrademark | SymbolRegistered | SymbolPlusMinus
+)
+
+// Replacement is a text replacement rule, used by Options.Replacements.
+type Replacement struct {
+	Old    string         // The literal text to replace, used if Regexp is nil
+	Regexp *regexp.Regexp // The pattern to replace, "$1" in New is expanded to its first group
+	New    string
+}
+
+// replace applies the rule to s.
+func (r Replacement) replace(s string) string {
+	if r.Regexp != nil {
+		return r.Regexp.ReplaceAllString(s, r.New)
+	}
+	return strings.Replace(s, r.Old, r.New, -1)
+}
+
+// CustomInline is an inline extension, used by Options.CustomInline.
+type CustomInline struct {
+	Pattern *regexp.Regexp
+	// Render returns the html of a match, that is emitted as is. match
+	// holds the matched text and its groups, like FindStringSubmatch.
+	Render func(match []string) string
+}
+
+// Zone is a set of text zones, used by Options.TypographerZones. the zones
+// can be combined with "|".
+type Zone int
+
+// Text zones
+const (
+	ZoneCode Zone = 1 << iota // code spans
+	ZoneURLs                  // the text of autolinks(e.g: <http://example.com>)
+	ZoneHTML                  // the text of inline html, outside its tags and comments
+)
+
+// Mark options used to configure your Mark object.
+// when passed to New, zero values are replaced with their defaults, unless
+// the options were created by DefaultOptions(in this case they are used as is).
+// so a false Gfm or Tables in a partial Options means the default(true), and
+// NoGfm or NoTables turn them off.
 type Options struct {
-	Gfm         bool
-	Tables      bool
+	Gfm    bool
+	Tables bool
+	// NoGfm and NoTables turn off Gfm and Tables, also if the defaults or
+	// the options set them. NoGfm turns off Tables too, since they require Gfm.
+	NoGfm    bool
+	NoTables bool
+	// Smartypants and Fractions enable smartypants and smartfractions rendering.
 	Smartypants bool
 	Fractions   bool
+	// Locale selects the quotation marks used by smartypants,
+	// e.g: "fr" for « », "de" for „ “ and "ja" for 「 」.
+	Locale string
+	// FullWidth makes smartypants handle the full-width quotes(＂, ＇)
+	// like the ascii ones, and replace dashes next to CJK characters
+	// with a full-width dash(――).
+	FullWidth bool
+	// Symbols enables the given typographic symbol replacements in text,
+	// e.g: SymbolArrows|SymbolCopyright. "(c)", "(tm)" and "(r)" are
+	// case-insensitive. code spans, code blocks and html tags are kept as is.
+	Symbols Symbols
+	// TypographerZones enables the text options(Smartypants, Fractions,
+	// Symbols and Replacements) in the given zones, where they are skipped
+	// by default, e.g: ZoneCode to apply them to code spans too. they are
+	// never applied to code blocks, raw regions, link urls and html tags.
+	TypographerZones Zone
+	// Replacements are applied in order to the text, e.g: to enforce house
+	// style("e-mail" to "email"). they run before Smartypants, and code
+	// spans, code blocks, html tags and link destinations are kept as is.
+	Replacements []Replacement
+	// SourcePos annotates block elements with their source
+	// position(data-sourcepos="1:1-2:5"). columns are byte offsets
+	// in the lines of the input, as given(i.e: tabs aren't expanded).
+	SourcePos bool
+	// HardWrap renders the line breaks in paragraphs as <br>.
+	HardWrap bool
+	// BaseURL resolves the relative urls of links and images against it,
+	// e.g: "https://example.com/blog/" for feed readers, where relative urls
+	// have no base. see FeedOptions.
+	BaseURL string
+	// Sanitize removes link and image urls with an unsafe scheme,
+	// such as "javascript:", "vbscript:" and "data:"(except images).
+	Sanitize bool
+	// StrictAttrs drops the attribute values(e.g: href, src, title and alt)
+	// that can't be escaped safely, instead of escaping them. these are the
+	// values that hold raw html markup(e.g: a tag in the alt text of an
+	// image), control characters or invalid utf-8. dropped urls are rendered
+	// as empty, like the urls that are removed by Sanitize.
+	StrictAttrs bool
+	// JoinCJKLines joins lines that were broken between two CJK
+	// characters, without inserting a space or a line break.
+	JoinCJKLines bool
+	// Ruby enables ruby annotations, "{漢字|かんじ}" or "[漢字]{かんじ}"
+	// are rendered as <ruby>漢字<rt>かんじ</rt></ruby>.
+	Ruby bool
+	// CustomInline adds lightweight inline extensions(e.g: ticket ids or
+	// user handles). at each position of the text, the first rule whose
+	// pattern matches there is rendered by its Render function. code spans
+	// are kept as is. it's not used with Cache.
+	CustomInline []CustomInline
+	// AutolinkLength shortens the text of the autolinks(<http://...> and
+	// the bare urls of Gfm) that are longer than the given number of
+	// characters, e.g: for chat messages. they are shown as their host and
+	// path, and cut with an ellipsis(…) if they are still too long. the
+	// href is kept as is. zero means no limit.
+	AutolinkLength int
+	// Emoji maps custom emoji shortcodes to image urls, ":name:" is
+	// rendered as <img class="emoji">. unknown shortcodes are left as is.
+	Emoji map[string]string
+	// DisabledBlocks and DisabledInlines disable the parsing of the given
+	// block and inline node types(e.g: NodeHeading, NodeImage, NodeHTML).
+	// disabled elements are rendered as literal text.
+	DisabledBlocks  map[NodeType]bool
+	DisabledInlines map[NodeType]bool
+	// Classes maps node types to the class attribute of their elements,
+	// e.g: {NodeTable: "table table-striped", NodeBlockQuote: "quote"}.
+	Classes map[NodeType]string
+	// Styles maps node types to the inline style attribute of their
+	// elements, e.g: {NodeCode: "background:#f6f8fa"}, for email clients
+	// that ignore <style> elements.
+	Styles map[NodeType]string
+	// ClassPrefix prefixes all the emitted class names(e.g: "md-" turns
+	// "lang-js" into "md-lang-js").
+	ClassPrefix string
+	// Attributer returns extra attributes for the element of the given
+	// node(e.g: ids, data-*). the attributes are merged into its opening tag.
+	Attributer func(n Node) map[string]string
+	// Email renders the elements that email clients don't support with
+	// plain markup: code blocks are wrapped with a single-cell table, details
+	// blocks and tabs are rendered as blocks with a bold title, checkboxes as
+	// "☐" and "☑", and ruby annotations in parentheses. see EmailOptions.
+	Email bool
+	// Indent pretty-prints the output: each block element starts on its own
+	// line, and the content of blocks that contain other blocks(e.g: lists,
+	// tables and blockquotes) is indented with the given string, e.g: "  ".
+	// the content of <pre> elements is kept as is.
+	Indent string
+	// LiteralQuotes keeps the quotes(" and ') of text and code literal,
+	// instead of escaping them as &quot; and &#39;. quotes in attributes
+	// are always escaped.
+	LiteralQuotes bool
+	// Entities sets the output form of the non-ASCII characters in text,
+	// attributes and smartypants output, e.g: EntitiesASCII to emit them as
+	// numeric entities. the content of <script> and <style> is kept as is.
+	Entities EntityStyle
+	// Slugs sets the algorithm that generates the heading ids, e.g:
+	// SlugUnicode to keep the non-ASCII letters, like GitHub does.
+	Slugs SlugStyle
+	// VoidStyle sets the style of the emitted void elements, e.g: VoidSpaceSlash
+	// for XHTML pipelines. void elements in raw html are kept as is.
+	VoidStyle VoidStyle
+	// Compact removes the new-lines and the other whitespaces between block
+	// elements, that aren't rendered, for minimal output. the content of
+	// <pre> elements is kept as is. it's ignored if Indent is set.
+	Compact bool
+	// AssetFn is called while rendering with the path of each local image
+	// (url-decoded and cleaned, without its query and fragment), and returns
+	// the url that is emitted instead, e.g: to copy or fingerprint the asset.
+	// it's not used with Cache.
+	AssetFn func(path string) string
+	// Alerts renders GitHub alerts, blockquotes that start with "[!NOTE]",
+	// "[!TIP]", "[!IMPORTANT]", "[!WARNING]" or "[!CAUTION]", as callouts.
+	Alerts bool
+	// MarkdownInHTML parses the content of html blocks whose opening tag
+	// has a markdown="1" attribute as markdown(PHP Markdown Extra), e.g:
+	// for layout divs. the attribute is removed from the output.
+	MarkdownInHTML bool
+	// TabWidth expands the tabs of code blocks to spaces, up to the next
+	// multiple of TabWidth columns. by default, the tabs of code blocks are
+	// kept as is(e.g: for Makefile snippets), and only their indentation is
+	// removed.
+	TabWidth int
+	// ListIndent sets the number of spaces that are removed from the
+	// continuation lines of list items, e.g: 4 for documents that indent
+	// the content of all items by 4 spaces. zero means the width of the
+	// item marker(2 for "- " and 3 for "1. ").
+	ListIndent int
+	// NoListCode parses the lines that are indented by 4 or more spaces
+	// (after the ListIndent) inside list items as text, instead of as
+	// indented code blocks. fenced code blocks are still parsed.
+	NoListCode bool
+	// LiteralErrors renders malformed constructs as literal text, wrapped
+	// with LiteralNode, instead of the silent fallbacks: the opening line of
+	// an unclosed code fence(that otherwise runs to the end of its container),
+	// references to undefined links, and the extra cells of table rows(that
+	// are otherwise dropped). the problems are recorded in the document
+	// Diagnostics.
+	LiteralErrors bool
+	// Details enables collapsible details blocks, that are fenced with
+	// ":::details Summary" and ":::" lines.
+	Details bool
+	// Divs enables pandoc-style fenced divs, that are fenced with
+	// "::: {#id .class key=value}"(or "::: class") and ":::" lines.
+	// the id, the classes and the data-*, title, lang and dir attributes
+	// are set on the emitted <div>.
+	Divs bool
+	// Tabs enables groups of tabs, each tab starts with a `=== "Title"`
+	// line, followed by its content indented by 4 spaces.
+	Tabs bool
+	// FrontMatter strips the front matter("---" delimited block at the
+	// start of the input) and stores it in DocumentNode.FrontMatter.
+	FrontMatter bool
+	// Cache is consulted by Render before rendering the input, and stores
+	// its output. it's not used with custom render functions or Attributer,
+	// and Mappings are not available for cached outputs.
+	Cache Cache
+	// TagFilter escapes the opening "<" of disallowed raw html tags,
+	// such as <script>, <style> and <iframe>(GFM tagfilter).
+	TagFilter bool
+	// RawDelims maps the opening delimiters of raw regions to their closing
+	// delimiters, e.g: {"{% raw %}": "{% endraw %}", "```raw": "```"}. raw
+	// regions start at the beginning of a line, and their content is emitted
+	// verbatim, without markdown processing or escaping.
+	RawDelims map[string]string
+	// Shortcodes enables Hugo-style shortcodes, {{< name args >}} and
+	// {{% name args %}}...{{% /name %}}. they are rendered by the handlers
+	// that are registered with AddShortcode, and unknown shortcodes are
+	// rendered as text.
+	Shortcodes bool
+	// TemplateTags keeps Liquid/Jinja tags, {% ... %} and {{ ... }}, as is,
+	// without markdown processing or escaping inside them, for documents
+	// that are post-processed by a template engine. a line that holds only
+	// a {% ... %} tag isn't wrapped with a paragraph. the TagFilter option
+	// still applies to them.
+	TemplateTags bool
+	// Links holds shared link reference definitions, by their lower-cased
+	// names, that are used by references that the document doesn't define,
+	// e.g: the Links of a central document that is parsed once. definitions
+	// in the document take precedence, and conflicts are reported in the
+	// document Diagnostics.
+	Links map[string]*DefLinkNode
+	// MultilineTables lets table cells contain block content(e.g: lists).
+	// a row that ends with a backslash after its last pipe is continued
+	// by the next row, cell by cell:
+	//
+	//	| Name | Items |
+	//	|------|-------|
+	//	| foo  | - a   | \
+	//	|      | - b   |
+	MultilineTables bool
+	// MaxDepth limits the nesting depth of container blocks(blockquotes,
+	// list items, details, tabs and block shortcodes). the content of deeper
+	// containers is rendered as literal text, so hostile input(e.g: thousands
+	// of ">") can't blow up the parsing time. zero means the default limit
+	// of 100, and a negative value means no limit.
+	MaxDepth int
+	// Metrics is called after each render with its timings and the number
+	// of nodes, e.g: to export them or to find pathological documents.
+	// it's not called for cached outputs.
+	Metrics func(Metrics)
+	// Trace logs each emitted token and each parser decision, with their
+	// positions, used to debug mis-parses. it's not used with Cache.
+	Trace io.Writer
+
+	complete bool // created by DefaultOptions
 }
 
+// Package default options, can be changed using SetDefaultOptions.
+var defaults = struct {
+	sync.RWMutex
+	opts Options
+}{opts: Options{Gfm: true, Tables: true}}
+
 // DefaultOptions return an options struct with default configuration
-// it's means that only Gfm, and Tables set to true.
+// it's means that only Gfm, and Tables set to true, unless they were
+// changed using SetDefaultOptions.
 func DefaultOptions() *Options {
+	defaults.RLock()
+	defer defaults.RUnlock()
+	opts := defaults.opts.clone()
+	opts.complete = true
+	return &opts
+}
+
+// CommentOptions returns an options preset for user comments. autolinks,
+// emphasis, code and blockquotes are enabled, while images, headings, raw
+// html and horizontal rules are rendered as literal text. line breaks are
+// rendered as <br>, unsafe urls are removed, and blocks can be nested up to
+// 16 levels.
+func CommentOptions() *Options {
 	return &Options{
-		Gfm:    true,
-		Tables: true,
+		Gfm:             true,
+		HardWrap:        true,
+		Sanitize:        true,
+		TagFilter:       true,
+		DisabledBlocks:  map[NodeType]bool{NodeHeading: true, NodeHr: true, NodeHTML: true},
+		DisabledInlines: map[NodeType]bool{NodeImage: true, NodeRefImage: true, NodeHTML: true},
+		MaxDepth:        16,
+		complete:        true,
 	}
 }
 
-// New return a new Mark
-func New(input string, opts *Options) *Mark {
-	// Preprocessing
-	input = strings.Replace(input, "\t", "    ", -1)
-	if opts == nil {
-		opts = DefaultOptions()
+// EmailOptions returns an options preset for html emails, with the given
+// inline styles. Gfm, Tables and Email are enabled, unsafe urls are removed
+// and <script>, <style> and the other disallowed raw html tags are escaped.
+func EmailOptions(styles map[NodeType]string) *Options {
+	return &Options{
+		Gfm:       true,
+		Tables:    true,
+		Email:     true,
+		Styles:    styles,
+		Sanitize:  true,
+		TagFilter: true,
+		complete:  true,
+	}
+}
+
+// FeedOptions returns an options preset for the content of RSS and Atom
+// feeds, that's embedded in XML. Gfm and Tables are enabled, relative urls
+// are resolved against the given base url, void elements and boolean
+// attributes are written as XHTML, named entities are converted to numeric
+// ones and unsafe urls are removed. raw html is rendered as literal text,
+// since it may not be well-formed XML(e.g: <br>), and its urls can't be
+// resolved.
+func FeedOptions(baseURL string) *Options {
+	return &Options{
+		Gfm:             true,
+		Tables:          true,
+		BaseURL:         baseURL,
+		VoidStyle:       VoidXHTML,
+		Entities:        EntitiesXML,
+		Sanitize:        true,
+		TagFilter:       true,
+		DisabledBlocks:  map[NodeType]bool{NodeHTML: true},
+		DisabledInlines: map[NodeType]bool{NodeHTML: true},
+		complete:        true,
+	}
+}
+
+// SetDefaultOptions sets the options returned by DefaultOptions and used to
+// fill the zero values of the options passed to New. nil restores the built-in
+// defaults. it's safe for concurrent use.
+func SetDefaultOptions(opts *Options) {
+	o := Options{Gfm: true, Tables: true}
+	if opts != nil {
+		o = opts.clone()
 	}
-	return &Mark{
+	defaults.Lock()
+	defaults.opts = o
+	defaults.Unlock()
+}
+
+// defaultMaxDepth is the nesting limit that is used when MaxDepth is zero.
+const defaultMaxDepth = 100
+
+// maxDepth returns the nesting limit of container blocks, or 0 if there's no limit.
+func (o *Options) maxDepth() int {
+	switch {
+	case o.MaxDepth == 0:
+		return defaultMaxDepth
+	case o.MaxDepth < 0:
+		return 0
+	}
+	return o.MaxDepth
+}
+
+// merge returns the options with their zero values replaced by the defaults.
+func (o *Options) merge() *Options {
+	if o == nil {
+		return DefaultOptions()
+	}
+	if o.complete && !o.NoGfm && !o.NoTables {
+		return o
+	}
+	opts := *o
+	if !o.complete {
+		dst, def := reflect.ValueOf(&opts).Elem(), reflect.ValueOf(DefaultOptions()).Elem()
+		for i := 0; i < dst.NumField(); i++ {
+			if f := dst.Field(i); f.CanSet() && f.IsZero() {
+				f.Set(def.Field(i))
+			}
+		}
+	}
+	if opts.NoGfm {
+		opts.Gfm, opts.Tables = false, false
+	}
+	if opts.NoTables {
+		opts.Tables = false
+	}
+	opts.complete = true
+	return &opts
+}
+
+// clone returns a copy of the options, with copies of their maps and
+// slices, so changing the copy doesn't change the options.
+func (o Options) clone() Options {
+	v := reflect.ValueOf(&o).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if !f.CanSet() || (f.Kind() != reflect.Map && f.Kind() != reflect.Slice) || f.IsNil() {
+			continue
+		}
+		if f.Kind() == reflect.Slice {
+			c := reflect.MakeSlice(f.Type(), f.Len(), f.Len())
+			reflect.Copy(c, f)
+			f.Set(c)
+			continue
+		}
+		c := reflect.MakeMapWithSize(f.Type(), f.Len())
+		for it := f.MapRange(); it.Next(); {
+			c.SetMapIndex(it.Key(), it.Value())
+		}
+		f.Set(c)
+	}
+	return o
+}
+
+// Validate returns an error if the options contain conflicting settings.
+func (o *Options) Validate() error {
+	switch {
+	case o.Tables && !o.Gfm:
+		return fmt.Errorf("mark: Tables requires Gfm")
+	case o.Locale != "" && !o.Smartypants:
+		return fmt.Errorf("mark: Locale requires Smartypants")
+	case o.FullWidth && !o.Smartypants:
+		return fmt.Errorf("mark: FullWidth requires Smartypants")
+	case o.VoidStyle < VoidHTML || o.VoidStyle > VoidXHTML:
+		return fmt.Errorf("mark: unknown VoidStyle %d", o.VoidStyle)
+	case o.Entities < EntitiesAsIs || o.Entities > EntitiesXML:
+		return fmt.Errorf("mark: unknown Entities %d", o.Entities)
+	case o.Slugs < SlugASCII || o.Slugs > SlugTransliterate:
+		return fmt.Errorf("mark: unknown Slugs %d", o.Slugs)
+	case o.BaseURL != "" && !isAbsURL(o.BaseURL):
+		return fmt.Errorf("mark: BaseURL %q is not an absolute url", o.BaseURL)
+	case o.TabWidth < 0:
+		return fmt.Errorf("mark: negative TabWidth %d", o.TabWidth)
+	case o.ListIndent < 0:
+		return fmt.Errorf("mark: negative ListIndent %d", o.ListIndent)
+	case o.AutolinkLength < 0:
+		return fmt.Errorf("mark: negative AutolinkLength %d", o.AutolinkLength)
+	}
+	for i, c := range o.CustomInline {
+		if c.Pattern == nil || c.Render == nil {
+			return fmt.Errorf("mark: CustomInline[%d] has no Pattern or Render", i)
+		}
+	}
+	for i, r := range o.Replacements {
+		if r.Old == "" && r.Regexp == nil {
+			return fmt.Errorf("mark: Replacements[%d] has no Old text or Regexp", i)
+		}
+	}
+	if _, ok := quotesFor(o.Locale); o.Locale != "" && !ok {
+		return fmt.Errorf("mark: unknown locale %q", o.Locale)
+	}
+	return nil
+}
+
+// New return a new Mark
+func New(source string, opts *Options) *Mark {
+	opts = opts.merge()
+	input, front := prepare(source, opts)
+	m := &Mark{
 		Input: input,
 		parse: newParse(input, opts),
 	}
+	m.frontMatter, m.source = front, source
+	return m
 }
 
-// parse and render input
-func (m *Mark) Render() string {
+// Reset replaces the input of the Mark, and keeps its options, render
+// functions and shortcodes, so a configured Mark can be reused for many
+// inputs. the nodes, the output mappings and the metrics of the previous
+// input are discarded. it must not be called concurrently with Render.
+func (m *Mark) Reset(source string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	input, front := prepare(source, m.options)
+	m.parse.reset(input)
+	m.Input, m.frontMatter, m.source = input, front, source
+	m.parsed, m.fromDoc, m.mappings, m.metrics = false, false, nil, Metrics{}
+}
+
+// prepare returns the input after preprocessing, and its front matter.
+func prepare(input string, opts *Options) (string, string) {
+	input = indentTabs(input)
+	var front string
+	if opts.FrontMatter {
+		front, input = splitFrontMatter(input)
+	}
+	return input, front
+}
+
+// indentTabs replaces the tabs of the markdown syntax with spaces, and keeps
+// the tabs of code blocks. the lines of fenced code are kept as is, and the
+// leading tabs of the other lines are expanded up to the indentation of code
+// blocks(4 columns), after which the tabs of the line are kept.
+func indentTabs(input string) string {
+	if !strings.Contains(input, "\t") {
+		return input
+	}
+	var b strings.Builder
+	var fence string // the fence of the open code block
+	for len(input) > 0 {
+		line := firstLine(input)
+		input = input[len(line):]
+		quote := reQuoteMarks.FindString(line)
+		rest := line[len(quote):]
+		if fence != "" {
+			if closesFence(rest, fence) {
+				fence = ""
+			}
+			b.WriteString(line)
+			continue
+		}
+		b.WriteString(quote)
+		col := 0
+		for ; col < 4 && rest != "" && (rest[0] == ' ' || rest[0] == '\t'); rest = rest[1:] {
+			if rest[0] == '\t' {
+				b.WriteString(strings.Repeat(" ", 4-col%4))
+				col += 4 - col%4
+			} else {
+				b.WriteByte(' ')
+				col++
+			}
+		}
+		if col < 4 {
+			rest = strings.Replace(rest, "\t", "    ", -1)
+			if m := reGfmCode.FindStringSubmatch(strings.Repeat(" ", col) + rest); m != nil {
+				fence = m[2]
+			}
+		}
+		b.WriteString(rest)
+	}
+	return b.String()
+}
+
+// tabCol maps a column of the given line, after its tabs were expanded
+// by indentTabs, to its column in the line. the line isn't a fenced code
+// line, that is kept as is.
+func tabCol(line string, col int) int {
+	quote := reQuoteMarks.FindString(line)
+	if col <= len(quote) {
+		return col
+	}
+	w, indent, leading := len(quote), 0, true
+	for i := len(quote); i < len(line); i++ {
+		width, c := 1, line[i]
+		if leading = leading && indent < 4 && (c == ' ' || c == '\t'); leading {
+			if c == '\t' {
+				width = 4 - indent%4
+			}
+			indent += width
+		} else if c == '\t' && indent < 4 {
+			width = 4
+		}
+		if w += width; w >= col {
+			return i + 1
+		}
+	}
+	return len(line) + 1
+}
+
+// closesFence tests if the given line closes a fenced code block
+// that was opened with the given fence. the closing fence is at least
+// as long as the opening one, and it may be followed only by spaces.
+func closesFence(line, fence string) bool {
+	l := strings.TrimLeft(line, " ")
+	rest := strings.TrimLeft(l, fence[:1])
+	return len(line)-len(l) <= 3 && len(l)-len(rest) >= len(fence) && strings.TrimRight(rest, " \n") == ""
+}
+
+// splitFrontMatter returns the front matter of the input, and the input
+// without it. the front matter is replaced with new-lines, to keep the
+// line numbers of the source positions.
+func splitFrontMatter(input string) (front, rest string) {
+	m := reFrontMatter.FindStringSubmatch(input)
+	if m == nil {
+		return "", input
+	}
+	return m[1], strings.Repeat("\n", strings.Count(m[0], "\n")) + input[len(m[0]):]
+}
+
+// DocumentNode is the root of a document. it holds the block nodes and the
+// document-scoped data. it's returned by Parse, and can be built
+// programmatically using NewDocument.
+type DocumentNode struct {
+	NodeType
+	Pos
+	Nodes []Node
+	// FrontMatter holds the raw front matter of the input, without
+	// its "---" delimiters. used with the FrontMatter option.
+	FrontMatter string
+	// Links holds the link reference definitions by their lower-cased names.
+	Links map[string]*DefLinkNode
+	// Diagnostics holds the problems that were found while parsing the
+	// input(e.g: references to undefined links).
+	Diagnostics []Diagnostic
+
+	spans  map[Node]Span
+	source string // the input of the document, before prepare
+}
+
+// Document is the old name of DocumentNode, kept for compatibility.
+type Document = DocumentNode
+
+// Diagnostic describes a problem in the input. it doesn't stop the parsing.
+type Diagnostic struct {
+	Pos     Pos
+	Message string
+}
+
+// Render returns the html representation of the document, using the default options.
+func (d *DocumentNode) Render() string {
+	return d.html(newRenderer(nil, nil))
+}
+
+func (d *DocumentNode) html(r *renderer) string {
+	var blocks []string
+	for _, n := range d.Nodes {
+		if s := r.render(n); s != "" {
+			blocks = append(blocks, s)
+		}
+	}
+	return strings.Join(blocks, "\n")
+}
+
+// NewDocument return a new Document that holds the given nodes
+func NewDocument(nodes ...Node) *DocumentNode {
+	return &DocumentNode{NodeType: NodeDocument, Nodes: nodes}
+}
+
+// FromDocument return a new Mark that renders the given Document
+// the same way it renders a parsed input(options, render functions, etc.)
+func FromDocument(doc *DocumentNode, opts *Options) *Mark {
+	m := New("", opts)
+	m.Nodes = append(m.Nodes, doc.Nodes...)
+	m.spans = doc.spans
+	m.parsed, m.fromDoc = true, true
+	return m
+}
+
+// Parse parses the input and return its nodes as a Document,
+// that can be modified and rendered using FromDocument.
+func Parse(input string, opts *Options) *DocumentNode {
+	m := New(input, opts)
 	m.parse.parse()
-	m.render()
-	return m.output
+	return m.document()
+}
+
+// ParseContext is like Parse, but it checks for cancellation between
+// blocks, and returns the context error if it's done.
+func ParseContext(ctx context.Context, input string, opts *Options) (*DocumentNode, error) {
+	m := New(input, opts)
+	if err := m.parse.parseContext(ctx); err != nil {
+		return nil, err
+	}
+	return m.document(), nil
+}
+
+// parse and render input. the input is parsed only once, and it's safe
+// to render the same Mark from multiple goroutines.
+func (m *Mark) Render() string {
+	s, _ := m.RenderContext(context.Background())
+	return s
+}
+
+// Document parses the input, like Render, and returns the parsed document.
+// the input is parsed only once, so the same tree can be rendered to the
+// other formats(e.g: Markdown, JSON) without parsing it again.
+func (m *Mark) Document() *DocumentNode {
+	m.parseOnce(context.Background())
+	return m.document()
+}
+
+// RenderText parses the input, like Render, and returns its plain text.
+func (m *Mark) RenderText() string {
+	return m.Document().Text()
+}
+
+// Excerpt parses the input, like Render, and renders only the content above
+// the excerpt separator(a "<!--more-->" line), e.g: for the post teasers of
+// index pages. ok reports whether the input has a separator. without one,
+// the whole input is rendered.
+func (m *Mark) Excerpt() (s string, ok bool) {
+	nodes, ok := m.Document().Excerpt()
+	var b strings.Builder
+	m.renderTo(context.Background(), &b, nodes)
+	return b.String(), ok
+}
+
+// parseOnce parses the input on the first call. if the parsing was stopped
+// by the context, the next call continues from the same point.
+func (m *Mark) parseOnce(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.parsed {
+		return nil
+	}
+	start := time.Now()
+	err := m.parseContext(ctx)
+	m.metrics.Parse += time.Since(start)
+	if err != nil {
+		return err
+	}
+	m.parsed = true
+	m.metrics.Parse -= m.lexTime
+	m.metrics.Lex = m.lexTime
+	for _, n := range m.Nodes {
+		Walk(n, func(Node) bool {
+			m.metrics.Nodes++
+			return true
+		})
+	}
+	return nil
+}
+
+// writeTo parses the input if needed, and writes the rendered output to w.
+func (m *Mark) writeTo(ctx context.Context, w io.Writer) (int64, error) {
+	if err := m.parseOnce(ctx); err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	n, mappings, err := m.renderTo(ctx, w, m.Nodes)
+	m.mu.Lock()
+	m.mappings = mappings
+	metrics := m.metrics
+	m.mu.Unlock()
+	if fn := m.options.Metrics; fn != nil && err == nil {
+		metrics.Render = time.Since(start)
+		fn(metrics)
+	}
+	return n, err
+}
+
+// Mappings returns the source to output mapping of the top-level blocks,
+// in the order they were rendered. it's available after calling Render.
+// used to synchronize the source and the output(e.g: editor preview).
+func (m *Mark) Mappings() []Mapping {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mappings
+}
+
+// RenderContext is like Render, but it checks for cancellation between
+// blocks, and returns the context error if it's done.
+func (m *Mark) RenderContext(ctx context.Context) (string, error) {
+	key := m.cacheKey()
+	if key != "" {
+		if s, ok := m.options.Cache.Get(key); ok {
+			return s, nil
+		}
+	}
+	var b strings.Builder
+	if _, err := m.writeTo(ctx, &b); err != nil {
+		return "", err
+	}
+	if key != "" {
+		m.options.Cache.Set(key, b.String())
+	}
+	return b.String(), nil
+}
+
+// cacheKey returns the key of the output in the options cache, or an empty
+// string if it can't be cached(e.g: there are custom render functions).
+func (m *Mark) cacheKey() string {
+	opts := *m.options
+	opts.Metrics = nil
+	if opts.Cache == nil || opts.Trace != nil || opts.Attributer != nil || opts.AssetFn != nil || len(opts.CustomInline) > 0 || m.fromDoc || len(m.renderFn) > 0 || m.headingFn != nil || m.tabsFn != nil || len(m.shortcodes) > 0 {
+		return ""
+	}
+	// the links and the replacements hold pointers, that are printed
+	// as addresses. their content is hashed instead.
+	links, replacements := opts.Links, opts.Replacements
+	opts.Cache, opts.Links, opts.Replacements = nil, nil, nil
+	h := sha256.New()
+	fmt.Fprintf(h, "%+v\x00", opts)
+	names := make([]string, 0, len(links))
+	for name := range links {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if l := links[name]; l != nil {
+			fmt.Fprintf(h, "%q %q %q %q\x00", name, l.Name, l.Href, l.Title)
+		}
+	}
+	for _, r := range replacements {
+		var re string
+		if r.Regexp != nil {
+			re = r.Regexp.String()
+		}
+		fmt.Fprintf(h, "%q %q %q\x00", r.Old, re, r.New)
+	}
+	// the source positions are columns of the source, before its tabs
+	// were expanded, so the source is hashed instead of the input.
+	fmt.Fprintf(h, "%s", m.source)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// RenderE is like Render, but it returns an error instead of panicking
+// if something went wrong while parsing or rendering the input, or if
+// the options are not valid.
+func (m *Mark) RenderE() (s string, err error) {
+	if err := m.options.Validate(); err != nil {
+		return "", err
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("mark: %v", e)
+		}
+	}()
+	return m.Render(), nil
+}
+
+// WriteTo parses the input and writes the rendered output to w,
+// block by block, without building the whole output in memory.
+// it implements the io.WriterTo interface.
+func (m *Mark) WriteTo(w io.Writer) (int64, error) {
+	return m.writeTo(context.Background(), w)
+}
+
+// RenderNode renders the given node and its children using the
+// options and the render functions of the Mark.
+func (m *Mark) RenderNode(n Node) string {
+	return m.renderer().render(n)
 }
 
 // AddRenderFn let you pass NodeType, and RenderFn function
-// and override the default Node rendering
+// and override the default Node rendering. the function is used for
+// the nested nodes as well(e.g: the links of a paragraph), and not only
+// for the top-level ones, as it used to be.
 func (m *Mark) AddRenderFn(typ NodeType, fn RenderFn) {
+	m.renderFn[typ] = func(_ context.Context, n Node) string {
+		return fn(n)
+	}
+}
+
+// AddContextRenderFn is like AddRenderFn, but the render function gets the
+// context that was passed to RenderContext(or context.Background).
+func (m *Mark) AddContextRenderFn(typ NodeType, fn ContextRenderFn) {
 	m.renderFn[typ] = fn
 }
 
+// SetHeadingFn sets a function that renders the headings instead of the
+// default rendering. it's ignored if there's a RenderFn for NodeHeading.
+func (m *Mark) SetHeadingFn(fn HeadingFn) {
+	m.headingFn = fn
+}
+
+// SetTabsFn sets a function that renders the groups of tabs instead
+// of the default markup.
+func (m *Mark) SetTabsFn(fn TabsFn) {
+	m.tabsFn = fn
+}
+
+// AddShortcode registers a handler for the shortcodes with the given name.
+// if markdown is true, the handler gets the rendered html of the inner
+// content, otherwise it gets the inner content as is. it requires the
+// Shortcodes option.
+func (m *Mark) AddShortcode(name string, markdown bool, fn ShortcodeFn) {
+	if m.shortcodes == nil {
+		m.shortcodes = make(map[string]shortcode)
+	}
+	m.shortcodes[name] = shortcode{fn, markdown}
+}
+
 // Staic render function
 func Render(input string) string {
 	m := New(input, nil)
 	return m.Render()
 }
+
+// Static render function that returns an error instead of panicking.
+func RenderE(input string) (string, error) {
+	return New(input, nil).RenderE()
+}
+
+// RenderBytes is like Render, but it gets and returns a byte slice.
+func RenderBytes(src []byte) []byte {
+	return AppendRender(nil, src)
+}
+
+// AppendRender renders src and appends the output to dst, and returns
+// the extended buffer. it lets the caller reuse the same buffer. the
+// output is written straight into dst, and it grows only if its capacity
+// is exceeded.
+func AppendRender(dst, src []byte) []byte {
+	b := bytes.NewBuffer(dst)
+	New(string(src), nil).WriteTo(b)
+	return b.Bytes()
+}
+
+// Buffers used by RenderAll workers.
+var bufPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// RenderAll renders the given inputs concurrently with the same options,
+// and returns the outputs in the order of the inputs. workers is the number
+// of inputs rendered in parallel, runtime.NumCPU() is used if it's <= 0(or
+// 1 on wasm). with a single worker, the inputs are rendered sequentially,
+// without starting goroutines.
+func RenderAll(inputs []string, opts *Options, workers int) []string {
+	if workers <= 0 {
+		workers = defaultWorkers()
+	}
+	opts = opts.merge()
+	outputs := make([]string, len(inputs))
+	if workers == 1 {
+		for i, input := range inputs {
+			outputs[i] = renderBuffered(input, opts)
+		}
+		return outputs
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				outputs[i] = renderBuffered(inputs[i], opts)
+			}
+		}()
+	}
+	for i := range inputs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return outputs
+}
+
+// renderBuffered renders the input into a pooled buffer.
+func renderBuffered(input string, opts *Options) string {
+	buf := bufPool.Get().(*bytes.Buffer)
+	defer bufPool.Put(buf)
+	buf.Reset()
+	New(input, opts).WriteTo(buf)
+	return buf.String()
+}
@@ -1,10 +1,17 @@
 package mark
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
 	"io/ioutil"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestRender(t *testing.T) {
@@ -16,11 +23,14 @@ func TestRender(t *testing.T) {
 		"__bar__ foo":          "<p><strong>bar</strong> foo</p>",
 		"**bar** foo __bar__":  "<p><strong>bar</strong> foo <strong>bar</strong></p>",
 		"**bar**__baz__":       "<p><strong>bar</strong><strong>baz</strong></p>",
-		"**bar**foo__bar__":    "<p><strong>bar</strong>foo<strong>bar</strong></p>",
-		"_bar_baz":             "<p><em>bar</em>baz</p>",
+		"**bar**foo__bar__":    "<p><strong>bar</strong>foo__bar__</p>",
+		"_bar_baz":             "<p>_bar_baz</p>",
 		"_foo_~~bar~~ baz":     "<p><em>foo</em><del>bar</del> baz</p>",
 		"~~baz~~ _baz_":        "<p><del>baz</del> <em>baz</em></p>",
 		"`bool` and thats it.": "<p><code>bool</code> and thats it.</p>",
+		"`` `x` ``":            "<p><code>`x`</code></p>",
+		"`` a` `` b":           "<p><code>a`</code> b</p>",
+		"x ```a``b``` c":       "<p>x <code>a``b</code> c</p>",
 		// Html
 		"<!--hello-->": "<!--hello-->",
 		// Emphasis mixim
@@ -40,16 +50,20 @@ func TestRender(t *testing.T) {
 		"# 1\np\n## 2\n### 3\n4\n===": "<h1 id=\"1\">1</h1>\n<p>p</p>\n<h2 id=\"2\">2</h2>\n<h3 id=\"3\">3</h3>\n<h1 id=\"4\">4</h1>",
 		"Hello\n===":                  "<h1 id=\"hello\">Hello</h1>",
 		// Links
-		"[text](link \"title\")": "<p><a href=\"link\" title=\"title\">text</a></p>",
-		"[text](link)":           "<p><a href=\"link\">text</a></p>",
-		"[](link)":               "<p><a href=\"link\"></a></p>",
-		"Link: [example](#)":     "<p>Link: <a href=\"#\">example</a></p>",
-		"Link: [not really":      "<p>Link: [not really</p>",
-		"http://localhost:3000":  "<p><a href=\"http://localhost:3000\">http://localhost:3000</a></p>",
-		"Link: http://yeah.com":  "<p>Link: <a href=\"http://yeah.com\">http://yeah.com</a></p>",
-		"<http://foo.com>":       "<p><a href=\"http://foo.com\">http://foo.com</a></p>",
-		"Link: <http://l.co>":    "<p>Link: <a href=\"http://l.co\">http://l.co</a></p>",
-		"Link: <not really":      "<p>Link: &lt;not really</p>",
+		"[text](link \"title\")":  "<p><a href=\"link\" title=\"title\">text</a></p>",
+		"[text](link)":            "<p><a href=\"link\">text</a></p>",
+		"[](link)":                "<p><a href=\"link\"></a></p>",
+		"Link: [example](#)":      "<p>Link: <a href=\"#\">example</a></p>",
+		"Link: [not really":       "<p>Link: [not really</p>",
+		"http://localhost:3000":   "<p><a href=\"http://localhost:3000\">http://localhost:3000</a></p>",
+		"Link: http://yeah.com":   "<p>Link: <a href=\"http://yeah.com\">http://yeah.com</a></p>",
+		"see http://a.com.":       "<p>see <a href=\"http://a.com\">http://a.com</a>.</p>",
+		"(http://a.com/foo), bar": "<p>(<a href=\"http://a.com/foo\">http://a.com/foo</a>), bar</p>",
+		"http://a.com/Foo_(bar)?": "<p><a href=\"http://a.com/Foo_(bar)\">http://a.com/Foo_(bar)</a>?</p>",
+		"http://a.com/?q=1&amp;":  "<p><a href=\"http://a.com/?q=1\">http://a.com/?q=1</a>&amp;</p>",
+		"<http://foo.com>":        "<p><a href=\"http://foo.com\">http://foo.com</a></p>",
+		"Link: <http://l.co>":     "<p>Link: <a href=\"http://l.co\">http://l.co</a></p>",
+		"Link: <not really":       "<p>Link: &lt;not really</p>",
 		// CodeBlock
 		"\tfoo\n\tbar": "<pre><code>foo\nbar</code></pre>",
 		"\tfoo\nbar":   "<pre><code>foo\n</code></pre>\n<p>bar</p>",
@@ -71,18 +85,23 @@ func TestRender(t *testing.T) {
 		// // Ordered Lists
 		"1. one\n2. two\n3. three": "<ol>\n<li>one</li>\n<li>two</li>\n<li>three</li>\n</ol>",
 		"1. one\n 1. one of one":   "<ol>\n<li>one<ol>\n<li>one of one</li>\n</ol></li>\n</ol>",
-		"2. two\n 3. three":        "<ol>\n<li>two<ol>\n<li>three</li>\n</ol></li>\n</ol>",
+		"2. two\n 3. three":        "<ol start=\"2\">\n<li>two<ol start=\"3\">\n<li>three</li>\n</ol></li>\n</ol>",
 		// Task list
 		"- [ ] foo\n- [ ] bar": "<ul>\n<li><input type=\"checkbox\">foo</li>\n<li><input type=\"checkbox\">bar</li>\n</ul>",
 		"- [x] foo\n- [x] bar": "<ul>\n<li><input type=\"checkbox\" checked>foo</li>\n<li><input type=\"checkbox\" checked>bar</li>\n</ul>",
 		"- [ ] foo\n- [x] bar": "<ul>\n<li><input type=\"checkbox\">foo</li>\n<li><input type=\"checkbox\" checked>bar</li>\n</ul>",
+		// Tables
+		"a|b\n-|-\n`\\|`|c\\|d":        "<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td><code>|</code></td>\n<td>c|d</td>\n</tr>\n</tbody>\n</table>",
+		"a | b\n--|--\nc |\nd | e | f": "<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td>c</td>\n<td></td>\n</tr>\n<tr>\n<td>d</td>\n<td>e</td>\n</tr>\n</tbody>\n</table>",
+		"| a | b |\n| - |\n| c | d |":  "<p>| a | b |\n| - |\n| c | d |</p>",
 		// Special characters escaping
-		"< hello":   "<p>&lt; hello</p>",
-		"hello >":   "<p>hello &gt;</p>",
-		"foo & bar": "<p>foo &amp; bar</p>",
-		"'foo'":     "<p>&#39;foo&#39;</p>",
-		"\"foo\"":   "<p>&quot;foo&quot;</p>",
-		"&copy;":    "<p>&copy;</p>",
+		"< hello":       "<p>&lt; hello</p>",
+		"hello >":       "<p>hello &gt;</p>",
+		"foo & bar":     "<p>foo &amp; bar</p>",
+		"'foo'":         "<p>&#39;foo&#39;</p>",
+		"\"foo\"":       "<p>&quot;foo&quot;</p>",
+		"&copy;":        "<p>&copy;</p>",
+		"a & b &amp; c": "<p>a &amp; b &amp; c</p>",
 		// Backslash escaping
 		"\\**foo\\**":       "<p>*<em>foo*</em></p>",
 		"\\*foo\\*":         "<p>*foo*</p>",
@@ -137,6 +156,338 @@ func TestData(t *testing.T) {
 	}
 }
 
+func TestSmartypantsLocale(t *testing.T) {
+	cases := []struct {
+		locale, input, expected string
+	}{
+		{"", `"it's 'ok'"`, "<p>\u201cit\u2019s \u2018ok\u2019\u201d</p>"},
+		{"fr", `"it's 'ok'"`, "<p>\u00abit\u2019s \u2039ok\u203a\u00bb</p>"},
+		{"de-AT", `"it's 'ok'"`, "<p>\u201eit\u2019s \u201aok\u2018\u201c</p>"},
+		{"ja", `"hello"`, "<p>\u300chello\u300d</p>"},
+		{"xx", `"hello"`, "<p>\u201chello\u201d</p>"},
+	}
+	for _, c := range cases {
+		opts := DefaultOptions()
+		opts.Smartypants = true
+		opts.Locale = c.locale
+		if actual := New(c.input, opts).Render(); actual != c.expected {
+			t.Errorf("%s(%s): got\n\t%+v\nexpected\n\t%+v", c.input, c.locale, actual, c.expected)
+		}
+	}
+}
+
+func TestSmartypantsFullWidth(t *testing.T) {
+	cases := []struct {
+		fullWidth       bool
+		input, expected string
+	}{
+		{false, `彼は"hello"と言った`, "<p>彼は\u300chello\u300dと言った</p>"},
+		{false, `"こんにちは"と"さようなら"`, "<p>\u300cこんにちは\u300dと\u300cさようなら\u300d</p>"},
+		{false, `は'ok'です`, "<p>は\u300eok\u300fです</p>"},
+		{false, `日本--語`, "<p>日本\u2013語</p>"},
+		{true, `日本--語 and a--b`, "<p>日本\u2015\u2015語 and a\u2013b</p>"},
+		{true, "\uff02こんにちは\uff02", "<p>\u300cこんにちは\u300d</p>"},
+	}
+	for _, c := range cases {
+		opts := DefaultOptions()
+		opts.Smartypants, opts.Locale, opts.FullWidth = true, "ja", c.fullWidth
+		if actual := New(c.input, opts).Render(); actual != c.expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", c.input, actual, c.expected)
+		}
+	}
+}
+
+func TestSymbols(t *testing.T) {
+	cases := []struct {
+		symbols         Symbols
+		input, expected string
+	}{
+		{SymbolsAll, "a -> b <- c <-> d (c) (C) (tm) (R) +-1", "<p>a \u2192 b \u2190 c \u2194 d \u00a9 \u00a9 \u2122 \u00ae \u00b11</p>"},
+		{SymbolArrows, "a -> b (c) +-1", "<p>a \u2192 b (c) +-1</p>"},
+		{SymbolCopyright | SymbolPlusMinus, "a -> b (c) +-1", "<p>a -&gt; b \u00a9 \u00b11</p>"},
+		{SymbolLeftArrow, "a <-> b <- c", "<p>a &lt;-&gt; b \u2190 c</p>"},
+		{SymbolsAll, "`a -> (c)` *b -> (c)*", "<p><code>a -&gt; (c)</code> <em>b \u2192 \u00a9</em></p>"},
+		{SymbolsAll, "a <!-- x -> y --> <b title=\"(c)\">->(c)</b>", "<p>a <!-- x -> y --> <b title=\"(c)\">\u2192\u00a9</b></p>"},
+		{SymbolsAll, "    a -> b\n\n```\n(c)\n```", "<pre><code>a -&gt; b\n\n</code></pre>\n<pre><code>\n(c)\n</code></pre>"},
+		{0, "a -> b (c)", "<p>a -&gt; b (c)</p>"},
+	}
+	for _, c := range cases {
+		if actual := New(c.input, &Options{Symbols: c.symbols}).Render(); actual != c.expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", c.input, actual, c.expected)
+		}
+	}
+}
+
+func TestReplacements(t *testing.T) {
+	opts := &Options{
+		Smartypants: true,
+		Replacements: []Replacement{
+			{Old: "e-mail", New: "email"},
+			{Regexp: regexp.MustCompile(`\bJIRA-(\d+)`), New: "ticket $1"},
+			{Old: "ticket", New: "issue"},
+			{Old: "...", New: "etc"},
+		},
+	}
+	cases := map[string]string{
+		"send an e-mail about JIRA-12...":                "<p>send an email about issue 12etc</p>",
+		"`e-mail` *e-mail* [e-mail](http://e-mail.com)":  "<p><code>e-mail</code> <em>email</em> <a href=\"http://e-mail.com\">email</a></p>",
+		"<span title=e-mail>e-mail</span>\n\n    e-mail": "<p><span title=e-mail>email</span></p>\n<pre><code>e-mail</code></pre>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, opts).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+	if err := (&Options{Replacements: []Replacement{{New: "x"}}}).Validate(); err == nil {
+		t.Error("Validate: expected an error for a rule without Old and Regexp")
+	}
+}
+
+func TestCustomInline(t *testing.T) {
+	opts := &Options{
+		Gfm: true,
+		CustomInline: []CustomInline{
+			{regexp.MustCompile(`\b[A-Z]+-(\d+)\b`), func(m []string) string {
+				return fmt.Sprintf("<a href=\"/issues/%s\">%s</a>", m[1], m[0])
+			}},
+			{regexp.MustCompile(`@(\w+)`), func(m []string) string {
+				return "<span class=\"user\">" + m[1] + "</span>"
+			}},
+		},
+	}
+	cases := map[string]string{
+		"see BUG-12 and BUG-3":              "<p>see <a href=\"/issues/12\">BUG-12</a> and <a href=\"/issues/3\">BUG-3</a></p>",
+		"cc @foo, *@bar* [link @baz](/url)": "<p>cc <span class=\"user\">foo</span>, <em><span class=\"user\">bar</span></em> <a href=\"/url\">link <span class=\"user\">baz</span></a></p>",
+		"`@foo BUG-1` @foo":                 "<p><code>@foo BUG-1</code> <span class=\"user\">foo</span></p>",
+		"no match here":                     "<p>no match here</p>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, opts).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+	doc := Parse("ping @foo", opts)
+	if actual := doc.Markdown(); actual != "ping @foo" {
+		t.Errorf("Markdown: got\n\t%+v\nexpected\n\t%+v", actual, "ping @foo")
+	}
+	if n := doc.Select(NodeCustomInline).First(); n == nil || n.(*CustomInlineNode).Match[1] != "foo" {
+		t.Errorf("Select: got %v, expected the custom inline node of @foo", n)
+	}
+	if err := (&Options{CustomInline: []CustomInline{{Pattern: regexp.MustCompile("x")}}}).Validate(); err == nil {
+		t.Error("Validate: expected an error for a rule without Render")
+	}
+}
+
+func TestTypographerZones(t *testing.T) {
+	cases := []struct {
+		zones           Zone
+		input, expected string
+	}{
+		{0, "\"<b>x</b>\" <a title=\"x--y\">a--b</a>", "<p>\u201c<b>x</b>\u201d <a title=\"x--y\">a\u2013b</a></p>"},
+		{0, "<http://a.com/a--b> [x](http://a--b.com/1/2 \"it's\") ![it's](/a--b.png)", "<p><a href=\"http://a.com/a--b\">http://a.com/a--b</a> <a href=\"http://a--b.com/1/2\" title=\"it\u2019s\">x</a> <img src=\"/a--b.png\" alt=\"it\u2019s\"></p>"},
+		{0, "`a--b \"c\" 1/2 (c)` a--b", "<p><code>a--b &quot;c&quot; 1/2 (c)</code> a\u2013b</p>"},
+		{ZoneCode, "`a--b \"c\" (c)`", "<p><code>a\u2013b \u201cc\u201d \u00a9</code></p>"},
+		{ZoneURLs, "<http://a.com/a--b>", "<p><a href=\"http://a.com/a--b\">http://a.com/a\u2013b</a></p>"},
+		{ZoneHTML, "<a title=\"x--y\">a</a>", "<p><a title=\"x--y\">a</a></p>"},
+		{0, "x <abbr title=\"x--y\">a--b</abbr>", "<p>x <abbr title=\"x--y\">a--b</abbr></p>"},
+		{0, "a <b>\"x\"</b>", "<p>a <b>\u201cx\u201d</b></p>"},
+		{ZoneHTML, "x <abbr title=\"x--y\">\"a--b\" <!-- c--d --></abbr>", "<p>x <abbr title=\"x--y\">\u201ca\u2013b\u201d <!-- c--d --></abbr></p>"},
+		{0, "a \ufffc <b>--</b> \ufffc", "<p>a \ufffc <b>\u2013</b> \ufffc</p>"},
+	}
+	for _, c := range cases {
+		opts := &Options{Smartypants: true, Fractions: true, Symbols: SymbolCopyright, TypographerZones: c.zones}
+		if actual := New(c.input, opts).Render(); actual != c.expected {
+			t.Errorf("%s(%d): got\n\t%+v\nexpected\n\t%+v", c.input, c.zones, actual, c.expected)
+		}
+	}
+}
+
+func TestMarkdownInHTML(t *testing.T) {
+	cases := []struct {
+		input, expected string
+	}{
+		{"<div class=\"note\" markdown=\"1\">\n# Hi\n\n*foo* bar\n</div>\n\nafter", "<div class=\"note\">\n<h1 id=\"hi\">Hi</h1>\n<p><em>foo</em> bar</p>\n</div>\n<p>after</p>"},
+		{"<div markdown=1>*x*</div>", "<div>\n<p><em>x</em></p>\n</div>"},
+		{"<section markdown='1'>\n> quote\n</section>", "<section>\n<blockquote><p>quote</p></blockquote>\n</section>"},
+		{"<div>\n*x*\n</div>", "<div>\n*x*\n</div>"},
+		// nested blocks of the same name
+		{"<div markdown=\"1\">\n<div markdown=\"1\">\n*x*\n</div>\n\n*y*\n</div>", "<div>\n<div>\n<p><em>x</em></p>\n</div>\n<p><em>y</em></p>\n</div>"},
+		{"<div markdown=\"1\">\n<div>\n*x*\n</div>\n</div>", "<div>\n<div>\n*x*\n</div>\n</div>"},
+		{"<div markdown=\"1\">\n*x* <abbr markdown=\"1\">y</abbr>\n\n<hr markdown=\"1\"/>\n</div>", "<div>\n<p><em>x</em> <abbr>y</abbr></p>\n<hr/>\n</div>"},
+	}
+	for _, c := range cases {
+		if actual := New(c.input, &Options{MarkdownInHTML: true}).Render(); actual != c.expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", c.input, actual, c.expected)
+		}
+	}
+	input := "<div markdown=\"1\">\n*x*\n</div>"
+	if actual := New(input, nil).Render(); actual != input {
+		t.Errorf("%s: got\n\t%+v\nexpected the html as is without MarkdownInHTML", input, actual)
+	}
+	doc := Parse(input, &Options{MarkdownInHTML: true})
+	if expected := "<div markdown=\"1\">\n\n*x*\n\n</div>"; doc.Markdown() != expected {
+		t.Errorf("Markdown: got\n\t%+v\nexpected\n\t%+v", doc.Markdown(), expected)
+	}
+	if n := doc.Select(NodeEmphasis).First(); n == nil {
+		t.Error("Select: expected the emphasis inside the html block")
+	}
+}
+
+func TestLazyContinuation(t *testing.T) {
+	cases := map[string]string{
+		// blockquotes
+		"> # Foo\n> bar\nbaz":      "<blockquote><h1 id=\"foo\">Foo</h1><p>bar\nbaz</p></blockquote>",
+		"> bar\nbaz\n> foo":        "<blockquote><p>bar\nbaz\nfoo</p></blockquote>",
+		"> foo\n---":               "<blockquote><p>foo</p></blockquote>\n<hr>",
+		"> - foo\n- bar":           "<blockquote><ul>\n<li>foo</li>\n</ul></blockquote>\n<ul>\n<li>bar</li>\n</ul>",
+		">     foo\n    bar":       "<blockquote><pre><code>foo\n</code></pre></blockquote>\n<pre><code>bar</code></pre>",
+		"> foo\n    - bar":         "<blockquote><p>foo\n- bar</p></blockquote>",
+		"> bar\n>\nbaz":            "<blockquote><p>bar</p></blockquote>\n<p>baz</p>",
+		"> > > foo\nbar":           "<blockquote><blockquote><blockquote><p>foo\nbar</p></blockquote></blockquote></blockquote>",
+		"> foo\n# head":            "<blockquote><p>foo</p></blockquote>\n<h1 id=\"head\">head</h1>",
+		"> foo\n1. item":           "<blockquote><p>foo</p></blockquote>\n<ol>\n<li>item</li>\n</ol>",
+		"> foo\n<div>x</div>":      "<blockquote><p>foo</p></blockquote>\n<div>x</div>",
+		"> 1. > Quote\ncontinued.": "<blockquote><ol>\n<li><blockquote><p>Quote\ncontinued.</p></blockquote></li>\n</ol></blockquote>",
+		// list items
+		"  1.  A paragraph\nwith two lines.": "<ol>\n<li>A paragraph\nwith two lines.</li>\n</ol>",
+		"- a\n  > b\n  c\n- d":               "<ul>\n<li>a<blockquote><p>b\nc</p></blockquote></li>\n<li>d</li>\n</ul>",
+		"- foo\n# h":                         "<ul>\n<li>foo</li>\n</ul>\n<h1 id=\"h\">h</h1>",
+		"- foo\n> q":                         "<ul>\n<li>foo</li>\n</ul>\n<blockquote><p>q</p></blockquote>",
+		"- a\n\n      code\nx":               "<ul>\n<li><p>a</p><pre><code>code</code></pre></li>\n</ul>\n<p>x</p>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, nil).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+}
+
+func TestListIndent(t *testing.T) {
+	cases := []struct {
+		input    string
+		opts     *Options
+		expected string
+	}{
+		{"- a\n\n      code", nil, "<ul>\n<li><p>a</p><pre><code>code</code></pre></li>\n</ul>"},
+		{"- a\n\n      b", &Options{ListIndent: 4}, "<ul>\n<li><p>a</p><p>b</p></li>\n</ul>"},
+		{"- a\n    - b\n        - c", &Options{ListIndent: 4}, "<ul>\n<li>a<ul>\n<li>b<ul>\n<li>c</li>\n</ul></li>\n</ul></li>\n</ul>"},
+		{"1. a\n\n       b", &Options{NoListCode: true}, "<ol>\n<li><p>a</p><p>b</p></li>\n</ol>"},
+		{"- a\n  - b\n\n        c", &Options{NoListCode: true}, "<ul>\n<li><p>a</p><ul>\n<li><p>b</p><p>c</p></li>\n</ul></li>\n</ul>"},
+		{"- a\n\n  ```\n  x\n  ```", &Options{NoListCode: true}, "<ul>\n<li><p>a</p><pre><code>\nx\n</code></pre></li>\n</ul>"},
+		{"    code", &Options{NoListCode: true}, "<pre><code>code</code></pre>"},
+	}
+	for _, c := range cases {
+		if actual := New(c.input, c.opts).Render(); actual != c.expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", c.input, actual, c.expected)
+		}
+	}
+	if err := (&Options{ListIndent: -1}).Validate(); err == nil {
+		t.Error("Validate: expected an error for a negative ListIndent")
+	}
+}
+
+func TestCodeTabs(t *testing.T) {
+	cases := []struct {
+		input    string
+		opts     *Options
+		expected string
+	}{
+		{"```make\nall:\n\tgo build\n```", nil, "<pre><code class=\"lang-make\">\nall:\n\tgo build\n</code></pre>"},
+		{"> ```\n> \tx\n> ```", nil, "<blockquote><pre><code>\n\tx\n</code></pre></blockquote>"},
+		{"\tall:\n\t\tgo build", nil, "<pre><code>all:\n\tgo build</code></pre>"},
+		{"- a\n\n\t\tcode", nil, "<ul>\n<li><p>a</p><pre><code>code</code></pre></li>\n</ul>"},
+		{"#\tfoo\n\n-\tbar", nil, "<h1 id=\"foo\">foo</h1>\n<ul>\n<li>bar</li>\n</ul>"},
+		{"```\na\tb\n\tc\n```", &Options{TabWidth: 4}, "<pre><code>\na   b\n    c\n</code></pre>"},
+	}
+	for _, c := range cases {
+		if actual := New(c.input, c.opts).Render(); actual != c.expected {
+			t.Errorf("%q: got\n\t%+v\nexpected\n\t%+v", c.input, actual, c.expected)
+		}
+	}
+}
+
+func TestAttrEscaping(t *testing.T) {
+	cases := []struct {
+		input            string
+		expected, strict string
+	}{
+		{
+			"[a](/u \"<b title='x' onmouseover=alert(1)>\")",
+			"<p><a href=\"/u\" title=\"&lt;b title=&#39;x&#39; onmouseover=alert(1)&gt;\">a</a></p>",
+			"<p><a href=\"/u\">a</a></p>",
+		},
+		{
+			"![<b x=\"y\" onerror=alert(1)>](x)",
+			"<p><img src=\"x\" alt=\"&lt;b x=&quot;y&quot; onerror=alert(1)&gt;\"></p>",
+			"<p><img src=\"x\" alt=\"\"></p>",
+		},
+		{
+			"```a\"onclick=\"alert(1)\nx\n```",
+			"<pre><code class=\"lang-a&quot;onclick=&quot;alert(1)\">\nx\n</code></pre>",
+			"<pre><code class=\"\">\nx\n</code></pre>",
+		},
+		{
+			"[a](/u?a=1&b=2 '&copy; \"x\"')",
+			"<p><a href=\"/u?a=1&amp;b=2\" title=\"&copy; &quot;x&quot;\">a</a></p>",
+			"<p><a href=\"/u?a=1&amp;b=2\" title=\"&copy; &quot;x&quot;\">a</a></p>",
+		},
+	}
+	for _, c := range cases {
+		if actual := Render(c.input); actual != c.expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", c.input, actual, c.expected)
+		}
+		if actual := New(c.input, &Options{StrictAttrs: true}).Render(); actual != c.strict {
+			t.Errorf("%s(strict): got\n\t%+v\nexpected\n\t%+v", c.input, actual, c.strict)
+		}
+	}
+	link := NewLink("/a\x00b", "")
+	if actual, expected := link.Render(), "<a href=\"/a%00b\"></a>"; actual != expected {
+		t.Errorf("NewLink: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	opts := &Options{
+		Classes: map[NodeType]string{NodeParagraph: "p", NodeHeading: "h", NodeList: "l"},
+		Attributer: func(Node) map[string]string {
+			return map[string]string{"data-b": "2", "data-a": "1", "class": "c", "id": "x"}
+		},
+		RawDelims: map[string]string{"<<": ">>", "<<raw": "raw>>", "{{": "}}"},
+	}
+	input := "# Title\n\n- a\n- b\n\n<<raw\n\n*a* >> b\n\nraw>>\n\ntext"
+	expected := New(input, opts).Render()
+	for i := 0; i < 20; i++ {
+		if actual := New(input, opts).Render(); actual != expected {
+			t.Fatalf("Render: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+		}
+		var b strings.Builder
+		s := NewStream(&b, opts)
+		s.Feed([]byte(input))
+		s.Finish()
+		if actual := b.String(); actual != expected {
+			t.Fatalf("Stream: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+		}
+	}
+}
+
+func TestContextRenderFn(t *testing.T) {
+	type key struct{}
+	m := New("hello", nil)
+	m.AddContextRenderFn(NodeText, func(ctx context.Context, n Node) string {
+		if user, ok := ctx.Value(key{}).(string); ok {
+			return n.Render() + ", " + user
+		}
+		return n.Render()
+	})
+	ctx := context.WithValue(context.Background(), key{}, "ariel")
+	if actual, err := m.RenderContext(ctx); err != nil || actual != "<p>hello, ariel</p>" {
+		t.Errorf("ContextRenderFn: got\n\t%+v(%v)\nexpected\n\t%+v", actual, err, "<p>hello, ariel</p>")
+	}
+	if actual := m.Render(); actual != "<p>hello</p>" {
+		t.Errorf("ContextRenderFn: got\n\t%+v\nexpected\n\t%+v", actual, "<p>hello</p>")
+	}
+}
+
 // TODO: Add more tests for it.
 func TestRenderFn(t *testing.T) {
 	m := New("hello world", nil)
@@ -156,6 +507,1156 @@ func TestRenderFn(t *testing.T) {
 	}
 }
 
+func TestReset(t *testing.T) {
+	m := New("[a]\n\n[a]: /a", &Options{SourcePos: true})
+	m.AddRenderFn(NodeHeading, func(n Node) string {
+		return "<h6>" + plainText(n.(*HeadingNode).Nodes) + "</h6>"
+	})
+	if expected := "<p data-sourcepos=\"1:1-1:3\"><a href=\"/a\">a</a></p>\n"; m.Render() != expected {
+		t.Errorf("Render: got\n\t%+v\nexpected\n\t%+v", m.Render(), expected)
+	}
+	cases := map[string]string{
+		"# foo\n\n[a]": "<h6 data-sourcepos=\"1:1-1:5\">foo</h6>\n<p data-sourcepos=\"3:1-3:3\">[a]</p>",
+		"bar":          "<p data-sourcepos=\"1:1-1:3\">bar</p>",
+	}
+	for input, expected := range cases {
+		m.Reset(input)
+		if actual := m.Render(); actual != expected {
+			t.Errorf("Reset(%q): got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+		if m.Input != input || len(m.Mappings()) == 0 {
+			t.Errorf("Reset(%q): got input %q and mappings %v", input, m.Input, m.Mappings())
+		}
+	}
+	// a returned Document keeps its links and spans
+	m.Reset("[a]\n\n[a]: /a")
+	doc := m.Document()
+	m.Reset("bar")
+	m.Render()
+	if doc.Links["a"] == nil || len(doc.spans) != 2 {
+		t.Errorf("Reset: got document links %v and spans %v", doc.Links, doc.spans)
+	}
+}
+
+func TestMarkDocument(t *testing.T) {
+	var parses int
+	m := New("# Title\n\n*foo*", &Options{Metrics: func(Metrics) { parses++ }})
+	if expected := "<h1 id=\"title\">Title</h1>\n<p><em>foo</em></p>"; m.Render() != expected {
+		t.Errorf("Render: got\n\t%+v\nexpected\n\t%+v", m.Render(), expected)
+	}
+	doc := m.Document()
+	if len(doc.Nodes) != 2 || doc.Nodes[0] != m.Nodes[0] {
+		t.Errorf("Document: got nodes %v, expected the parsed nodes %v", doc.Nodes, m.Nodes)
+	}
+	if expected := "# Title\n\n*foo*"; doc.Markdown() != expected {
+		t.Errorf("Markdown: got\n\t%+v\nexpected\n\t%+v", doc.Markdown(), expected)
+	}
+	if expected := "Title\n\nfoo"; m.RenderText() != expected {
+		t.Errorf("RenderText: got\n\t%+v\nexpected\n\t%+v", m.RenderText(), expected)
+	}
+}
+
+func TestDocument(t *testing.T) {
+	doc := NewDocument(
+		NewHeading(1, NewText("Hello "), NewStrong(NewText("world"))),
+		NewParagraph(NewText("a < b"), NewBr(), NewLink("http://a.com", "", NewText("link"))),
+		NewList(false, NewListItem(NewCheckbox(true), NewText("done")), NewListItem(NewInlineCode("<tag>"))),
+		NewHr(),
+		NewTable(
+			NewRow(NewCell(Header, None, NewText("a")), NewCell(Header, Right, NewText("b"))),
+			NewRow(NewCell(Data, None, NewText("1")), NewCell(Data, Right, NewText("2"))),
+		),
+		NewCode("go", "x := <-c"),
+	)
+	m := FromDocument(doc, nil)
+	m.AddRenderFn(NodeHr, func(Node) string { return "<hr/>" })
+	expected := "<h1 id=\"hello-world\">Hello <strong>world</strong></h1>\n" +
+		"<p>a &lt; b<br><a href=\"http://a.com\">link</a></p>\n" +
+		"<ul>\n<li><input type=\"checkbox\" checked>done</li>\n<li><code>&lt;tag&gt;</code></li>\n</ul>\n" +
+		"<hr/>\n" +
+		"<table>\n<thead>\n<tr>\n<th>a</th>\n<th style=\"text-align:right\">b</th>\n</tr>\n</thead>\n" +
+		"<tbody>\n<tr>\n<td>1</td>\n<td style=\"text-align:right\">2</td>\n</tr>\n</tbody>\n</table>\n" +
+		"<pre><code class=\"lang-go\">x := &lt;-c</code></pre>"
+	if actual := m.Render(); actual != expected {
+		t.Errorf("Document: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+}
+
+func TestRenderNode(t *testing.T) {
+	doc := Parse("# Title\n\n- foo\n- [bar](http://bar.com)", nil)
+	m := FromDocument(doc, nil)
+	m.AddRenderFn(NodeLink, func(n Node) string {
+		l, _ := n.(*LinkNode)
+		return "<a href=\"" + l.Href + "\" rel=\"nofollow\">" + m.RenderNode(l.Nodes[0]) + "</a>"
+	})
+	list := doc.Nodes[1].(*ListNode)
+	expected := "<li><a href=\"http://bar.com\" rel=\"nofollow\">bar</a></li>"
+	if actual := m.RenderNode(list.Items[1]); actual != expected {
+		t.Errorf("RenderNode: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+	expected = "<h1 id=\"title\">Title</h1>"
+	if actual := m.RenderNode(doc.Nodes[0]); actual != expected {
+		t.Errorf("RenderNode: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+	// link definitions aren't passed to the render function of links
+	m = New("[a]\n\n[a]: /a", nil)
+	m.AddRenderFn(NodeLink, func(n Node) string {
+		return "<link>"
+	})
+	if actual := m.Render(); actual != "<p><link></p>\n" {
+		t.Errorf("RenderFn: got\n\t%+v\nexpected\n\t%+v", actual, "<p><link></p>\n")
+	}
+}
+
+func TestSourcePos(t *testing.T) {
+	opts := DefaultOptions()
+	opts.SourcePos = true
+	input := "# Title\n\nfoo\nbar\n\n- a\n- b\n\n> quote\n> text\n\n***"
+	expected := "<h1 data-sourcepos=\"1:1-1:7\" id=\"title\">Title</h1>\n" +
+		"<p data-sourcepos=\"3:1-4:3\">foo\nbar</p>\n" +
+		"<ul data-sourcepos=\"6:1-7:3\">\n<li data-sourcepos=\"6:1-6:3\">a</li>\n<li data-sourcepos=\"7:1-7:3\">b</li>\n</ul>\n" +
+		"<blockquote data-sourcepos=\"9:1-10:6\"><p data-sourcepos=\"9:3-10:6\">quote\ntext</p></blockquote>\n" +
+		"<hr data-sourcepos=\"12:1-12:3\">"
+	if actual := New(input, opts).Render(); actual != expected {
+		t.Errorf("SourcePos: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+	// the columns of the lines with tabs are the columns in the input
+	input = "# a\tb\n\n- c\n \t- d\n\n> e\tf"
+	expected = "<h1 data-sourcepos=\"1:1-1:5\" id=\"a-b\">a    b</h1>\n" +
+		"<ul data-sourcepos=\"3:1-4:5\">\n<li data-sourcepos=\"3:1-4:5\">c\n<ul data-sourcepos=\"4:3-4:5\">\n<li data-sourcepos=\"4:3-4:5\">d</li>\n</ul></li>\n</ul>\n" +
+		"<blockquote data-sourcepos=\"6:1-6:5\"><p data-sourcepos=\"6:3-6:5\">e    f</p></blockquote>"
+	if actual := New(input, opts).Render(); actual != expected {
+		t.Errorf("SourcePos: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+}
+
+func TestMappings(t *testing.T) {
+	m := New("# Title\n\nfoo\nbar\n\n[a]: b\n\n- a\n- b", nil)
+	output := m.Render()
+	expected := []struct {
+		span   string
+		output string
+	}{
+		{"1:1-1:7", "<h1 id=\"title\">Title</h1>"},
+		{"3:1-4:3", "<p>foo\nbar</p>"},
+		{"8:1-9:3", "<ul>\n<li>a</li>\n<li>b</li>\n</ul>"},
+	}
+	mappings := m.Mappings()
+	if len(mappings) != len(expected) {
+		t.Fatalf("Mappings: got %d mappings, expected %d", len(mappings), len(expected))
+	}
+	for i, e := range expected {
+		mp := mappings[i]
+		if span, out := mp.Span.String(), output[mp.Start:mp.End]; span != e.span || out != e.output {
+			t.Errorf("Mappings: got\n\t%s %q\nexpected\n\t%s %q", span, out, e.span, e.output)
+		}
+	}
+}
+
+func TestRenderE(t *testing.T) {
+	// rows with more cells than the header row are truncated
+	expected := "<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n" +
+		"<tbody>\n<tr>\n<td>1</td>\n<td>2</td>\n</tr>\n</tbody>\n</table>"
+	if actual, err := RenderE("a|b\n-|-\n1|2|3"); err != nil || actual != expected {
+		t.Errorf("RenderE: got\n\t%+v(%v)\nexpected\n\t%+v", actual, err, expected)
+	}
+	// leading pipe in the header row only
+	if _, err := RenderE("|a|b|\n-|-\n"); err != nil {
+		t.Errorf("RenderE: got error %v, expected nil", err)
+	}
+	m := New("hello", nil)
+	m.AddRenderFn(NodeText, func(Node) string {
+		panic("boom")
+	})
+	if _, err := m.RenderE(); err == nil || err.Error() != "mark: boom" {
+		t.Errorf("RenderE: got error %v, expected \"mark: boom\"", err)
+	}
+}
+
+func TestRenderContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	actual, err := New("foo\n\nbar", nil).RenderContext(ctx)
+	if expected := "<p>foo</p>\n<p>bar</p>"; err != nil || actual != expected {
+		t.Errorf("RenderContext: got\n\t%+v(%v)\nexpected\n\t%+v", actual, err, expected)
+	}
+	cancel()
+	if _, err := New("foo\n\nbar", nil).RenderContext(ctx); err != context.Canceled {
+		t.Errorf("RenderContext: got error %v, expected %v", err, context.Canceled)
+	}
+	if _, err := ParseContext(ctx, "foo", nil); err != context.Canceled {
+		t.Errorf("ParseContext: got error %v, expected %v", err, context.Canceled)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	m := New("'hi'", &Options{Smartypants: true})
+	if opts := m.options; !opts.Gfm || !opts.Tables || !opts.Smartypants {
+		t.Errorf("Options: got %+v, expected merged with the defaults", opts)
+	}
+	opts := DefaultOptions()
+	opts.Tables = false
+	if m := New("", opts); m.options.Tables {
+		t.Errorf("Options: got Tables=true, expected DefaultOptions to be used as is")
+	}
+	noGfm := DefaultOptions()
+	noGfm.Gfm = false
+	for _, c := range []struct {
+		opts  *Options
+		valid bool
+	}{
+		{DefaultOptions(), true},
+		{&Options{Smartypants: true, Locale: "fr-CA"}, true},
+		{noGfm, false},
+		{&Options{Gfm: true, Locale: "fr"}, false},
+		{&Options{Gfm: true, Smartypants: true, Locale: "xx"}, false},
+	} {
+		if err := c.opts.merge().Validate(); (err == nil) != c.valid {
+			t.Errorf("Validate(%+v): got %v, expected valid=%v", c.opts, err, c.valid)
+		}
+	}
+	if _, err := New("", &Options{Locale: "fr"}).RenderE(); err == nil {
+		t.Errorf("RenderE: got nil error, expected invalid options error")
+	}
+	for _, opts := range []*Options{{NoGfm: true}, {NoGfm: true, Tables: true}, {Smartypants: true, NoTables: true}, {Gfm: true, NoTables: true}} {
+		merged := opts.merge()
+		if merged.Tables || merged.Gfm == opts.NoGfm {
+			t.Errorf("NoGfm/NoTables(%+v): got Gfm=%v Tables=%v", opts, merged.Gfm, merged.Tables)
+		}
+		if err := merged.Validate(); err != nil {
+			t.Errorf("NoGfm/NoTables(%+v): got %v, expected valid options", opts, err)
+		}
+	}
+	expected := "<p>snake<em>case</em>name</p>"
+	if actual := New("snake_case_name", &Options{NoGfm: true}).Render(); actual != expected {
+		t.Errorf("NoGfm: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+}
+
+func TestSetDefaultOptions(t *testing.T) {
+	SetDefaultOptions(&Options{Gfm: true, Tables: true, Smartypants: true})
+	defer SetDefaultOptions(nil)
+	expected := "<p>\u201chi\u201d</p>"
+	if actual := Render(`"hi"`); actual != expected {
+		t.Errorf("SetDefaultOptions: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+	expected = "<p>\u00abhi\u00bb</p>"
+	if actual := New(`"hi"`, &Options{Locale: "fr"}).Render(); actual != expected {
+		t.Errorf("SetDefaultOptions: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+	classes := map[NodeType]string{NodeParagraph: "p"}
+	SetDefaultOptions(&Options{Gfm: true, Classes: classes})
+	classes[NodeParagraph] = "changed"
+	DefaultOptions().Classes[NodeParagraph] = "changed"
+	if actual := DefaultOptions().Classes[NodeParagraph]; actual != "p" {
+		t.Errorf("SetDefaultOptions: got class %q, expected the default maps to be copied", actual)
+	}
+	SetDefaultOptions(nil)
+	if opts := DefaultOptions(); opts.Smartypants || !opts.Gfm {
+		t.Errorf("SetDefaultOptions: got %+v after reset, expected the built-in defaults", opts)
+	}
+}
+
+func TestRenderBytes(t *testing.T) {
+	if actual := string(RenderBytes([]byte("__foo__"))); actual != "<p><strong>foo</strong></p>" {
+		t.Errorf("RenderBytes: got %+v", actual)
+	}
+	buf := make([]byte, 0, 64)
+	buf = AppendRender(buf, []byte("foo"))
+	buf = AppendRender(append(buf, '\n'), []byte("bar"))
+	if actual := string(buf); actual != "<p>foo</p>\n<p>bar</p>" {
+		t.Errorf("AppendRender: got %+v", actual)
+	}
+	if out := AppendRender(buf[:0], []byte("baz")); &out[0] != &buf[0] {
+		t.Errorf("AppendRender: the output is not written into dst")
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	var b bytes.Buffer
+	input := "# foo\n\nbar\n\n- baz"
+	n, err := New(input, nil).WriteTo(&b)
+	expected := Render(input)
+	if err != nil || b.String() != expected || n != int64(len(expected)) {
+		t.Errorf("WriteTo: got\n\t%+v(%d, %v)\nexpected\n\t%+v", b.String(), n, err, expected)
+	}
+}
+
+func TestDump(t *testing.T) {
+	doc := Parse("# Title\n\nfoo __bar__\n\n- [x] done", nil)
+	expected := `HeadingNode 1:1-1:7 level=1
+  TextNode @0 "Title"
+ParagraphNode 3:1-3:11
+  TextNode @0 "foo "
+  EmphasisNode @4 tag=strong
+    TextNode @0 "bar"
+ListNode 5:1-5:10 ordered=false
+  ListItemNode 5:1-5:10
+    CheckboxNode @22 checked=true
+    TextNode @0 "done"
+`
+	if actual := doc.String(); actual != expected {
+		t.Errorf("Dump: got\n%s\nexpected\n%s", actual, expected)
+	}
+}
+
+func TestConcurrentRender(t *testing.T) {
+	input := "[foo][bar] and [baz]\n\n- [x] __foo__\n\n[bar]: http://bar.com\n[baz]: http://baz.com"
+	expected := Render(input)
+	m := New(input, nil)
+	var wg sync.WaitGroup
+	results := make([]string, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				results[i] = m.Render()
+			} else {
+				var b bytes.Buffer
+				m.WriteTo(&b)
+				results[i] = b.String()
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, actual := range results {
+		if actual != expected {
+			t.Errorf("ConcurrentRender: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+		}
+	}
+	// rendering again returns the same output
+	if actual := m.Render(); actual != expected {
+		t.Errorf("Render twice: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+}
+
+func TestPathological(t *testing.T) {
+	cases := map[string]string{
+		"stars":           strings.Repeat("*", 10000),
+		"stars and text":  strings.Repeat("*a", 10000),
+		"underscores":     strings.Repeat("_a", 10000),
+		"open brackets":   strings.Repeat("[", 10000),
+		"nested brackets": strings.Repeat("[", 5000) + "a" + strings.Repeat("]", 5000),
+		"link openers":    strings.Repeat("[a](b", 3000),
+		"unclosed links":  strings.Repeat("[](", 5000),
+		"reference links": strings.Repeat("[a]", 5000),
+		"emphasis":        strings.Repeat("*a **a ", 3000),
+		"nested emphasis": strings.Repeat("*a ", 3000) + strings.Repeat(" a*", 3000),
+		"backticks":       strings.Repeat("`a``", 3000),
+		"tildes":          strings.Repeat("~~a ", 5000),
+		"less than":       strings.Repeat("a <", 10000),
+		"unclosed tags":   strings.Repeat("<a", 5000) + strings.Repeat(">", 5000),
+		"entities":        strings.Repeat("&", 10000),
+		"words":           strings.Repeat("a ", 20000),
+		"nested quotes":   strings.Repeat(">", 5000) + " a",
+		"quote markers":   strings.Repeat("> ", 5000) + "a",
+		"list markers":    strings.Repeat("- ", 2000) + "a",
+		"nested lists":    nestedLists(500),
+		"open underscore": strings.Repeat("_a ", 20000) + "_",
+		"open strong":     strings.Repeat("__a ", 20000) + "__",
+		"open images":     strings.Repeat("![a ", 20000) + "](b)",
+		"link titles":     strings.Repeat("[a](x (y) ", 10000),
+		"open comments":   strings.Repeat("<a <!--", 10000),
+		"unclosed blocks": strings.Repeat("<div>", 10000),
+		"long fences":     strings.Repeat("`", 2000) + "\na\n" + strings.Repeat("`", 2000),
+		"deep indents":    "- " + strings.Repeat(" ", 1500) + "a\n  b",
+	}
+	for name, input := range cases {
+		start := time.Now()
+		New(input, &Options{Gfm: true}).Render()
+		if d := time.Since(start); d > 2*time.Second {
+			t.Errorf("%s: rendering took %v", name, d)
+		}
+	}
+}
+
+func TestSmartypantsLinear(t *testing.T) {
+	opts := &Options{Smartypants: true, Fractions: true}
+	// the best of a few runs, so the ratio isn't skewed by a slow run
+	render := func(n int) time.Duration {
+		input := strings.Repeat("\"a\" 'b' -- c... ", n)
+		best := time.Duration(1<<63 - 1)
+		for i := 0; i < 3; i++ {
+			start := time.Now()
+			New(input, opts).Render()
+			if d := time.Since(start); d < best {
+				best = d
+			}
+		}
+		return best
+	}
+	small, large := render(5000), render(20000)
+	if large > 2*time.Second || large > 10*small {
+		t.Errorf("Smartypants: got %v for 4x the input that took %v, expected linear time", large, small)
+	}
+}
+
+// nestedLists returns n lists, each nested in the previous one.
+func nestedLists(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		b.WriteString(strings.Repeat("  ", i) + "- a\n")
+	}
+	return b.String()
+}
+
+func TestMaxDepth(t *testing.T) {
+	cases := []struct {
+		input    string
+		depth    int
+		expected string
+	}{
+		{">>> a", 1, "<blockquote><p>&gt;&gt; a</p></blockquote>"},
+		{">>> a", 2, "<blockquote><blockquote><p>&gt; a</p></blockquote></blockquote>"},
+		{">>> a", -1, "<blockquote><blockquote><blockquote><p>a</p></blockquote></blockquote></blockquote>"},
+		{"- - - a", 1, "<ul>\n<li>- - a</li>\n</ul>"},
+		{"> - > a", 2, "<blockquote><ul>\n<li>&gt; a</li>\n</ul></blockquote>"},
+	}
+	for _, c := range cases {
+		if actual := New(c.input, &Options{MaxDepth: c.depth}).Render(); actual != c.expected {
+			t.Errorf("%s(%d): got\n\t%+v\nexpected\n\t%+v", c.input, c.depth, actual, c.expected)
+		}
+	}
+	input := strings.Repeat(">", 100) + " a"
+	if actual := New(input, CommentOptions()).Render(); strings.Count(actual, "<blockquote>") != 16 {
+		t.Errorf("CommentOptions: got\n\t%+v\nexpected 16 nested blockquotes", actual)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	opts := &Options{
+		DisabledBlocks:  map[NodeType]bool{NodeHeading: true, NodeHTML: true},
+		DisabledInlines: map[NodeType]bool{NodeImage: true, NodeHTML: true},
+	}
+	cases := map[string]string{
+		"# Title":                  "<p># Title</p>",
+		"<div>\n<b>hi</b>\n</div>": "<p>&lt;div&gt;\n&lt;b&gt;hi&lt;/b&gt;\n&lt;/div&gt;</p>",
+		"![alt](src.png) *ok*":     "<p>![alt](src.png) <em>ok</em></p>",
+		"foo <b>bar</b>":           "<p>foo &lt;b&gt;bar&lt;/b&gt;</p>",
+		"> ## Quote":               "<blockquote><p>## Quote</p></blockquote>",
+		"- [link](/url)":           "<ul>\n<li><a href=\"/url\">link</a></li>\n</ul>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, opts).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+}
+
+func TestCommentOptions(t *testing.T) {
+	cases := map[string]string{
+		"# Title\n\n***":                           "<p># Title</p>\n<p>***</p>",
+		"*hi* `code`\nhttp://a.com":                "<p><em>hi</em> <code>code</code><br><a href=\"http://a.com\">http://a.com</a></p>",
+		"> quote":                                  "<blockquote><p>quote</p></blockquote>",
+		"![x](y.png) <script>alert(1)</script>":    "<p>![x](y.png) &lt;script&gt;alert(1)&lt;/script&gt;</p>",
+		"[a](javascript:void) [b](JaVa\tScRiPt:x)": "<p><a href=\"\">a</a> <a href=\"\">b</a></p>",
+		"[a][b]\n\n[b]: &#106;avascript:x":         "<p><a href=\"\">a</a></p>\n",
+	}
+	for input, expected := range cases {
+		if actual := New(input, CommentOptions()).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+}
+
+func TestClasses(t *testing.T) {
+	opts := &Options{Classes: map[NodeType]string{
+		NodeTable:      "table table-striped",
+		NodeBlockQuote: "quote",
+		NodeEmoji:      "small",
+		NodeText:       "ignored",
+	}, Emoji: map[string]string{"x": "x.png"}}
+	cases := map[string]string{
+		"> hi :x:": "<blockquote class=\"quote\"><p>hi <img class=\"small emoji\" src=\"x.png\" alt=\":x:\"></p></blockquote>",
+		"a|b\n-|-\nc|d": "<table class=\"table table-striped\">\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n" +
+			"<tbody>\n<tr>\n<td>c</td>\n<td>d</td>\n</tr>\n</tbody>\n</table>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, opts).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+}
+
+func TestClassPrefix(t *testing.T) {
+	opts := &Options{
+		ClassPrefix: "md-",
+		Classes:     map[NodeType]string{NodeCode: "code  block"},
+		Emoji:       map[string]string{"x": "x.png"},
+	}
+	cases := map[string]string{
+		"```js\nfoo\n```": "<pre class=\"md-code md-block\"><code class=\"md-lang-js\">\nfoo\n</code></pre>",
+		":x:":             "<p><img class=\"md-emoji\" src=\"x.png\" alt=\":x:\"></p>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, opts).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+}
+
+func TestEmailOptions(t *testing.T) {
+	opts := EmailOptions(map[NodeType]string{NodeCode: "background:#eee", NodeParagraph: "margin:0"})
+	opts.Details, opts.Tabs, opts.Ruby = true, true, true
+	cases := map[string]string{
+		"```go\nx := 1\n```":           "<table style=\"background:#eee\" role=\"presentation\" width=\"100%\"><tr><td><pre><code class=\"lang-go\">\nx := 1\n</code></pre></td></tr></table>",
+		"- [x] done\n- [ ] todo":       "<ul>\n<li>☑ done</li>\n<li>☐ todo</li>\n</ul>",
+		":::details More\nhidden\n:::": "<div><p><strong>More</strong></p><p style=\"margin:0\">hidden</p></div>",
+		"=== \"A\"\n\n    a":           "<div><p><strong>A</strong></p><div class=\"tabbed-block\"><p style=\"margin:0\">a</p></div></div>",
+		"{漢字|かんじ} <script>x</script>":  "<p style=\"margin:0\">漢字(かんじ) &lt;script>x&lt;/script></p>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, opts).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+}
+
+func TestFeedOptions(t *testing.T) {
+	opts := FeedOptions("https://example.com/blog/post/")
+	opts.Fractions = true
+	cases := map[string]string{
+		"[a](../x?a=1&b=2) [b](#top) [c](//cdn.com/y) ![i](img/a.png)": "<p><a href=\"https://example.com/blog/x?a=1&amp;b=2\">a</a> <a href=\"https://example.com/blog/post/#top\">b</a> <a href=\"https://cdn.com/y\">c</a> <img src=\"https://example.com/blog/post/img/a.png\" alt=\"i\" /></p>",
+		"&copy; &nbsp; &amp; 1/2 é":                                    "<p>&#169; &#160; &amp; &#189; é</p>",
+		"- [x] done\n\nbr  \nx":                                        "<ul>\n<li><input type=\"checkbox\" checked=\"checked\" />done</li>\n</ul>\n<p>br<br />x</p>",
+		"<iframe src=x></iframe>\n\n<script>x</script>":                "<p>&lt;iframe src=x&gt;&lt;/iframe&gt;</p>\n<p>&lt;script&gt;x&lt;/script&gt;</p>",
+		"<div>\n<br>\n</div>\n\na <img src=\"x.png\"> b":               "<p>&lt;div&gt;\n&lt;br&gt;\n&lt;/div&gt;</p>\n<p>a &lt;img src=\"x.png\"&gt; b</p>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, opts).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+	if err := FeedOptions("/blog/").Validate(); err == nil {
+		t.Errorf("Validate: expected an error for relative BaseURL")
+	}
+}
+
+func TestAttributer(t *testing.T) {
+	opts := &Options{Attributer: func(n Node) map[string]string {
+		switch n := n.(type) {
+		case *HeadingNode:
+			return map[string]string{"id": "custom", "data-level": strconv.Itoa(n.Level)}
+		case *ParagraphNode:
+			return map[string]string{"class": "lead", "title": "a \"b\""}
+		case *TextNode:
+			return map[string]string{"id": "ignored"}
+		}
+		return nil
+	}}
+	cases := map[string]string{
+		"## Hello": "<h2 data-level=\"2\" id=\"custom\">Hello</h2>",
+		"hello":    "<p class=\"lead\" title=\"a &quot;b&quot;\">hello</p>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, opts).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+}
+
+func TestHeadingFn(t *testing.T) {
+	m := New("## Hello *world*", nil)
+	m.SetHeadingFn(func(level int, id, text, children string) string {
+		return fmt.Sprintf("<h%d id=\"%s\"><a href=\"#%[2]s\">%s</a><!-- %s --></h%[1]d>", level, id, children, text)
+	})
+	expected := "<h2 id=\"hello-world-\"><a href=\"#hello-world-\">Hello <em>world</em></a><!-- Hello *world* --></h2>"
+	if actual := m.Render(); actual != expected {
+		t.Errorf("HeadingFn: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+}
+
+func TestAlerts(t *testing.T) {
+	cases := map[string]string{
+		"> [!NOTE]\n> Read this.":           "<div class=\"markdown-alert markdown-alert-note\"><p class=\"markdown-alert-title\">Note</p><p>Read this.</p></div>",
+		"> [!warning]\n> Careful\n>\n> - a": "<div class=\"markdown-alert markdown-alert-warning\"><p class=\"markdown-alert-title\">Warning</p><p>Careful</p><ul>\n<li>a</li>\n</ul></div>",
+		"> [!FOO]\n> bar":                   "<blockquote><p>[!FOO]\nbar</p></blockquote>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, &Options{Alerts: true}).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+	if md := Parse("> [!TIP]\n> foo", &Options{Alerts: true}).Markdown(); md != "> [!TIP]\n> foo" {
+		t.Errorf("Alerts: got markdown %q", md)
+	}
+}
+
+func TestBlockQuoteDepth(t *testing.T) {
+	opts := &Options{Attributer: func(n Node) map[string]string {
+		if q, ok := n.(*BlockQuoteNode); ok {
+			return map[string]string{"class": "depth-" + strconv.Itoa(q.Depth)}
+		}
+		return nil
+	}}
+	cases := map[string]string{
+		"> a\n> > b\n> > > c\n>\n> d":          "<blockquote class=\"depth-1\"><p>a</p><blockquote class=\"depth-2\"><p>b</p><blockquote class=\"depth-3\"><p>c</p></blockquote></blockquote><p>d</p></blockquote>",
+		"- > in list\n  > - > nested\n\n> top": "<ul>\n<li><blockquote class=\"depth-1\"><p>in list</p><ul>\n<li><blockquote class=\"depth-2\"><p>nested</p></blockquote></li>\n</ul></blockquote></li>\n</ul>\n<blockquote class=\"depth-1\"><p>top</p></blockquote>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, opts).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+}
+
+func TestDetails(t *testing.T) {
+	cases := map[string]string{
+		":::details Click *me*\nhidden\n\n- a\n:::\n\nafter": "<details><summary>Click <em>me</em></summary><p>hidden</p><ul>\n<li>a</li>\n</ul></details>\n<p>after</p>",
+		":::details A\n:::details B\nb\n:::\n:::":            "<details><summary>A</summary><details><summary>B</summary><p>b</p></details></details>",
+		":::details open": "<details><summary>open</summary></details>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, &Options{Details: true}).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+	if actual, expected := Render(":::details A\nb\n:::"), "<p>:::details A\nb\n:::</p>"; actual != expected {
+		t.Errorf("Details: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+}
+
+func TestDivs(t *testing.T) {
+	cases := map[string]string{
+		"::: {.note #n1 key=val title=\"A title\"}\n*hi*\n:::\n\nafter": "<div id=\"n1\" class=\"note\" title=\"A title\"><p><em>hi</em></p></div>\n<p>after</p>",
+		"::: {onclick=\"alert(1)\" style=x data-a=1 lang=en}\n:::":      "<div data-a=\"1\" lang=\"en\"></div>",
+		"::: note\n```\n:::\n```\n:::":                                  "<div class=\"note\"><pre><code>\n:::\n</code></pre></div>",
+		"::: warning :::\nfoo\n:::":                                     "<div class=\"warning\"><p>foo</p></div>",
+		":::: {.a .b}\n::: c\nnested\n:::\n::::":                        "<div class=\"a b\"><div class=\"c\"><p>nested</p></div></div>",
+		"::: {#x class=\"y z\" data-q='<\">'}\n:::":                     "<div id=\"x\" class=\"y z\" data-q=\"&lt;&quot;&gt;\"></div>",
+		"::: open\n- a":                    "<div class=\"open\"><ul>\n<li>a</li>\n</ul></div>",
+		":::details A\n::: b\nc\n:::\n:::": "<details><summary>A</summary><div class=\"b\"><p>c</p></div></details>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, &Options{Divs: true, Details: true}).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+	if actual, expected := Render("::: note\nb\n:::"), "<p>::: note\nb\n:::</p>"; actual != expected {
+		t.Errorf("Divs: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+	input := "::: {#n1 .note .wide key=\"val\"}\ntext\n\n::: tip\nnested\n:::\n:::"
+	if md := Parse(input, &Options{Divs: true}).Markdown(); md != input {
+		t.Errorf("Divs: got markdown %q", md)
+	}
+}
+
+func TestTabs(t *testing.T) {
+	input := "=== \"Go\"\n\n    ```go\n    fmt.Println()\n    ```\n\n=== \"Python\"\n\n    print()\n\nafter"
+	expected := "<div class=\"tabbed-set\"><div class=\"tabbed-labels\"><label>Go</label><label>Python</label></div>" +
+		"<div class=\"tabbed-content\"><div class=\"tabbed-block\"><pre><code class=\"lang-go\">\nfmt.Println()\n</code></pre></div>" +
+		"<div class=\"tabbed-block\"><p>print()</p></div></div></div>\n<p>after</p>"
+	if actual := New(input, &Options{Tabs: true}).Render(); actual != expected {
+		t.Errorf("Tabs: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+	m := New(input, &Options{Tabs: true})
+	m.SetTabsFn(func(titles, panels []string) string {
+		return strings.Join(titles, ",")
+	})
+	if actual, expected := m.Render(), "Go,Python\n<p>after</p>"; actual != expected {
+		t.Errorf("TabsFn: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+	if md := Parse(input, &Options{Tabs: true}).Markdown(); md != input {
+		t.Errorf("Tabs: got markdown %q", md)
+	}
+}
+
+func TestDocumentNode(t *testing.T) {
+	input := "---\ntitle: Hello\n---\n# Hi [foo] and [bar]\n\n[foo]: /foo"
+	doc := Parse(input, &Options{FrontMatter: true})
+	if doc.FrontMatter != "title: Hello" {
+		t.Errorf("FrontMatter: got %q, expected %q", doc.FrontMatter, "title: Hello")
+	}
+	if l := doc.Links["foo"]; l == nil || l.Href != "/foo" {
+		t.Errorf("Links: got %+v, expected [foo]: /foo", doc.Links)
+	}
+	if len(doc.Diagnostics) != 1 || doc.Diagnostics[0].Message != `undefined reference "bar"` {
+		t.Errorf("Diagnostics: got %+v, expected an undefined reference", doc.Diagnostics)
+	}
+	if span := doc.spans[doc.Nodes[0]].String(); span != "4:1-4:20" {
+		t.Errorf("FrontMatter: got heading span %s, expected 4:1-4:20", span)
+	}
+	expected := "<h1 id=\"hi-foo-and-bar-\">Hi <a href=\"/foo\">foo</a> and [bar]</h1>"
+	if actual := doc.Render(); actual != expected {
+		t.Errorf("Render: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+	if n := len(Selection{doc}.Select(NodeHeading)); n != 1 {
+		t.Errorf("Select: got %d headings, expected 1", n)
+	}
+}
+
+func TestAutolinkLength(t *testing.T) {
+	cases := map[string]string{
+		"https://www.example.com/a/very/long/path?query=1#frag": "<p><a href=\"https://www.example.com/a/very/long/path?query=1#frag\">example.com/a/very/…</a></p>",
+		"<https://example.com/docs/>":                           "<p><a href=\"https://example.com/docs/\">example.com/docs</a></p>",
+		"http://a.io/x [a long link text](/z)":                  "<p><a href=\"http://a.io/x\">http://a.io/x</a> <a href=\"/z\">a long link text</a></p>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, &Options{Gfm: true, AutolinkLength: 20}).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+}
+
+func TestLiteralErrors(t *testing.T) {
+	cases := []struct {
+		input, expected, diagnostic string
+	}{
+		{"intro\n\n```go\nfoo *bar*", "<p>intro</p>\n<p>```go</p>\n<p>foo <em>bar</em></p>", "unclosed code fence"},
+		{"| a | b |\n|---|---|\n| 1 | 2 | 3 |", "<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td>1</td>\n<td>2 | 3</td>\n</tr>\n</tbody>\n</table>", "table row has 3 cells, expected 2"},
+		{"see [foo][bar]", "<p>see <mark>[foo][bar]</mark></p>", `undefined reference "bar"`},
+	}
+	for _, c := range cases {
+		m := New(c.input, &Options{Gfm: true, Tables: true, LiteralErrors: true})
+		m.AddRenderFn(NodeLiteral, func(n Node) string {
+			if l := n.(*LiteralNode); strings.HasPrefix(l.Reason, "undefined") {
+				return "<mark>" + l.Text + "</mark>"
+			}
+			return n.Render()
+		})
+		if actual := m.Render(); actual != c.expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", c.input, actual, c.expected)
+		}
+		if d := m.Document().Diagnostics; len(d) != 1 || d[0].Message != c.diagnostic {
+			t.Errorf("%s: got diagnostics %+v, expected %q", c.input, d, c.diagnostic)
+		}
+	}
+	if actual, expected := Render("```go\nfoo"), "<pre><code class=\"lang-go\">\nfoo</code></pre>"; actual != expected {
+		t.Errorf("Render: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+}
+
+func TestSharedLinks(t *testing.T) {
+	shared := Parse("[rfc2119]: https://www.rfc-editor.org/rfc/rfc2119\n[go]: https://go.dev \"Go\"", nil).Links
+	input := "See [rfc2119], [Go][go] and [bar].\n\n[go]: https://golang.org"
+	doc := Parse(input, &Options{Links: shared})
+	expected := "<p>See <a href=\"https://www.rfc-editor.org/rfc/rfc2119\">rfc2119</a>, <a href=\"https://golang.org\">Go</a> and [bar].</p>"
+	if actual := doc.Render(); actual != expected {
+		t.Errorf("Render: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+	var messages []string
+	for _, d := range doc.Diagnostics {
+		messages = append(messages, d.Message)
+	}
+	if actual, expected := strings.Join(messages, "; "), `undefined reference "bar"; reference "go" conflicts with a shared definition`; actual != expected {
+		t.Errorf("Diagnostics: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+	if _, ok := doc.Links["rfc2119"]; ok {
+		t.Errorf("Links: shared definitions should not be added to the document links")
+	}
+}
+
+func TestRenderAll(t *testing.T) {
+	inputs := make([]string, 100)
+	for i := range inputs {
+		inputs[i] = "# doc " + strconv.Itoa(i) + "\n\n*text*"
+	}
+	for _, workers := range []int{0, 1, 8} {
+		outputs := RenderAll(inputs, nil, workers)
+		for i, input := range inputs {
+			if expected := Render(input); outputs[i] != expected {
+				t.Errorf("RenderAll(%d): got\n\t%+v\nexpected\n\t%+v", workers, outputs[i], expected)
+			}
+		}
+	}
+}
+
+type mapCache struct {
+	sync.Mutex
+	m          map[string]string
+	hits, sets int
+}
+
+func (c *mapCache) Get(key string) (string, bool) {
+	c.Lock()
+	defer c.Unlock()
+	s, ok := c.m[key]
+	if ok {
+		c.hits++
+	}
+	return s, ok
+}
+
+func (c *mapCache) Set(key, value string) {
+	c.Lock()
+	defer c.Unlock()
+	c.m[key] = value
+	c.sets++
+}
+
+func TestCache(t *testing.T) {
+	cache := &mapCache{m: make(map[string]string)}
+	opts := &Options{Cache: cache}
+	for i := 0; i < 3; i++ {
+		if actual := New("# hello", opts).Render(); actual != "<h1 id=\"hello\">hello</h1>" {
+			t.Errorf("Cache: got %q", actual)
+		}
+	}
+	New("# hello", &Options{Cache: cache, Smartypants: true}).Render()
+	if cache.hits != 2 || cache.sets != 2 {
+		t.Errorf("Cache: got %d hits and %d sets, expected 2 and 2", cache.hits, cache.sets)
+	}
+	// custom render functions bypass the cache
+	m := New("# hello", opts)
+	m.AddRenderFn(NodeHeading, func(Node) string { return "heading" })
+	if actual := m.Render(); actual != "heading" {
+		t.Errorf("Cache: got %q, expected the custom render function to be used", actual)
+	}
+	// links and replacements are hashed by their content
+	links := func(href string) *Options {
+		return &Options{Cache: cache, Links: Parse("[a]: "+href, nil).Links,
+			Replacements: []Replacement{{Regexp: regexp.MustCompile("b+"), New: "c"}}}
+	}
+	for _, c := range []struct{ href, expected string }{{"/x", "<p><a href=\"/x\">a</a> c</p>"}, {"/x", "<p><a href=\"/x\">a</a> c</p>"}, {"/y", "<p><a href=\"/y\">a</a> c</p>"}} {
+		if actual := New("[a] bb", links(c.href)).Render(); actual != c.expected {
+			t.Errorf("Cache: got %q, expected %q", actual, c.expected)
+		}
+	}
+	if cache.hits != 3 || cache.sets != 4 {
+		t.Errorf("Cache: got %d hits and %d sets, expected 3 and 4", cache.hits, cache.sets)
+	}
+	// inputs that differ only in tabs have different source positions
+	opts = &Options{Cache: cache, SourcePos: true}
+	for _, c := range []struct{ input, expected string }{
+		{"- \tfoo", "<ul data-sourcepos=\"1:1-1:6\">\n<li data-sourcepos=\"1:1-1:6\">foo</li>\n</ul>"},
+		{"-    foo", "<ul data-sourcepos=\"1:1-1:8\">\n<li data-sourcepos=\"1:1-1:8\">foo</li>\n</ul>"},
+		{"- a\n \t- b", "<ul data-sourcepos=\"1:1-2:5\">\n<li data-sourcepos=\"1:1-2:5\">a\n<ul data-sourcepos=\"2:3-2:5\">\n<li data-sourcepos=\"2:3-2:5\">b</li>\n</ul></li>\n</ul>"},
+		{"- a\n    - b", "<ul data-sourcepos=\"1:1-2:7\">\n<li data-sourcepos=\"1:1-2:7\">a\n<ul data-sourcepos=\"2:5-2:7\">\n<li data-sourcepos=\"2:5-2:7\">b</li>\n</ul></li>\n</ul>"},
+	} {
+		if actual := New(c.input, opts).Render(); actual != c.expected {
+			t.Errorf("Cache: got %q, expected %q", actual, c.expected)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	var calls []Metrics
+	opts := &Options{Metrics: func(m Metrics) { calls = append(calls, m) }}
+	m := New("# hello\n\n- foo *bar*\n- baz", opts)
+	m.Render()
+	m.Render()
+	if len(calls) != 2 {
+		t.Fatalf("Metrics: got %d calls, expected 2", len(calls))
+	}
+	// heading, text, list, 2 items, text, emphasis, text, text
+	if calls[0].Nodes != 9 {
+		t.Errorf("Metrics nodes: got %d, expected 9", calls[0].Nodes)
+	}
+	if calls[0].Lex <= 0 || calls[0].Parse <= 0 || calls[0].Render <= 0 {
+		t.Errorf("Metrics: got %+v, expected non-zero durations", calls[0])
+	}
+	if calls[0].Lex != calls[1].Lex || calls[0].Parse != calls[1].Parse {
+		t.Errorf("Metrics: got %+v and %+v, expected the same parse timings", calls[0], calls[1])
+	}
+}
+
+func TestTrace(t *testing.T) {
+	var b bytes.Buffer
+	New("# foo\n\n> *bar*", &Options{Trace: &b, DisabledBlocks: map[NodeType]bool{NodeHeading: true}}).Render()
+	for _, line := range []string{
+		"lex 1:1 Heading \"# foo\\n\"\n",
+		"parse 1:1 block Heading is disabled, literal text\n",
+		"lex 3:3 Text \"*bar*\"\n",
+		"inline +0 Italic \"*bar*\"\n",
+		"parse 3:1 block BlockQuote\n",
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("Trace: got\n%s\nexpected to contain\n\t%q", b.String(), line)
+		}
+	}
+}
+
+func TestNodeNames(t *testing.T) {
+	for typ := NodeText; typ <= NodeShortcode; typ++ {
+		if NodeNames[typ] == "" {
+			t.Errorf("NodeNames: missing name for node %d", int(typ))
+		}
+	}
+	if name := NodeName(NodeRefLink); name != "RefLink" {
+		t.Errorf("NodeName: got %q, expected %q", name, "RefLink")
+	}
+	if name := NodeName(NodeType(-1)); name != "Node(-1)" {
+		t.Errorf("NodeName: got %q, expected %q", name, "Node(-1)")
+	}
+}
+
+func TestIntrawordUnderscore(t *testing.T) {
+	cases := []struct {
+		input, gfm, plain string
+	}{
+		{"snake_case_name", "<p>snake_case_name</p>", "<p>snake<em>case</em>name</p>"},
+		{"my__dunder__var", "<p>my__dunder__var</p>", "<p>my<strong>dunder</strong>var</p>"},
+		{"_foo_bar_ baz", "<p><em>foo_bar</em> baz</p>", "<p><em>foo</em>bar_ baz</p>"},
+		{"__foo__bar__", "<p><strong>foo__bar</strong></p>", "<p><strong>foo</strong>bar__</p>"},
+		{"foo*bar*baz", "<p>foo<em>bar</em>baz</p>", "<p>foo<em>bar</em>baz</p>"},
+		{"_foo_, __bar__.", "<p><em>foo</em>, <strong>bar</strong>.</p>", "<p><em>foo</em>, <strong>bar</strong>.</p>"},
+	}
+	plain := DefaultOptions()
+	plain.Gfm = false
+	for _, c := range cases {
+		if actual := Render(c.input); actual != c.gfm {
+			t.Errorf("%s(gfm): got\n\t%+v\nexpected\n\t%+v", c.input, actual, c.gfm)
+		}
+		if actual := New(c.input, plain).Render(); actual != c.plain {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", c.input, actual, c.plain)
+		}
+	}
+}
+
+func TestListStart(t *testing.T) {
+	cases := map[string]string{
+		"3. a\n4. b":                     "<ol start=\"3\">\n<li>a</li>\n<li>b</li>\n</ol>",
+		"1. a\n\n```\ncode\n```\n\n2. b": "<ol>\n<li>a</li>\n</ol>\n<pre><code>\ncode\n</code></pre>\n<ol start=\"2\">\n<li>b</li>\n</ol>",
+		"1. a\n2. b\n\npara\n\n3. c":     "<ol>\n<li>a</li>\n<li>b</li>\n</ol>\n<p>para</p>\n<ol start=\"3\">\n<li>c</li>\n</ol>",
+		"0. zero":                        "<ol start=\"0\">\n<li>zero</li>\n</ol>",
+	}
+	for input, expected := range cases {
+		if actual := Render(input); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+	if actual := Parse("7. a\n8. b", nil).Markdown(); actual != "7. a\n8. b" {
+		t.Errorf("Markdown: got %q, expected the list numbers to be kept", actual)
+	}
+	// a list without a start number starts at 1
+	list := &ListNode{NodeType: NodeList, Ordered: true, Items: []*ListItemNode{NewListItem(NewText("a"))}}
+	if actual := list.Render(); actual != "<ol>\n<li>a</li>\n</ol>" {
+		t.Errorf("Render: got %q, expected no start attribute", actual)
+	}
+	if actual := Markdown(list); actual != "1. a" {
+		t.Errorf("Markdown: got %q, expected the list to start at 1", actual)
+	}
+	if actual := Parse("0. a\n1. b", nil).Markdown(); actual != "0. a\n1. b" {
+		t.Errorf("Markdown: got %q, expected the list to start at 0", actual)
+	}
+	if doc, err := FromHTML("<ol start=\"0\"><li>a</li></ol>"); err != nil || doc.Markdown() != "0. a" {
+		t.Errorf("FromHTML: got %q(%v), expected the list to start at 0", doc.Markdown(), err)
+	}
+}
+
+func TestRawDelims(t *testing.T) {
+	opts := &Options{RawDelims: map[string]string{"{% raw %}": "{% endraw %}", "```raw": "```"}, SourcePos: true}
+	cases := map[string]string{
+		"{% raw %}\n{{ *name* }} & <b>\n{% endraw %}\n\n*foo*": "{{ *name* }} & <b>\n<p data-sourcepos=\"5:1-5:5\"><em>foo</em></p>",
+		"{% raw %}{% if a_b %}{% endraw %}":                    "{% if a_b %}",
+		"# title\n\n```raw\n<div>*x*</div>\n```":               "<h1 data-sourcepos=\"1:1-1:7\" id=\"title\">title</h1>\n<div>*x*</div>",
+		"{% raw %} unclosed *foo*":                             "<p data-sourcepos=\"1:1-1:24\">{% raw %} unclosed <em>foo</em></p>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, opts).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+}
+
+func TestShortcodes(t *testing.T) {
+	cases := map[string]string{
+		"{{< youtube abc >}}":                                    "<iframe src=\"https://youtube.com/embed/abc\"></iframe>",
+		"{{< figure src=\"a b.png\" alt=x />}}\n\n*foo*":         "<figure><img src=\"a b.png\" alt=\"x\"></figure>\n<p><em>foo</em></p>",
+		"{{% note type=\"warn\" %}}\n**bold**\n{{% /note %}}":    "<aside class=\"warn\"><p><strong>bold</strong></p></aside>",
+		"{{< code >}}\n*not* <b>\n{{< /code >}}":                 "<pre>\n*not* &lt;b&gt;\n</pre>",
+		"see {{< youtube xyz >}} here":                           "<p>see <iframe src=\"https://youtube.com/embed/xyz\"></iframe> here</p>",
+		"a {{% note %}}*b*{{% /note %}} c":                       "<p>a <aside class=\"\"><em>b</em></aside> c</p>",
+		"{{< unknown x >}}":                                      "{{&lt; unknown x &gt;}}",
+		"{{< youtube abc %}}":                                    "<p>{{&lt; youtube abc %}}</p>",
+		"- {{< youtube abc >}}":                                  "<ul>\n<li><iframe src=\"https://youtube.com/embed/abc\"></iframe></li>\n</ul>",
+		"{{< code >}}a{{< /code >}} tail\n{{< youtube abc >}}\n": "<p><pre>a</pre> tail</p>\n<iframe src=\"https://youtube.com/embed/abc\"></iframe>",
+	}
+	for input, expected := range cases {
+		m := New(input, &Options{Shortcodes: true})
+		m.AddShortcode("youtube", false, func(args map[string]string, inner string) string {
+			return fmt.Sprintf("<iframe src=\"https://youtube.com/embed/%s\"></iframe>", args["0"])
+		})
+		m.AddShortcode("figure", false, func(args map[string]string, inner string) string {
+			return fmt.Sprintf("<figure><img src=\"%s\" alt=\"%s\"></figure>", args["src"], args["alt"])
+		})
+		m.AddShortcode("note", true, func(args map[string]string, inner string) string {
+			return fmt.Sprintf("<aside class=\"%s\">%s</aside>", args["type"], inner)
+		})
+		m.AddShortcode("code", false, func(args map[string]string, inner string) string {
+			return "<pre>" + html.EscapeString(inner) + "</pre>"
+		})
+		if actual := m.Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+	// disabled
+	if actual := Render("{{< youtube abc >}}"); actual != "<p>{{&lt; youtube abc &gt;}}</p>" {
+		t.Errorf("disabled: got\n\t%+v", actual)
+	}
+	// markdown
+	doc := Parse("{{< youtube abc >}}\n\n{{% note type=\"warn\" %}}\n*x*\n{{% /note %}}", &Options{Shortcodes: true})
+	sc := doc.Nodes[1].(*ShortcodeNode)
+	if sc.Name != "note" || sc.Args["type"] != "warn" || sc.Inner != "\n*x*\n" {
+		t.Errorf("shortcode: got\n\t%+v", sc)
+	}
+	if actual, expected := doc.Markdown(), "{{< youtube abc >}}\n\n{{% note type=\"warn\" %}}\n*x*\n{{% /note %}}"; actual != expected {
+		t.Errorf("markdown: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+	if actual, expected := Markdown(NewShortcode("x", map[string]string{"0": "a", "k": "v"}, "")), "{{< x \"a\" k=\"v\" />}}"; actual != expected {
+		t.Errorf("markdown: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+}
+
+func TestTemplateTags(t *testing.T) {
+	cases := map[string]string{
+		"Hello {{ user.first_name }} & *you*":                                 "<p>Hello {{ user.first_name }} &amp; <em>you</em></p>",
+		"{% if page.tags %}\n- {{ page.tags | join: \", \" }}\n\n{% endif %}": "{% if page.tags %}\n<ul>\n<li>{{ page.tags | join: \", \" }}</li>\n</ul>\n{% endif %}",
+		"{% include note.html text=\"<b>*x*</b>\" %} after":                   "<p>{% include note.html text=\"<b>*x*</b>\" %} after</p>",
+		"**{{ site.title }}**":                                                "<p><strong>{{ site.title }}</strong></p>",
+		"{{ a_b_c }}_d_":                                                      "<p>{{ a_b_c }}<em>d</em></p>",
+		"`{{ code }}`":                                                        "<p><code>{{ code }}</code></p>",
+		"{ not a tag }":                                                       "<p>{ not a tag }</p>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, &Options{TemplateTags: true}).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+	// disabled
+	if actual := Render("{% *x* %}"); actual != "<p>{% <em>x</em> %}</p>" {
+		t.Errorf("disabled: got\n\t%+v", actual)
+	}
+	// the disallowed html tags are escaped with the TagFilter option
+	cases = map[string]string{
+		"a {{ \"<script>x</script>\" }}":         "<p>a {{ \"&lt;script>x&lt;/script>\" }}</p>",
+		"{% include x.html text=\"<iframe>\" %}": "{% include x.html text=\"&lt;iframe>\" %}",
+		"{{ \"<b>x</b>\" }}":                     "<p>{{ \"<b>x</b>\" }}</p>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, &Options{TemplateTags: true, TagFilter: true}).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+}
+
+func TestAssetFn(t *testing.T) {
+	var paths []string
+	opts := &Options{AssetFn: func(path string) string {
+		paths = append(paths, path)
+		return "/static/" + path + "?v=1&h=2"
+	}}
+	input := "![a](img/a%20b.png?x=1) ![b](./img/../b.png \"B\") ![c](https://example.com/c.png) ![d][d]\n\n[d]: d.svg"
+	expected := "<p><img src=\"/static/img/a b.png?v=1&amp;h=2\" alt=\"a\"> <img src=\"/static/b.png?v=1&amp;h=2\" alt=\"b\" title=\"B\"> " +
+		"<img src=\"https://example.com/c.png\" alt=\"c\"> <img src=\"/static/d.svg?v=1&amp;h=2\" alt=\"d\"></p>\n"
+	if actual := Parse(input, opts).Render(); actual != Parse(input, nil).Render() {
+		t.Errorf("Document.Render: got\n\t%+v", actual)
+	}
+	if actual := FromDocument(Parse(input, opts), opts).Render(); actual != expected {
+		t.Errorf("AssetFn: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+	if actual, expected := strings.Join(paths, ","), "img/a b.png,b.png,d.svg"; actual != expected {
+		t.Errorf("AssetFn: got paths %q, expected %q", actual, expected)
+	}
+}
+
+func TestVoidStyle(t *testing.T) {
+	input := "a  \nb ![img](a.png) :smile:\n\n***\n\n- [x] done\n\n<br>"
+	cases := map[VoidStyle]string{
+		VoidHTML:       "<p>a<br>b <img src=\"a.png\" alt=\"img\"> <img class=\"emoji\" src=\"s.png\" alt=\":smile:\"></p>\n<hr>\n<ul>\n<li><input type=\"checkbox\" checked>done</li>\n</ul>\n<p><br></p>",
+		VoidSlash:      "<p>a<br/>b <img src=\"a.png\" alt=\"img\"/> <img class=\"emoji\" src=\"s.png\" alt=\":smile:\"/></p>\n<hr/>\n<ul>\n<li><input type=\"checkbox\" checked/>done</li>\n</ul>\n<p><br></p>",
+		VoidSpaceSlash: "<p>a<br />b <img src=\"a.png\" alt=\"img\" /> <img class=\"emoji\" src=\"s.png\" alt=\":smile:\" /></p>\n<hr />\n<ul>\n<li><input type=\"checkbox\" checked />done</li>\n</ul>\n<p><br></p>",
+		VoidXHTML:      "<p>a<br />b <img src=\"a.png\" alt=\"img\" /> <img class=\"emoji\" src=\"s.png\" alt=\":smile:\" /></p>\n<hr />\n<ul>\n<li><input type=\"checkbox\" checked=\"checked\" />done</li>\n</ul>\n<p><br></p>",
+	}
+	for style, expected := range cases {
+		opts := &Options{VoidStyle: style, Emoji: map[string]string{"smile": "s.png"}, Classes: map[NodeType]string{NodeHr: "sep"}}
+		expected = strings.Replace(expected, "<hr", "<hr class=\"sep\"", 1)
+		if actual := New(input, opts).Render(); actual != expected {
+			t.Errorf("VoidStyle(%d): got\n\t%+v\nexpected\n\t%+v", style, actual, expected)
+		}
+	}
+	if err := (&Options{VoidStyle: 4}).Validate(); err == nil {
+		t.Errorf("Validate: expected an error for unknown VoidStyle")
+	}
+}
+
+func TestLiteralQuotes(t *testing.T) {
+	cases := map[string]string{
+		`"It's" <b title="&quot;x&quot;">b</b>`:      `<p>"It's" <b title="&quot;x&quot;">b</b></p>`,
+		`[it's](/a "say 'hi'") ![a "b"](c.png)`:      `<p><a href="/a" title="say &#39;hi&#39;">it's</a> <img src="c.png" alt="a &quot;b&quot;"></p>`,
+		"`x = \"a\" & 'b'`\n\n```\nsay(\"hi\")\n```": "<p><code>x = \"a\" &amp; 'b'</code></p>\n<pre><code>\nsay(\"hi\")\n</code></pre>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, &Options{LiteralQuotes: true}).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+	if actual, expected := Render(`"It's"`), "<p>&quot;It&#39;s&quot;</p>"; actual != expected {
+		t.Errorf("default: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+}
+
+func TestTagFilter(t *testing.T) {
+	cases := map[string]string{
+		"<script>alert(1)</script>":         "&lt;script>alert(1)&lt;/script>",
+		"<div>\n<IFRAME src=\"x\">\n</div>": "<div>\n&lt;IFRAME src=\"x\">\n</div>",
+		"foo <style>bar":                    "<p>foo &lt;style>bar</p>",
+		"foo <strong>bar</strong>":          "<p>foo <strong>bar</strong></p>",
+		"foo <scripts>":                     "<p>foo <scripts></p>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, &Options{TagFilter: true}).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+}
+
+func TestRuby(t *testing.T) {
+	cases := map[string]string{
+		"{漢字|かんじ}を読む":    "<p><ruby>漢字<rt>かんじ</rt></ruby>を読む</p>",
+		"[漢字]{かんじ}":      "<p><ruby>漢字<rt>かんじ</rt></ruby></p>",
+		"{**東京**|とうきょう}": "<p><ruby><strong>東京</strong><rt>とうきょう</rt></ruby></p>",
+		"{a} and [b](c)": "<p>{a} and <a href=\"c\">b</a></p>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, &Options{Ruby: true}).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+	// ruby annotations are rendered as is when the option is off
+	if actual, expected := Render("{漢字|かんじ}"), "<p>{漢字|かんじ}</p>"; actual != expected {
+		t.Errorf("Ruby: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+}
+
+func TestEmoji(t *testing.T) {
+	opts := &Options{Emoji: map[string]string{"party_parrot": "/img/parrot.gif"}}
+	cases := map[string]string{
+		"hi :party_parrot:!": "<p>hi <img class=\"emoji\" src=\"/img/parrot.gif\" alt=\":party_parrot:\">!</p>",
+		"hi :unknown:":       "<p>hi :unknown:</p>",
+		"at 12:30:45":        "<p>at 12:30:45</p>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, opts).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+}
+
+func TestJoinCJKLines(t *testing.T) {
+	cases := map[string]string{
+		"日本語の\n文章です。\n次の行": "<p>日本語の文章です。次の行</p>",
+		"日本語\nEnglish\nです": "<p>日本語\nEnglish\nです</p>",
+		"foo\nbar":         "<p>foo\nbar</p>",
+		"日本語  \n改行":        "<p>日本語<br>改行</p>",
+	}
+	for input, expected := range cases {
+		if actual := New(input, &Options{JoinCJKLines: true}).Render(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+}
+
+func TestMultilineTables(t *testing.T) {
+	input := "| Name | Items |\n|------|-------|\n| foo  | - a   | \\\n|      | - b   |\n| bar  | baz   |"
+	expected := "<table>\n<thead>\n<tr>\n<th>Name</th>\n<th>Items</th>\n</tr>\n</thead>\n<tbody>\n" +
+		"<tr>\n<td>foo</td>\n<td><ul>\n<li>a</li>\n<li>b</li>\n</ul></td>\n</tr>\n" +
+		"<tr>\n<td>bar</td>\n<td>baz</td>\n</tr>\n</tbody>\n</table>"
+	if actual := New(input, &Options{MultilineTables: true}).Render(); actual != expected {
+		t.Errorf("MultilineTables: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+}
+
 type CommonMarkSpec struct {
 	name     string
 	input    string
@@ -1436,7 +2937,9 @@ bar</p>`},
 	{"458", `[link](/url 'title "and" title')`, `<p><a href="/url" title="title &quot;and&quot; title">link</a></p>`},
 	{"460", "[link] (/uri)", "<p>[link] (/uri)</p>"},
 	{"461", "[link [foo [bar]]](/uri)", `<p><a href="/uri">link [foo [bar]]</a></p>`},
+	{"462", "[link] bar](/uri)", "<p>[link] bar](/uri)</p>"},
 	{"463", "[link [bar](/uri)", `<p>[link <a href="/uri">bar</a></p>`},
+	{"464", `[link \[bar](/uri)`, `<p><a href="/uri">link [bar</a></p>`},
 	{"471", "[foo *bar](baz*)", `<p><a href="baz*">foo *bar</a></p>`},
 	{"472", "*foo [bar* baz]", "<p><em>foo [bar</em> baz]</p>"},
 	{"476", `
@@ -0,0 +1,439 @@
+package mark
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Markdown returns the markdown representation of the given nodes.
+// block nodes are separated by a blank line.
+func Markdown(nodes ...Node) string {
+	var blocks []string
+	for _, node := range nodes {
+		if s := mdBlock(node); s != "" {
+			blocks = append(blocks, s)
+		}
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+// Markdown returns the markdown representation of the document.
+func (d *DocumentNode) Markdown() string {
+	return Markdown(d.Nodes...)
+}
+
+// mdBlock returns the markdown representation of a block node.
+// inline nodes are returned as is.
+func mdBlock(node Node) string {
+	switch n := node.(type) {
+	case *ParagraphNode:
+		return mdParagraph(mdInline(n.Nodes))
+	case *HeadingNode:
+		return mdHeading(n)
+	case *HrNode:
+		return "***"
+	case *CodeNode:
+		return mdCode(n)
+	case *DefLinkNode:
+		return fmt.Sprintf("[%s]: %s", n.Name, mdLinkDest(n.Href, n.Title))
+	case *ListNode:
+		return mdList(n)
+	case *TableNode:
+		return mdTable(n)
+	case *BlockQuoteNode:
+		s := Markdown(n.Nodes...)
+		if n.Alert != "" {
+			s = "[!" + strings.ToUpper(n.Alert) + "]\n" + s
+		}
+		return mdPrefix(s, "> ", "> ")
+	case *TabsNode:
+		var tabs []string
+		for _, tab := range n.Tabs {
+			s := "=== \"" + html.UnescapeString(tab.Title) + "\""
+			if len(tab.Nodes) > 0 {
+				s += "\n\n" + mdPrefix(Markdown(tab.Nodes...), "    ", "    ")
+			}
+			tabs = append(tabs, s)
+		}
+		return strings.Join(tabs, "\n\n")
+	case *DetailsNode:
+		return ":::details " + mdInline(n.Summary) + "\n" + Markdown(n.Nodes...) + "\n:::"
+	case *DivNode:
+		return "::: " + mdDivAttrs(n) + "\n" + Markdown(n.Nodes...) + "\n:::"
+	case *HTMLNode:
+		if n.Open != "" {
+			return n.Open + "\n\n" + Markdown(n.Nodes...) + "\n\n" + n.Close
+		}
+		return n.Src
+	case *RawNode:
+		return n.Text
+	case *ShortcodeNode:
+		return mdShortcode(n)
+	case *DocumentNode:
+		return Markdown(n.Nodes...)
+	case *ListItemNode:
+		s, _ := mdListItem(n)
+		return s
+	case *RowNode:
+		return mdRow(n)
+	case *CellNode:
+		return mdInline(n.Nodes)
+	case *TabNode:
+		return Markdown(n.Nodes...)
+	default:
+		if isInline(node) {
+			return mdInline([]Node{node})
+		}
+		return ""
+	}
+}
+
+// mdShortcode returns the source of shortcode, or builds it for shortcodes
+// that were created with NewShortcode.
+func mdShortcode(n *ShortcodeNode) string {
+	if n.Src != "" {
+		return n.Src
+	}
+	var args []string
+	for i := 0; n.Args[strconv.Itoa(i)] != ""; i++ {
+		args = append(args, strconv.Quote(n.Args[strconv.Itoa(i)]))
+	}
+	var named []string
+	for key, value := range n.Args {
+		if _, err := strconv.Atoi(key); err != nil {
+			named = append(named, key+"="+strconv.Quote(value))
+		}
+	}
+	sort.Strings(named)
+	tag := strings.Join(append([]string{n.Name}, append(args, named...)...), " ")
+	if n.Inner == "" {
+		return "{{< " + tag + " />}}"
+	}
+	return "{{< " + tag + " >}}" + n.Inner + "{{< /" + n.Name + " >}}"
+}
+
+// mdDivAttrs returns the attributes of the opening fence of div. a single
+// class is written as is, e.g: "::: note".
+func mdDivAttrs(n *DivNode) string {
+	if n.ID == "" && len(n.Classes) == 1 && len(n.Attrs) == 0 {
+		return n.Classes[0]
+	}
+	var attrs []string
+	if n.ID != "" {
+		attrs = append(attrs, "#"+n.ID)
+	}
+	for _, class := range n.Classes {
+		attrs = append(attrs, "."+class)
+	}
+	var named []string
+	for key, value := range n.Attrs {
+		named = append(named, key+"=\""+value+"\"")
+	}
+	sort.Strings(named)
+	return "{" + strings.Join(append(attrs, named...), " ") + "}"
+}
+
+// mdInline returns the markdown representation of inline nodes.
+func mdInline(nodes []Node) (s string) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *TextNode:
+			s += mdText(n.Text)
+		case *LiteralNode:
+			s += html.UnescapeString(n.Text)
+		case *BrNode:
+			s += "  \n"
+		case *EmphasisNode:
+			switch n.Style {
+			case itemStrong, itemItalic:
+				delim := n.Delim
+				if delim == "" && n.Style == itemStrong {
+					delim = "**"
+				} else if delim == "" {
+					delim = "*"
+				}
+				s += delim + mdInline(n.Nodes) + delim
+			case itemStrike:
+				s += "~~" + mdInline(n.Nodes) + "~~"
+			case itemCode:
+				s += mdCodeSpan(html.UnescapeString(plainText(n.Nodes)))
+			}
+		case *LinkNode:
+			s += "[" + mdInline(n.Nodes) + "](" + mdLinkDest(n.Href, n.Title) + ")"
+		case *ImageNode:
+			s += "![" + mdEscape(html.UnescapeString(n.Alt)) + "](" + mdLinkDest(n.Src, n.Title) + ")"
+		case *RefNode:
+			s += n.Raw
+		case *EmojiNode:
+			s += ":" + n.Name + ":"
+		case *RubyNode:
+			s += "{" + mdInline(n.Nodes) + "|" + html.UnescapeString(n.Text) + "}"
+		case *CheckboxNode:
+			if n.Checked {
+				s += "[x] "
+			} else {
+				s += "[ ] "
+			}
+		case *HTMLNode:
+			s += n.Src
+		case *CustomInlineNode:
+			s += n.Match[0]
+		case *RawNode:
+			s += n.Text
+		case *ShortcodeNode:
+			s += mdShortcode(n)
+		}
+	}
+	return
+}
+
+// Escape characters that have a special meaning in inline context.
+var mdEscaper = strings.NewReplacer(
+	"\\", "\\\\", "`", "\\`", "*", "\\*", "_", "\\_",
+	"[", "\\[", "]", "\\]", "~", "\\~", "|", "\\|",
+	"{", "\\{", "}", "\\}",
+)
+
+// reMdHTML matches the start of a tag or an entity, that is parsed as
+// html instead of text.
+var reMdHTML = regexp.MustCompile(`<[a-zA-Z/!?]|&#?[a-zA-Z0-9]+;`)
+
+// mdEscape escapes text, including the characters that start raw html.
+func mdEscape(s string) string {
+	return reMdHTML.ReplaceAllStringFunc(mdEscaper.Replace(s), func(m string) string {
+		if m[0] == '&' {
+			return "&amp;" + m[1:]
+		}
+		return "&lt;" + m[1:]
+	})
+}
+
+// mdText returns the markdown of the html-escaped text of a text node.
+// the text is escaped by the parser, so the tags it holds are its inline
+// raw html, that is kept as is.
+func mdText(text string) string {
+	text, tags := maskTags(text)
+	return unmaskTags(mdEscape(html.UnescapeString(text)), tags)
+}
+
+// reMdBlockStart matches the start of a line that could be confused with
+// the start of another block, e.g: a heading or a list item.
+var reMdBlockStart = regexp.MustCompile(`(?m)^ *(?:[#>=+-]|\d{1,9}\.)`)
+
+// mdParagraph escapes the start of the lines of a paragraph that could be
+// confused with the start of another block, e.g: "1. x" becomes "1\. x".
+func mdParagraph(s string) string {
+	return reMdBlockStart.ReplaceAllStringFunc(s, func(m string) string {
+		return m[:len(m)-1] + "\\" + m[len(m)-1:]
+	})
+}
+
+// mdHeading returns an ATX heading, or a setext heading if it was
+// underlined in the source.
+func mdHeading(n *HeadingNode) string {
+	s := mdInline(n.Nodes)
+	if !n.Setext || n.Level > 2 || s == "" {
+		return strings.Repeat("#", n.Level) + " " + s
+	}
+	width := utf8.RuneCountInString(s[strings.LastIndexByte(s, '\n')+1:])
+	if width < 3 {
+		width = 3
+	}
+	underline := "="
+	if n.Level == 2 {
+		underline = "-"
+	}
+	return s + "\n" + strings.Repeat(underline, width)
+}
+
+// mdLinkDest returns the destination part of link or image.
+func mdLinkDest(href, title string) string {
+	href = html.UnescapeString(href)
+	if href == "" || strings.ContainsAny(href, " ()") {
+		href = "<" + href + ">"
+	}
+	if title != "" {
+		href += " \"" + strings.Replace(html.UnescapeString(title), "\"", "\\\"", -1) + "\""
+	}
+	return href
+}
+
+// mdCodeSpan wraps text with backticks. the delimiter is longer than the
+// longest sequence of backticks in the text, and it's separated from the
+// text with spaces if the text starts or ends with a backtick.
+func mdCodeSpan(text string) string {
+	var run, longest int
+	for i := 0; i < len(text); i++ {
+		if text[i] != '`' {
+			run = 0
+		} else if run++; run > longest {
+			longest = run
+		}
+	}
+	delim := strings.Repeat("`", longest+1)
+	if strings.HasPrefix(text, "`") || strings.HasSuffix(text, "`") {
+		return delim + " " + text + " " + delim
+	}
+	return delim + text + delim
+}
+
+// mdCode returns a fenced code block, or an indented one if it was
+// indented in the source. the fence is longer than any sequence of its
+// character in the code.
+func mdCode(n *CodeNode) string {
+	text := html.UnescapeString(n.Text)
+	if code := strings.Trim(text, "\n"); n.Indented && n.Lang == "" && code != "" {
+		return mdPrefix(code, "    ", "    ")
+	}
+	fence := n.Fence
+	if fence == "" {
+		fence = "```"
+	}
+	for strings.Contains(text, fence) {
+		fence += fence[:1]
+	}
+	if !strings.HasPrefix(text, "\n") {
+		text = "\n" + text
+	}
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return fence + n.Lang + text + fence
+}
+
+// mdList returns the markdown representation of list. items in loose
+// lists(contains paragraphs) are separated by a blank line.
+func mdList(n *ListNode) string {
+	sep, items := "\n", make([]string, len(n.Items))
+	for i, item := range n.Items {
+		marker := "- "
+		if n.Ordered {
+			marker = strconv.Itoa(n.start()+i) + ". "
+		} else if n.Bullet != "" {
+			marker = n.Bullet + " "
+		}
+		s, loose := mdListItem(item)
+		if loose {
+			sep = "\n\n"
+		}
+		items[i] = mdPrefix(s, marker, strings.Repeat(" ", len(marker)))
+	}
+	return strings.Join(items, sep)
+}
+
+// mdListItem returns the content of list item, without its marker. loose
+// reports whether the item contains paragraphs, and then its blocks are
+// separated by a blank line.
+func mdListItem(item *ListItemNode) (s string, loose bool) {
+	for _, node := range item.Nodes {
+		if _, ok := node.(*ParagraphNode); ok {
+			loose = true
+		}
+	}
+	sep := "\n"
+	if loose {
+		sep = "\n\n"
+	}
+	var blocks []string
+	var inline []Node
+	for _, node := range item.Nodes {
+		if isInline(node) {
+			inline = append(inline, node)
+			continue
+		}
+		if len(inline) > 0 {
+			blocks, inline = append(blocks, mdParagraph(strings.TrimRight(mdInline(inline), "\n"))), nil
+		}
+		// indented code can't interrupt a paragraph
+		if c, ok := node.(*CodeNode); ok && c.Indented && len(blocks) > 0 && !loose {
+			blocks = append(blocks, "")
+		}
+		blocks = append(blocks, mdBlock(node))
+	}
+	if len(inline) > 0 {
+		blocks = append(blocks, mdParagraph(mdInline(inline)))
+	}
+	return strings.Join(blocks, sep), loose
+}
+
+// mdTable returns the markdown representation of table.
+func mdTable(n *TableNode) string {
+	var rows []string
+	for i, row := range n.Rows {
+		rows = append(rows, mdRow(row))
+		if i == 0 {
+			var aligns []string
+			for _, cell := range row.Cells {
+				switch cell.Align() {
+				case Left:
+					aligns = append(aligns, ":---")
+				case Right:
+					aligns = append(aligns, "---:")
+				case Center:
+					aligns = append(aligns, ":---:")
+				default:
+					aligns = append(aligns, "---")
+				}
+			}
+			rows = append(rows, "| "+strings.Join(aligns, " | ")+" |")
+		}
+	}
+	return strings.Join(rows, "\n")
+}
+
+// mdRow returns the markdown representation of table row.
+func mdRow(row *RowNode) string {
+	var cells []string
+	for _, cell := range row.Cells {
+		cells = append(cells, mdCell(mdInline(cell.Nodes)))
+	}
+	return "| " + strings.Join(cells, " | ") + " |"
+}
+
+// mdCell escapes the pipes of cell content that aren't escaped yet(e.g:
+// in code spans), so they don't split the cell. the pipes are unescaped
+// when the row is split, before the inline content is parsed.
+func mdCell(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '|' {
+			escapes := 0
+			for j := i - 1; j >= 0 && s[j] == '\\'; j-- {
+				escapes++
+			}
+			if escapes%2 == 0 {
+				b.WriteByte('\\')
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// mdPrefix prefixes the first line of s with first, and the rest of its
+// non-empty lines with rest.
+func mdPrefix(s, first, rest string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if i == 0 {
+			lines[i] = first + line
+		} else if line != "" || strings.TrimSpace(rest) != "" {
+			lines[i] = rest + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// isInline tests if the given node is an inline node.
+func isInline(n Node) bool {
+	switch n.Type() {
+	case NodeText, NodeEmphasis, NodeBr, NodeImage, NodeRefImage, NodeLink, NodeRefLink, NodeCheckbox, NodeRuby, NodeEmoji, NodeCustomInline, NodeLiteral:
+		return true
+	}
+	return false
+}
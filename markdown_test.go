@@ -0,0 +1,105 @@
+package mark
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarkdown(t *testing.T) {
+	cases := map[string]string{
+		"foo _bar_ __baz__ ~~qux~~":           "foo _bar_ __baz__ ~~qux~~",
+		"*foo* **bar**":                       "*foo* **bar**",
+		"Hello\n===":                          "Hello\n=====",
+		"Sub\n---":                            "Sub\n---",
+		"### h3":                              "### h3",
+		"\\*foo\\* a_b":                       "\\*foo\\* a\\_b",
+		"foo  \nbar":                          "foo  \nbar",
+		"a `b` and `c`":                       "a `b` and `c`",
+		"[text](link \"title\")":              "[text](link \"title\")",
+		"![alt](src)":                         "![alt](src)",
+		"<http://foo.com>":                    "[http://foo.com](http://foo.com)",
+		"foo\n***\nbar":                       "foo\n\n***\n\nbar",
+		"    code":                            "    code",
+		"```go\nx := 1\n```":                  "```go\nx := 1\n```",
+		"~~~~\nx\n~~~~":                       "~~~~\nx\n~~~~",
+		"> foo\n> bar":                        "> foo\n> bar",
+		"- foo\n- bar":                        "- foo\n- bar",
+		"* foo\n* bar":                        "* foo\n* bar",
+		"- a\n\n      code":                   "- a\n\n      code",
+		"1. one\n2. two":                      "1. one\n2. two",
+		"- [ ] foo\n- [x] bar":                "- [ ] foo\n- [x] bar",
+		"- foo\n\n- bar":                      "- foo\n\n- bar",
+		"a | b\n--|:-:\n1 | 2":                "| a | b |\n| --- | :---: |\n| 1 | 2 |",
+		"[foo][bar]\n\n[bar]: http://bar":     "[foo][bar]\n\n[bar]: http://bar",
+		"\\# not a heading":                   "\\# not a heading",
+		"a & b < c":                           "a & b < c",
+		"&lt;img src=x&gt; &amp;lt;":          "&lt;img src=x> &amp;lt;",
+		"text with &amp; and &copy; <b>x</b>": "text with & and © <b>x</b>",
+		"a <!-- c --> &lt;b>":                 "a <!-- c --> &lt;b>",
+		"1\\. x\n\\- y":                       "1\\. x\n\\- y",
+		"- 1\\. x\n- \\# y":                   "- 1\\. x\n- \\# y",
+		"* a\n\n  b":                          "* a\n\n  b",
+		"a | b\n--|--\n`x\\|y` | c":           "| a | b |\n| --- | --- |\n| `x\\|y` | c |",
+	}
+	for input, expected := range cases {
+		if actual := Parse(input, nil).Markdown(); actual != expected {
+			t.Errorf("%s: got\n%+v\nexpected\n%+v", input, actual, expected)
+		}
+	}
+}
+
+func TestMarkdownRoundTrip(t *testing.T) {
+	inputs := []string{
+		"# Title\n\nSome *text* with a [link](http://a.com \"t\").\n\n- one\n- two\n    1. nested",
+		"> quote with `code`\n\n***\n\n| a | b |\n|---|--:|\n| 1 | 2 |",
+		"```js\nvar a = '<b>';\n```\n\nfoo  \nbar",
+		"Title\n=====\n\n* _a_\n* __b__\n\n~~~\ncode\n~~~\n\n    indented",
+		"* a\n\n  b\n* c\n\n  d\n\n      code",
+		"1. a\n\n   b\n\n   - c\n\n     d",
+		"| a | b |\n|---|---|\n| `x\\|y` | *z\\|w* |",
+		"text with &amp; and © <b>x</b> &lt;i&gt; <span class=\"a\">y</span>",
+	}
+	for _, input := range inputs {
+		expected := strings.Replace(Render(input), "\n", "", -1)
+		md := Parse(input, nil).Markdown()
+		if actual := strings.Replace(Render(md), "\n", "", -1); actual != expected {
+			t.Errorf("%s: got\n%+v\nexpected\n%+v\nmarkdown\n%+v", input, actual, expected, md)
+		}
+	}
+}
+
+// userNode is a node type that the serializers don't know.
+type userNode struct{ NodeType }
+
+func (userNode) Render() string { return "user" }
+
+func TestMarkdownNodes(t *testing.T) {
+	cases := []struct {
+		node     Node
+		expected string
+	}{
+		{NewListItem(NewText("foo"), NewParagraph(NewText("bar"))), "foo\n\nbar"},
+		{NewRow(NewCell(Data, None, NewText("a")), NewCell(Data, None, NewText("b"))), "| a | b |"},
+		{NewCell(Data, None, NewStrong(NewText("a"))), "**a**"},
+		{NewTab("t", NewParagraph(NewText("foo"))), "foo"},
+		{NewDocument(NewParagraph(NewText("a")), NewHr()), "a\n\n***"},
+		{NewParagraph(NewText("a"), NewRaw("<b>"), NewShortcode("x", nil, "")), "a<b>{{< x />}}"},
+		{NewInlineCode("a``b"), "```a``b```"},
+		{NewInlineCode("`a"), "`` `a ``"},
+		{NewInlineCode("a`"), "`` a` ``"},
+		{userNode{NodeType: 100}, ""},
+		{NewParagraph(NewText("a"), userNode{NodeType: 100}), "a"},
+	}
+	for _, c := range cases {
+		if actual := Markdown(c.node); actual != c.expected {
+			t.Errorf("%T: got\n%+v\nexpected\n%+v", c.node, actual, c.expected)
+		}
+	}
+	// code spans are parsed back as is
+	for _, text := range []string{"a``b", "`a", "a`", "``"} {
+		expected := "<p>x <code>" + text + "</code></p>"
+		if actual := Render(Markdown(NewParagraph(NewText("x "), NewInlineCode(text)))); actual != expected {
+			t.Errorf("%s: got\n%+v\nexpected\n%+v", text, actual, expected)
+		}
+	}
+}
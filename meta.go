@@ -0,0 +1,93 @@
+package mark
+
+import (
+	"html"
+	"strings"
+)
+
+// metaDescriptionLen is the max length(in runes) of Meta.Description.
+const metaDescriptionLen = 200
+
+// Meta is the summary of a document, that is used to fill the Open Graph
+// and the Twitter card <meta> tags. its fields are plain text.
+type Meta struct {
+	Title       string // The text of the first level-1 heading, or of the first heading
+	Description string // The text of the first paragraph, truncated to 200 characters
+	Image       string // The src of the first image
+}
+
+// Meta returns the summary of the document.
+func (d *DocumentNode) Meta() (m Meta) {
+	headings := d.Select(NodeHeading)
+	if h := headings.WithLevel(1).First(); h != nil {
+		m.Title = txInline(h.(*HeadingNode).Nodes)
+	} else if h := headings.First(); h != nil {
+		m.Title = txInline(h.(*HeadingNode).Nodes)
+	}
+	if p := d.Select(NodeParagraph).First(); p != nil {
+		m.Description = truncateText(strings.Join(strings.Fields(txInline(p.(*ParagraphNode).Nodes)), " "), metaDescriptionLen)
+	}
+	image := d.Find(func(n Node) bool {
+		if r, ok := n.(*RefNode); ok {
+			n = r.resolve()
+		}
+		_, ok := n.(*ImageNode)
+		return ok
+	}).First()
+	if r, ok := image.(*RefNode); ok {
+		image = r.resolve()
+	}
+	if img, ok := image.(*ImageNode); ok {
+		m.Image = html.UnescapeString(img.Src)
+	}
+	return
+}
+
+// Excerpt returns the top-level nodes above the excerpt separator(a
+// "<!--more-->" line), and reports whether the document has one. without
+// a separator, all the nodes are returned.
+func (d *DocumentNode) Excerpt() ([]Node, bool) {
+	for i, n := range d.Nodes {
+		if h, ok := n.(*HTMLNode); ok && reMore.MatchString(strings.TrimSpace(h.Src)) {
+			return d.Nodes[:i], true
+		}
+	}
+	return d.Nodes, false
+}
+
+// HTML returns the Open Graph and the Twitter card <meta> tags of the
+// summary, one per line. empty fields are omitted.
+func (m Meta) HTML() string {
+	var tags []string
+	add := func(attr, name, content string) {
+		if content != "" {
+			tags = append(tags, "<meta "+attr+"=\""+name+"\" content=\""+htmlEscaper.Replace(content)+"\">")
+		}
+	}
+	card := "summary"
+	if m.Image != "" {
+		card = "summary_large_image"
+	}
+	add("property", "og:title", m.Title)
+	add("property", "og:description", m.Description)
+	add("property", "og:image", m.Image)
+	add("name", "twitter:card", card)
+	add("name", "twitter:title", m.Title)
+	add("name", "twitter:description", m.Description)
+	add("name", "twitter:image", m.Image)
+	return strings.Join(tags, "\n")
+}
+
+// truncateText truncates s to n runes at a word boundary, and appends an
+// ellipsis if it was truncated.
+func truncateText(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	s = string(r[:n])
+	if i := strings.LastIndexByte(s, ' '); i > 0 {
+		s = s[:i]
+	}
+	return strings.TrimRight(s, " .,;:") + "…"
+}
@@ -0,0 +1,60 @@
+package mark
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMeta(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected Meta
+	}{
+		{"## Intro\n\n# Post & \"title\"\n\nfirst\nparagraph with *emphasis*.\n\n![img](/a.png)", Meta{"Post & \"title\"", "first paragraph with emphasis.", "/a.png"}},
+		{"## Sub\n\n> ![ref][img]\n\n[img]: /b.png?a=1&b=2", Meta{"Sub", "ref", "/b.png?a=1&b=2"}},
+		{strings.Repeat("word ", 50), Meta{"", strings.TrimSpace(strings.Repeat("word ", 40)) + "…", ""}},
+		{"", Meta{}},
+	}
+	for _, c := range cases {
+		if actual := Parse(c.input, nil).Meta(); actual != c.expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", c.input, actual, c.expected)
+		}
+	}
+}
+
+func TestMetaHTML(t *testing.T) {
+	m := Meta{Title: "A <b>", Description: "desc", Image: "/a.png?x=1&y=2"}
+	expected := strings.Join([]string{
+		`<meta property="og:title" content="A &lt;b&gt;">`,
+		`<meta property="og:description" content="desc">`,
+		`<meta property="og:image" content="/a.png?x=1&amp;y=2">`,
+		`<meta name="twitter:card" content="summary_large_image">`,
+		`<meta name="twitter:title" content="A &lt;b&gt;">`,
+		`<meta name="twitter:description" content="desc">`,
+		`<meta name="twitter:image" content="/a.png?x=1&amp;y=2">`,
+	}, "\n")
+	if actual := m.HTML(); actual != expected {
+		t.Errorf("HTML: got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+	if expected := `<meta name="twitter:card" content="summary">`; (Meta{}).HTML() != expected {
+		t.Errorf("HTML: got\n\t%+v\nexpected\n\t%+v", (Meta{}).HTML(), expected)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+		ok       bool
+	}{
+		{"# Post\n\nintro *text*\n<!--more-->\nrest\n\n<!--more-->", "<h1 id=\"post\">Post</h1>\n<p>intro <em>text</em></p>", true},
+		{"intro\n\n<!-- More -->\n\nrest", "<p>intro</p>", true},
+		{"intro <!--more--> rest", "<p>intro <!--more--> rest</p>", false},
+		{"<!--more-->\n\nrest", "", true},
+	}
+	for _, c := range cases {
+		if actual, ok := New(c.input, nil).Excerpt(); actual != c.expected || ok != c.ok {
+			t.Errorf("%s: got\n\t%+v %v\nexpected\n\t%+v %v", c.input, actual, ok, c.expected, c.ok)
+		}
+	}
+}
@@ -1,10 +1,15 @@
 package mark
 
 import (
+	"context"
 	"fmt"
-	"regexp"
+	"html"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 // A Node is an element in the parse tree.
@@ -25,29 +30,107 @@ func (t NodeType) Type() NodeType {
 // Render function, used for overriding default rendering.
 type RenderFn func(Node) string
 
+// ContextRenderFn is like RenderFn, but it gets the context that was passed
+// to RenderContext, used to access per-render values(e.g: the current user).
+type ContextRenderFn func(ctx context.Context, n Node) string
+
+// TabsFn renders a group of tabs, given the titles of the tabs
+// and their rendered content.
+type TabsFn func(titles, panels []string) string
+
+// ShortcodeFn renders a shortcode, given its arguments and its inner
+// content. positional arguments are keyed by their index("0", "1", ...).
+type ShortcodeFn func(args map[string]string, inner string) string
+
+// shortcode is a registered shortcode handler.
+type shortcode struct {
+	fn       ShortcodeFn
+	markdown bool // the handler gets the rendered inner content
+}
+
+// HeadingFn renders a heading, given its level, generated id, text
+// and rendered children.
+type HeadingFn func(level int, id, text, children string) string
+
 const (
-	NodeText       NodeType = iota // A plain text
-	NodeParagraph                  // A Paragraph
-	NodeEmphasis                   // An emphasis(strong, em, ...)
-	NodeHeading                    // A heading (h1, h2, ...)
-	NodeBr                         // A link break
-	NodeHr                         // A horizontal rule
-	NodeImage                      // An image
-	NodeRefImage                   // A image reference
-	NodeList                       // A list of ListItems
-	NodeListItem                   // A list item node
-	NodeLink                       // A link(href)
-	NodeRefLink                    // A link reference
-	NodeDefLink                    // A link definition
-	NodeTable                      // A table of NodeRows
-	NodeRow                        // A row of NodeCells
-	NodeCell                       // A table-cell(td)
-	NodeCode                       // A code block(wrapped with pre)
-	NodeBlockQuote                 // A blockquote
-	NodeHTML                       // An inline HTML
-	NodeCheckbox                   // A checkbox
+	NodeText         NodeType = iota // A plain text
+	NodeParagraph                    // A Paragraph
+	NodeEmphasis                     // An emphasis(strong, em, ...)
+	NodeHeading                      // A heading (h1, h2, ...)
+	NodeBr                           // A link break
+	NodeHr                           // A horizontal rule
+	NodeImage                        // An image
+	NodeRefImage                     // A image reference
+	NodeList                         // A list of ListItems
+	NodeListItem                     // A list item node
+	NodeLink                         // A link(href)
+	NodeRefLink                      // A link reference
+	NodeDefLink                      // A link definition
+	NodeTable                        // A table of NodeRows
+	NodeRow                          // A row of NodeCells
+	NodeCell                         // A table-cell(td)
+	NodeCode                         // A code block(wrapped with pre)
+	NodeBlockQuote                   // A blockquote
+	NodeHTML                         // An inline HTML
+	NodeCheckbox                     // A checkbox
+	NodeRuby                         // A ruby annotation
+	NodeEmoji                        // A custom emoji(shortcode image)
+	NodeDetails                      // A collapsible details block
+	NodeTabs                         // A group of Tabs
+	NodeTab                          // A tab with title
+	NodeDocument                     // The root of a document
+	NodeRaw                          // A raw region, emitted verbatim
+	NodeShortcode                    // A Hugo-style shortcode
+	NodeCustomInline                 // A match of an Options.CustomInline rule
+	NodeDiv                          // A fenced div with attributes
+	NodeLiteral                      // A malformed construct, kept as literal text
 )
 
+// NodeNames maps the node types to their names, used by dumps and
+// debuggers. the names are stable, and aren't changed between versions.
+var NodeNames = map[NodeType]string{
+	NodeText:         "Text",
+	NodeParagraph:    "Paragraph",
+	NodeEmphasis:     "Emphasis",
+	NodeHeading:      "Heading",
+	NodeBr:           "Br",
+	NodeHr:           "Hr",
+	NodeImage:        "Image",
+	NodeRefImage:     "RefImage",
+	NodeList:         "List",
+	NodeListItem:     "ListItem",
+	NodeLink:         "Link",
+	NodeRefLink:      "RefLink",
+	NodeDefLink:      "DefLink",
+	NodeTable:        "Table",
+	NodeRow:          "Row",
+	NodeCell:         "Cell",
+	NodeCode:         "Code",
+	NodeBlockQuote:   "BlockQuote",
+	NodeHTML:         "HTML",
+	NodeCheckbox:     "Checkbox",
+	NodeRuby:         "Ruby",
+	NodeEmoji:        "Emoji",
+	NodeDetails:      "Details",
+	NodeTabs:         "Tabs",
+	NodeTab:          "Tab",
+	NodeDocument:     "Document",
+	NodeRaw:          "Raw",
+	NodeShortcode:    "Shortcode",
+	NodeCustomInline: "CustomInline",
+	NodeDiv:          "Div",
+	NodeLiteral:      "Literal",
+}
+
+// NodeName returns the name of the given node type, or "Node(n)"
+// for unknown types.
+func NodeName(t NodeType) string {
+	if s, ok := NodeNames[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("Node(%d)", int(t))
+}
+
 // ParagraphNode hold simple paragraph node contains text
 // that may be emphasis.
 type ParagraphNode struct {
@@ -57,17 +140,23 @@ type ParagraphNode struct {
 }
 
 // Render returns the html representation of ParagraphNode
-func (n *ParagraphNode) Render() (s string) {
-	for _, node := range n.Nodes {
-		s += node.Render()
-	}
-	return wrap("p", s)
+func (n *ParagraphNode) Render() string {
+	return n.html(newRenderer(nil, nil))
+}
+
+func (n *ParagraphNode) html(r *renderer) string {
+	return wrap("p", r.renderAll(n.Nodes))
 }
 
 func (p *parse) newParagraph(pos Pos) *ParagraphNode {
 	return &ParagraphNode{NodeType: NodeParagraph, Pos: pos}
 }
 
+// NewParagraph returns a new paragraph that holds the given nodes.
+func NewParagraph(nodes ...Node) *ParagraphNode {
+	return &ParagraphNode{NodeType: NodeParagraph, Nodes: nodes}
+}
+
 // TextNode holds plain text.
 type TextNode struct {
 	NodeType
@@ -81,7 +170,45 @@ func (n *TextNode) Render() string {
 }
 
 func (p *parse) newText(pos Pos, text string) *TextNode {
-	return &TextNode{NodeType: NodeText, Pos: pos, Text: p.text(text)}
+	return &TextNode{NodeType: NodeText, Pos: pos, Text: p.content(text)}
+}
+
+// newLiteral returns a text node that holds the given source as is.
+func (p *parse) newLiteral(pos Pos, src string) *TextNode {
+	if p.root().options.LiteralQuotes {
+		return &TextNode{NodeType: NodeText, Pos: pos, Text: contentEscaper.Replace(src)}
+	}
+	return &TextNode{NodeType: NodeText, Pos: pos, Text: htmlEscaper.Replace(src)}
+}
+
+// LiteralNode holds the source of a malformed construct(e.g: an unclosed
+// code fence) as literal text. it's used with the LiteralErrors option,
+// and Reason describes the problem.
+type LiteralNode struct {
+	NodeType
+	Pos
+	Text   string
+	Reason string
+}
+
+// Render returns the string representation of LiteralNode
+func (n *LiteralNode) Render() string {
+	return n.Text
+}
+
+func (p *parse) newLiteralNode(pos Pos, src, reason string) *LiteralNode {
+	return &LiteralNode{NodeType: NodeLiteral, Pos: pos, Text: p.newLiteral(pos, src).Text, Reason: reason}
+}
+
+// NewLiteral returns a new literal node with the given reason.
+// the given source is html-escaped.
+func NewLiteral(src, reason string) *LiteralNode {
+	return &LiteralNode{NodeType: NodeLiteral, Text: htmlEscaper.Replace(src), Reason: reason}
+}
+
+// NewText returns a new text node. the given text is html-escaped.
+func NewText(text string) *TextNode {
+	return &TextNode{NodeType: NodeText, Text: htmlEscaper.Replace(text)}
 }
 
 // HTMLNode holds the raw html source.
@@ -89,17 +216,116 @@ type HTMLNode struct {
 	NodeType
 	Pos
 	Src string
+	// Open and Close hold the tags of an html block whose content is
+	// parsed as markdown(see Options.MarkdownInHTML), and Nodes holds
+	// its content.
+	Open, Close string
+	Nodes       []Node
 }
 
 // Render returns the src of the HTMLNode
 func (n *HTMLNode) Render() string {
-	return n.Src
+	return n.html(newRenderer(nil, nil))
+}
+
+func (n *HTMLNode) html(r *renderer) string {
+	s := n.Src
+	if n.Open != "" {
+		blocks := []string{reMarkdownAttr.ReplaceAllString(n.Open, "")}
+		for _, node := range n.Nodes {
+			if b := r.render(node); b != "" {
+				blocks = append(blocks, b)
+			}
+		}
+		s = strings.Join(append(blocks, n.Close), "\n")
+	}
+	if r.options.TagFilter {
+		return reTagFilter.ReplaceAllString(s, "&lt;$1")
+	}
+	return s
 }
 
 func (p *parse) newHTML(pos Pos, src string) *HTMLNode {
+	if p.inHTML {
+		src = reMarkdownAttr.ReplaceAllString(src, "")
+	}
 	return &HTMLNode{NodeType: NodeHTML, Pos: pos, Src: src}
 }
 
+// NewHTML returns a new node that holds raw html source.
+func NewHTML(src string) *HTMLNode {
+	return &HTMLNode{NodeType: NodeHTML, Src: src}
+}
+
+// RawNode holds the content of a raw region, that is emitted verbatim.
+type RawNode struct {
+	NodeType
+	Pos
+	Text string
+}
+
+// Render returns the text of the RawNode
+func (n *RawNode) Render() string {
+	return n.Text
+}
+
+func (p *parse) newRaw(pos Pos, text string) *RawNode {
+	return &RawNode{NodeType: NodeRaw, Pos: pos, Text: text}
+}
+
+// newTemplate returns a raw node for a Liquid/Jinja tag. the tag is kept
+// as is, but the disallowed raw html tags in it are escaped(TagFilter).
+func (p *parse) newTemplate(pos Pos, tag string) *RawNode {
+	if p.root().options.TagFilter {
+		tag = reTagFilter.ReplaceAllString(tag, "&lt;$1")
+	}
+	return p.newRaw(pos, tag)
+}
+
+// NewRaw returns a new node that holds text that is emitted verbatim.
+func NewRaw(text string) *RawNode {
+	return &RawNode{NodeType: NodeRaw, Text: text}
+}
+
+// ShortcodeNode represents a Hugo-style shortcode. Inner is the raw
+// content between its tags, and Nodes is the parsed content.
+type ShortcodeNode struct {
+	NodeType
+	Pos
+	Name  string
+	Args  map[string]string
+	Src   string // The source of the shortcode, including its tags
+	Inner string
+	Nodes []Node
+}
+
+// Render returns the html representation of ShortcodeNode
+func (n *ShortcodeNode) Render() string {
+	return n.html(newRenderer(nil, nil))
+}
+
+// unregistered shortcodes are rendered as text.
+func (n *ShortcodeNode) html(r *renderer) string {
+	sc, ok := r.shortcodes[n.Name]
+	if !ok {
+		return htmlEscaper.Replace(n.Src)
+	}
+	if sc.markdown {
+		return sc.fn(n.Args, r.renderAll(n.Nodes))
+	}
+	return sc.fn(n.Args, n.Inner)
+}
+
+func (p *parse) newShortcode(pos Pos, name, src string) *ShortcodeNode {
+	return &ShortcodeNode{NodeType: NodeShortcode, Pos: pos, Name: name, Args: make(map[string]string), Src: src}
+}
+
+// NewShortcode returns a new shortcode with the given name, arguments
+// and inner content.
+func NewShortcode(name string, args map[string]string, inner string, nodes ...Node) *ShortcodeNode {
+	return &ShortcodeNode{NodeType: NodeShortcode, Name: name, Args: args, Inner: inner, Nodes: nodes}
+}
+
 // HrNode represents horizontal rule
 type HrNode struct {
 	NodeType
@@ -108,13 +334,22 @@ type HrNode struct {
 
 // Render returns the html representation of hr.
 func (n *HrNode) Render() string {
-	return "<hr>"
+	return n.html(newRenderer(nil, nil))
+}
+
+func (n *HrNode) html(r *renderer) string {
+	return r.void("<hr")
 }
 
 func (p *parse) newHr(pos Pos) *HrNode {
 	return &HrNode{NodeType: NodeHr, Pos: pos}
 }
 
+// NewHr returns a new horizontal rule.
+func NewHr() *HrNode {
+	return &HrNode{NodeType: NodeHr}
+}
+
 // BrNode represents a link-break element.
 type BrNode struct {
 	NodeType
@@ -123,19 +358,29 @@ type BrNode struct {
 
 // Render returns the html representation of line-break.
 func (n *BrNode) Render() string {
-	return "<br>"
+	return n.html(newRenderer(nil, nil))
+}
+
+func (n *BrNode) html(r *renderer) string {
+	return r.void("<br")
 }
 
 func (p *parse) newBr(pos Pos) *BrNode {
 	return &BrNode{NodeType: NodeBr, Pos: pos}
 }
 
+// NewBr returns a new line-break.
+func NewBr() *BrNode {
+	return &BrNode{NodeType: NodeBr}
+}
+
 // EmphasisNode holds plain-text wrapped with style.
 // (strong, em, del, code)
 type EmphasisNode struct {
 	NodeType
 	Pos
 	Style itemType
+	Delim string // delimiter of strong and em in the source, e.g: "_" or "**"
 	Nodes []Node
 }
 
@@ -156,40 +401,78 @@ func (n *EmphasisNode) Tag() (s string) {
 
 // Return the html representation of emphasis text.
 func (n *EmphasisNode) Render() string {
-	var s string
-	for _, node := range n.Nodes {
-		s += node.Render()
-	}
-	return wrap(n.Tag(), s)
+	return n.html(newRenderer(nil, nil))
+}
+
+func (n *EmphasisNode) html(r *renderer) string {
+	return wrap(n.Tag(), r.renderAll(n.Nodes))
 }
 
 func (p *parse) newEmphasis(pos Pos, style itemType) *EmphasisNode {
 	return &EmphasisNode{NodeType: NodeEmphasis, Pos: pos, Style: style}
 }
 
+// NewStrong returns a new strong emphasis that holds the given nodes.
+func NewStrong(nodes ...Node) *EmphasisNode {
+	return &EmphasisNode{NodeType: NodeEmphasis, Style: itemStrong, Nodes: nodes}
+}
+
+// NewItalic returns a new italic emphasis that holds the given nodes.
+func NewItalic(nodes ...Node) *EmphasisNode {
+	return &EmphasisNode{NodeType: NodeEmphasis, Style: itemItalic, Nodes: nodes}
+}
+
+// NewStrike returns a new strikethrough that holds the given nodes.
+func NewStrike(nodes ...Node) *EmphasisNode {
+	return &EmphasisNode{NodeType: NodeEmphasis, Style: itemStrike, Nodes: nodes}
+}
+
+// NewInlineCode returns a new code span. the given text is html-escaped.
+func NewInlineCode(text string) *EmphasisNode {
+	return &EmphasisNode{NodeType: NodeEmphasis, Style: itemCode, Nodes: []Node{NewText(text)}}
+}
+
 // HeadingNode holds heaing element with specific level(1-6).
 type HeadingNode struct {
 	NodeType
 	Pos
-	Level int
-	Text  string
-	Nodes []Node
+	Level  int
+	Text   string
+	Setext bool // underlined with "=" or "-" in the source
+	Nodes  []Node
+
+	slugs SlugStyle // The algorithm of its id, Options.Slugs
 }
 
 // Render returns the html representation based on heading level.
-func (n *HeadingNode) Render() (s string) {
-	for _, node := range n.Nodes {
-		s += node.Render()
+func (n *HeadingNode) Render() string {
+	return n.html(newRenderer(nil, nil))
+}
+
+func (n *HeadingNode) html(r *renderer) string {
+	s, id := r.renderAll(n.Nodes), n.ID()
+	if r.headingFn != nil {
+		return r.headingFn(n.Level, id, n.Text, s)
 	}
-	re := regexp.MustCompile(`[^\w]+`)
-	id := re.ReplaceAllString(n.Text, "-")
-	// ToLowerCase
-	id = strings.ToLower(id)
-	return fmt.Sprintf("<%[1]s id=\"%s\">%s</%[1]s>", "h"+strconv.Itoa(n.Level), id, s)
+	return fmt.Sprintf("<%[1]s id=\"%s\">%s</%[1]s>", "h"+strconv.Itoa(n.Level), r.attr(id), s)
+}
+
+// ID returns the id of the heading, that is generated from its text.
+func (n *HeadingNode) ID() string {
+	if n.slugs != SlugASCII {
+		return slug(txInline(n.Nodes), n.slugs == SlugTransliterate)
+	}
+	return strings.ToLower(reHeadingID.ReplaceAllString(n.Text, "-"))
 }
 
 func (p *parse) newHeading(pos Pos, level int, text string) *HeadingNode {
-	return &HeadingNode{NodeType: NodeHeading, Pos: pos, Level: level, Text: p.text(text)}
+	return &HeadingNode{NodeType: NodeHeading, Pos: pos, Level: level, Text: p.text(text), slugs: p.root().options.Slugs}
+}
+
+// NewHeading returns a new heading with the given level(1-6) that holds the given nodes.
+// the heading id is generated from the text of its nodes.
+func NewHeading(level int, nodes ...Node) *HeadingNode {
+	return &HeadingNode{NodeType: NodeHeading, Level: level, Text: plainText(nodes), Nodes: nodes}
 }
 
 // Code holds CodeBlock node with specific lang field.
@@ -197,24 +480,68 @@ type CodeNode struct {
 	NodeType
 	Pos
 	Lang, Text string
+	Fence      string // opening fence in the source, e.g: "~~~~"
+	Indented   bool   // indented code block in the source
 }
 
 // Return the html representation of codeBlock
 func (n *CodeNode) Render() string {
+	return n.html(newRenderer(nil, nil))
+}
+
+func (n *CodeNode) html(r *renderer) string {
 	var attr string
 	if n.Lang != "" {
-		attr = fmt.Sprintf(" class=\"lang-%s\"", n.Lang)
+		attr = fmt.Sprintf(" class=\"%s\"", r.attr(r.class("lang-"+n.Lang)))
 	}
 	code := fmt.Sprintf("<%[1]s%s>%s</%[1]s>", "code", attr, n.Text)
+	// email clients keep the width of a table cell
+	if r.options.Email {
+		return "<table role=\"presentation\" width=\"100%\"><tr><td>" + wrap("pre", code) + "</td></tr></table>"
+	}
 	return wrap("pre", code)
 }
 
 func (p *parse) newCode(pos Pos, lang, text string) *CodeNode {
 	// DRY: see `escape()` below
-	text = strings.NewReplacer("<", "&lt;", ">", "&gt;", "\"", "&quot;", "&", "&amp;").Replace(text)
+	if p.root().options.LiteralQuotes {
+		text = contentEscaper.Replace(text)
+	} else {
+		text = strings.NewReplacer("<", "&lt;", ">", "&gt;", "\"", "&quot;", "&", "&amp;").Replace(text)
+	}
 	return &CodeNode{NodeType: NodeCode, Pos: pos, Lang: lang, Text: text}
 }
 
+// expandTabs replaces the tabs of the given text with spaces, up to the
+// next multiple of width columns.
+func expandTabs(text string, width int) string {
+	if !strings.Contains(text, "\t") {
+		return text
+	}
+	var b strings.Builder
+	col := 0
+	for _, r := range text {
+		switch r {
+		case '\t':
+			b.WriteString(strings.Repeat(" ", width-col%width))
+			col += width - col%width
+		case '\n':
+			b.WriteRune(r)
+			col = 0
+		default:
+			b.WriteRune(r)
+			col++
+		}
+	}
+	return b.String()
+}
+
+// NewCode returns a new code block with optional lang. the given text is html-escaped.
+func NewCode(lang, text string) *CodeNode {
+	text = htmlEscaper.Replace(text)
+	return &CodeNode{NodeType: NodeCode, Lang: lang, Text: text}
+}
+
 // Link holds a tag with optional title
 type LinkNode struct {
 	NodeType
@@ -224,19 +551,26 @@ type LinkNode struct {
 }
 
 // Return the html representation of link node
-func (n *LinkNode) Render() (s string) {
-	for _, node := range n.Nodes {
-		s += node.Render()
-	}
-	attrs := fmt.Sprintf("href=\"%s\"", n.Href)
-	if n.Title != "" {
-		attrs += fmt.Sprintf(" title=\"%s\"", n.Title)
+func (n *LinkNode) Render() string {
+	return n.html(newRenderer(nil, nil))
+}
+
+func (n *LinkNode) html(r *renderer) string {
+	s := r.renderAll(n.Nodes)
+	attrs := fmt.Sprintf("href=\"%s\"", r.urlAttr(n.Href))
+	if title := r.attr(n.Title); title != "" {
+		attrs += fmt.Sprintf(" title=\"%s\"", title)
 	}
 	return fmt.Sprintf("<a %s>%s</a>", attrs, s)
 }
 
 func (p *parse) newLink(pos Pos, title, href string, nodes ...Node) *LinkNode {
-	return &LinkNode{NodeType: NodeLink, Pos: pos, Title: p.text(title), Href: p.text(href), Nodes: nodes}
+	return &LinkNode{NodeType: NodeLink, Pos: pos, Title: p.text(title), Href: p.href(href), Nodes: nodes}
+}
+
+// NewLink returns a new link with optional title that holds the given nodes.
+func NewLink(href, title string, nodes ...Node) *LinkNode {
+	return &LinkNode{NodeType: NodeLink, Title: htmlEscaper.Replace(title), Href: htmlEscaper.Replace(href), Nodes: nodes}
 }
 
 // RefLink holds link with refrence to link definition
@@ -250,18 +584,30 @@ type RefNode struct {
 
 // rendering based type
 func (n *RefNode) Render() string {
-	var node Node
-	ref := strings.ToLower(n.Ref)
-	if l, ok := n.tr.links[ref]; ok {
-		if n.Type() == NodeRefLink {
-			node = n.tr.newLink(n.Pos, l.Title, l.Href, n.Nodes...)
-		} else {
-			node = n.tr.newImage(n.Pos, l.Title, l.Href, n.Text)
-		}
-	} else {
-		node = n.tr.newText(n.Pos, n.Raw)
+	return n.html(newRenderer(nil, nil))
+}
+
+func (n *RefNode) html(r *renderer) string {
+	return r.render(n.resolve())
+}
+
+// resolve returns the link or the image that the reference points to, or
+// a text node with the raw reference if it's not defined.
+func (n *RefNode) resolve() Node {
+	l, ok := n.tr.links[strings.ToLower(n.Ref)]
+	if !ok {
+		l, ok = n.tr.options.Links[strings.ToLower(n.Ref)]
+	}
+	switch {
+	case !ok && n.tr.options.LiteralErrors:
+		return n.tr.newLiteralNode(n.Pos, n.Raw, fmt.Sprintf("undefined reference %q", n.Ref))
+	case !ok:
+		return n.tr.newText(n.Pos, n.Raw)
+	case n.Type() == NodeRefLink:
+		return n.tr.newLink(n.Pos, l.Title, l.Href, n.Nodes...)
+	default:
+		return n.tr.newImage(n.Pos, l.Title, l.Href, n.Text)
 	}
-	return node.Render()
 }
 
 // newRefLink create new RefLink that suitable for link
@@ -274,7 +620,9 @@ func (p *parse) newRefImage(typ itemType, pos Pos, raw, ref, text string) *RefNo
 	return &RefNode{NodeType: NodeRefImage, Pos: pos, tr: p.root(), Raw: raw, Ref: ref, Text: text}
 }
 
-// DefLinkNode refresent single reference to link-definition
+// DefLinkNode refresent single reference to link-definition.
+// its type is NodeDefLink(it used to be NodeLink, so a RenderFn for
+// NodeLink got the definitions too).
 type DefLinkNode struct {
 	NodeType
 	Pos
@@ -287,7 +635,7 @@ func (n *DefLinkNode) Render() string {
 }
 
 func (p *parse) newDefLink(pos Pos, name, href, title string) *DefLinkNode {
-	return &DefLinkNode{NodeType: NodeLink, Pos: pos, Name: name, Href: href, Title: title}
+	return &DefLinkNode{NodeType: NodeDefLink, Pos: pos, Name: name, Href: p.url(href), Title: title}
 }
 
 // ImageNode represents an image element with optional alt and title attributes.
@@ -299,23 +647,101 @@ type ImageNode struct {
 
 // Render returns the html representation on image node
 func (n *ImageNode) Render() string {
-	attrs := fmt.Sprintf("src=\"%s\" alt=\"%s\"", n.Src, n.Alt)
-	if n.Title != "" {
-		attrs += fmt.Sprintf(" title=\"%s\"", n.Title)
+	return n.html(newRenderer(nil, nil))
+}
+
+func (n *ImageNode) html(r *renderer) string {
+	src := n.Src
+	if path, ok := localPath(src); ok && r.options.AssetFn != nil {
+		src = htmlEscaper.Replace(r.options.AssetFn(path))
+	}
+	attrs := fmt.Sprintf("src=\"%s\" alt=\"%s\"", r.urlAttr(src), r.attr(n.Alt))
+	if title := r.attr(n.Title); title != "" {
+		attrs += fmt.Sprintf(" title=\"%s\"", title)
 	}
-	return fmt.Sprintf("<img %s>", attrs)
+	return r.void("<img " + attrs)
 }
 
 func (p *parse) newImage(pos Pos, title, src, alt string) *ImageNode {
-	return &ImageNode{NodeType: NodeImage, Pos: pos, Title: p.text(title), Src: p.text(src), Alt: p.text(alt)}
+	return &ImageNode{NodeType: NodeImage, Pos: pos, Title: p.text(title), Src: p.href(src), Alt: p.text(alt)}
+}
+
+// NewImage returns a new image with optional title and alt attributes.
+func NewImage(src, title, alt string) *ImageNode {
+	return &ImageNode{NodeType: NodeImage, Title: htmlEscaper.Replace(title), Src: htmlEscaper.Replace(src), Alt: htmlEscaper.Replace(alt)}
+}
+
+// EmojiNode represents a custom emoji, that rendered as an image.
+type EmojiNode struct {
+	NodeType
+	Pos
+	Name, Src string
+}
+
+// Render returns the html representation of EmojiNode
+func (n *EmojiNode) Render() string {
+	return n.html(newRenderer(nil, nil))
+}
+
+func (n *EmojiNode) html(r *renderer) string {
+	return r.void(fmt.Sprintf("<img class=\"%s\" src=\"%s\" alt=\":%s:\"", r.class("emoji"), r.urlAttr(n.Src), r.attr(n.Name)))
+}
+
+func (p *parse) newEmoji(pos Pos, name, src string) *EmojiNode {
+	return &EmojiNode{NodeType: NodeEmoji, Pos: pos, Name: name, Src: htmlEscaper.Replace(src)}
+}
+
+// NewEmoji returns a new custom emoji with the given shortcode name
+// and image url.
+func NewEmoji(name, src string) *EmojiNode {
+	return &EmojiNode{NodeType: NodeEmoji, Name: htmlEscaper.Replace(name), Src: htmlEscaper.Replace(src)}
+}
+
+// CustomInlineNode holds a match of an Options.CustomInline rule.
+type CustomInlineNode struct {
+	NodeType
+	Pos
+	Match  []string // The match and its groups, Match[0] is the source text
+	render func(match []string) string
+}
+
+// Render returns the html representation of CustomInlineNode, that is
+// returned by the Render function of its rule.
+func (n *CustomInlineNode) Render() string {
+	if n.render == nil {
+		return htmlEscaper.Replace(n.Match[0])
+	}
+	return n.render(n.Match)
+}
+
+func (p *parse) newCustomInline(pos Pos, src string) Node {
+	// the text of code spans is kept as is
+	if p.root().zones&ZoneCode != 0 {
+		return p.newText(pos, src)
+	}
+	for _, rule := range p.root().options.CustomInline {
+		if m := rule.Pattern.FindStringSubmatch(src); m != nil && m[0] == src {
+			return &CustomInlineNode{NodeType: NodeCustomInline, Pos: pos, Match: m, render: rule.Render}
+		}
+	}
+	return p.newText(pos, src)
+}
+
+// NewCustomInline returns a new node that holds the given match, and is
+// rendered by fn.
+func NewCustomInline(match []string, fn func(match []string) string) *CustomInlineNode {
+	return &CustomInlineNode{NodeType: NodeCustomInline, Match: match, render: fn}
 }
 
 // ListNode holds list items nodes in ordered or unordered states.
 type ListNode struct {
 	NodeType
 	Pos
-	Ordered bool
-	Items   []*ListItemNode
+	Ordered  bool
+	Start    int    // number of the first item of ordered list
+	HasStart bool   // Start was set(e.g: parsed), otherwise a zero Start means 1
+	Bullet   string // marker of unordered list in the source: "-", "*" or "+"
+	Items    []*ListItemNode
 }
 
 func (n *ListNode) append(item *ListItemNode) {
@@ -323,20 +749,44 @@ func (n *ListNode) append(item *ListItemNode) {
 }
 
 // Render returns the html representation of orderd(ol) or unordered(ul) list.
-func (n *ListNode) Render() (s string) {
+func (n *ListNode) Render() string {
+	return n.html(newRenderer(nil, nil))
+}
+
+func (n *ListNode) html(r *renderer) (s string) {
 	tag := "ul"
 	if n.Ordered {
 		tag = "ol"
 	}
 	for _, item := range n.Items {
-		s += "\n" + item.Render()
+		s += "\n" + r.render(item)
 	}
 	s += "\n"
-	return wrap(tag, s)
+	s = wrap(tag, s)
+	// lists that were interrupted by another block continue their numbering
+	if n.Ordered && n.start() != 1 {
+		s = addAttr(s, fmt.Sprintf("start=\"%d\"", n.start()))
+	}
+	return s
+}
+
+// start returns the number of the first item of the list. lists without
+// a start number(e.g: a zero-value ListNode) start at 1.
+func (n *ListNode) start() int {
+	if n.Start == 0 && !n.HasStart {
+		return 1
+	}
+	return n.Start
 }
 
-func (p *parse) newList(pos Pos, ordered bool) *ListNode {
-	return &ListNode{NodeType: NodeList, Pos: pos, Ordered: ordered}
+func (p *parse) newList(pos Pos, ordered bool, start int) *ListNode {
+	return &ListNode{NodeType: NodeList, Pos: pos, Ordered: ordered, Start: start, HasStart: ordered}
+}
+
+// NewList returns a new ordered or unordered list that holds the given items.
+// ordered lists start at 1.
+func NewList(ordered bool, items ...*ListItemNode) *ListNode {
+	return &ListNode{NodeType: NodeList, Ordered: ordered, Start: 1, Items: items}
 }
 
 // ListItem represents single item in ListNode that may contains nested nodes.
@@ -351,17 +801,23 @@ func (l *ListItemNode) append(n Node) {
 }
 
 // Render returns the html representation of list-item
-func (l *ListItemNode) Render() (s string) {
-	for _, node := range l.Nodes {
-		s += node.Render()
-	}
-	return wrap("li", s)
+func (l *ListItemNode) Render() string {
+	return l.html(newRenderer(nil, nil))
+}
+
+func (l *ListItemNode) html(r *renderer) string {
+	return wrap("li", r.renderAll(l.Nodes))
 }
 
 func (p *parse) newListItem(pos Pos) *ListItemNode {
 	return &ListItemNode{NodeType: NodeListItem, Pos: pos}
 }
 
+// NewListItem returns a new list item that holds the given nodes.
+func NewListItem(nodes ...Node) *ListItemNode {
+	return &ListItemNode{NodeType: NodeListItem, Nodes: nodes}
+}
+
 // TableNode represents table element contains head and body
 type TableNode struct {
 	NodeType
@@ -375,17 +831,21 @@ func (n *TableNode) append(row *RowNode) {
 
 // Render returns the html representation of a table
 func (n *TableNode) Render() string {
+	return n.html(newRenderer(nil, nil))
+}
+
+func (n *TableNode) html(r *renderer) string {
 	var s string
 	for i, row := range n.Rows {
 		s += "\n"
 		switch i {
 		case 0:
-			s += wrap("thead", "\n"+row.Render()+"\n")
+			s += wrap("thead", "\n"+r.render(row)+"\n")
 		case 1:
 			s += "<tbody>\n"
 			fallthrough
 		default:
-			s += row.Render()
+			s += r.render(row)
 		}
 	}
 	s += "\n</tbody>\n"
@@ -396,6 +856,12 @@ func (p *parse) newTable(pos Pos) *TableNode {
 	return &TableNode{NodeType: NodeTable, Pos: pos}
 }
 
+// NewTable returns a new table that holds the given rows.
+// the first row is used as the table head.
+func NewTable(rows ...*RowNode) *TableNode {
+	return &TableNode{NodeType: NodeTable, Rows: rows}
+}
+
 // RowNode represnt tr that holds list of cell-nodes
 type RowNode struct {
 	NodeType
@@ -403,15 +869,19 @@ type RowNode struct {
 	Cells []*CellNode
 }
 
-func (r *RowNode) append(cell *CellNode) {
-	r.Cells = append(r.Cells, cell)
+func (n *RowNode) append(cell *CellNode) {
+	n.Cells = append(n.Cells, cell)
 }
 
 // Render returns the html representation of table-row
-func (r *RowNode) Render() string {
+func (n *RowNode) Render() string {
+	return n.html(newRenderer(nil, nil))
+}
+
+func (n *RowNode) html(r *renderer) string {
 	var s string
-	for _, cell := range r.Cells {
-		s += "\n" + cell.Render()
+	for _, cell := range n.Cells {
+		s += "\n" + r.render(cell)
 	}
 	s += "\n"
 	return wrap("tr", s)
@@ -421,6 +891,11 @@ func (p *parse) newRow(pos Pos) *RowNode {
 	return &RowNode{NodeType: NodeRow, Pos: pos}
 }
 
+// NewRow returns a new table-row that holds the given cells.
+func NewRow(cells ...*CellNode) *RowNode {
+	return &RowNode{NodeType: NodeRow, Cells: cells}
+}
+
 // AlignType identifies the aligment-type of specfic cell.
 type AlignType int
 
@@ -456,14 +931,15 @@ type CellNode struct {
 
 // Render returns the html reprenestation of table-cell
 func (c *CellNode) Render() string {
-	var s string
+	return c.html(newRenderer(nil, nil))
+}
+
+func (c *CellNode) html(r *renderer) string {
 	tag := "td"
 	if c.Kind == Header {
 		tag = "th"
 	}
-	for _, node := range c.Nodes {
-		s += node.Render()
-	}
+	s := r.renderAll(c.Nodes)
 	return fmt.Sprintf("<%[1]s%s>%s</%[1]s>", tag, c.Style(), s)
 }
 
@@ -487,26 +963,199 @@ func (p *parse) newCell(pos Pos, kind int, align AlignType) *CellNode {
 	return &CellNode{NodeType: NodeCell, Pos: pos, Kind: kind, AlignType: align}
 }
 
+// NewCell returns a new table-cell of the given kind(Header or Data) and alignment.
+func NewCell(kind int, align AlignType, nodes ...Node) *CellNode {
+	return &CellNode{NodeType: NodeCell, Kind: kind, AlignType: align, Nodes: nodes}
+}
+
 // BlockQuote represents block-quote tag.
 type BlockQuoteNode struct {
 	NodeType
 	Pos
+	Alert string // GitHub alert kind(e.g: "note", "warning"), if any
+	Depth int    // Nesting depth, 1 for a quote that isn't inside another quote
 	Nodes []Node
 }
 
 // Render returns the html representation of BlockQuote
 func (n *BlockQuoteNode) Render() string {
-	var s string
-	for _, node := range n.Nodes {
-		s += node.Render()
+	return n.html(newRenderer(nil, nil))
+}
+
+func (n *BlockQuoteNode) html(r *renderer) string {
+	if n.Alert != "" {
+		title := fmt.Sprintf("<p class=\"%s\">%s</p>", r.class("markdown-alert-title"), strings.ToUpper(n.Alert[:1])+n.Alert[1:])
+		class := r.class("markdown-alert markdown-alert-" + n.Alert)
+		return fmt.Sprintf("<div class=\"%s\">%s%s</div>", class, title, r.renderAll(n.Nodes))
 	}
-	return wrap("blockquote", s)
+	return wrap("blockquote", r.renderAll(n.Nodes))
 }
 
 func (p *parse) newBlockQuote(pos Pos) *BlockQuoteNode {
 	return &BlockQuoteNode{NodeType: NodeBlockQuote, Pos: pos}
 }
 
+// NewBlockQuote returns a new block-quote that holds the given nodes.
+func NewBlockQuote(nodes ...Node) *BlockQuoteNode {
+	return &BlockQuoteNode{NodeType: NodeBlockQuote, Nodes: nodes}
+}
+
+// DetailsNode represents a collapsible details block with a summary.
+type DetailsNode struct {
+	NodeType
+	Pos
+	Summary []Node
+	Nodes   []Node
+}
+
+// Render returns the html representation of DetailsNode
+func (n *DetailsNode) Render() string {
+	return n.html(newRenderer(nil, nil))
+}
+
+func (n *DetailsNode) html(r *renderer) string {
+	if r.options.Email {
+		return wrap("div", wrap("p", wrap("strong", r.renderAll(n.Summary)))+r.renderAll(n.Nodes))
+	}
+	return wrap("details", wrap("summary", r.renderAll(n.Summary))+r.renderAll(n.Nodes))
+}
+
+func (p *parse) newDetails(pos Pos) *DetailsNode {
+	return &DetailsNode{NodeType: NodeDetails, Pos: pos}
+}
+
+// NewDetails returns a new details block with the given summary
+// that holds the given nodes.
+func NewDetails(summary []Node, nodes ...Node) *DetailsNode {
+	return &DetailsNode{NodeType: NodeDetails, Summary: summary, Nodes: nodes}
+}
+
+// DivNode represents a fenced div. its id, classes and attributes
+// are set on the emitted <div>. attributes other than data-*, title,
+// lang and dir(e.g: event handlers or style) aren't rendered.
+type DivNode struct {
+	NodeType
+	Pos
+	ID      string
+	Classes []string
+	Attrs   map[string]string
+	Nodes   []Node
+}
+
+// Render returns the html representation of DivNode
+func (n *DivNode) Render() string {
+	return n.html(newRenderer(nil, nil))
+}
+
+// the attributes are sorted by name, after the id and the class.
+// unsafe attributes are dropped.
+func (n *DivNode) html(r *renderer) string {
+	s := "<div"
+	if n.ID != "" {
+		s += fmt.Sprintf(" id=\"%s\"", r.attr(n.ID))
+	}
+	if len(n.Classes) > 0 {
+		s += fmt.Sprintf(" class=\"%s\"", r.attr(r.class(strings.Join(n.Classes, " "))))
+	}
+	names := make([]string, 0, len(n.Attrs))
+	for name := range n.Attrs {
+		if reDivSafeAttr.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s += fmt.Sprintf(" %s=\"%s\"", name, r.attr(n.Attrs[name]))
+	}
+	return s + ">" + r.renderAll(n.Nodes) + "</div>"
+}
+
+func (p *parse) newDiv(pos Pos) *DivNode {
+	return &DivNode{NodeType: NodeDiv, Pos: pos, Attrs: make(map[string]string)}
+}
+
+// NewDiv returns a new div with the given id, classes and attributes
+// that holds the given nodes.
+func NewDiv(id string, classes []string, attrs map[string]string, nodes ...Node) *DivNode {
+	return &DivNode{NodeType: NodeDiv, ID: id, Classes: classes, Attrs: attrs, Nodes: nodes}
+}
+
+// TabsNode holds a group of tabs.
+type TabsNode struct {
+	NodeType
+	Pos
+	Tabs []*TabNode
+}
+
+func (n *TabsNode) append(tab *TabNode) {
+	n.Tabs = append(n.Tabs, tab)
+}
+
+// Render returns the html representation of TabsNode
+func (n *TabsNode) Render() string {
+	return n.html(newRenderer(nil, nil))
+}
+
+func (n *TabsNode) html(r *renderer) string {
+	var titles, panels []string
+	for _, tab := range n.Tabs {
+		titles = append(titles, tab.Title)
+		panels = append(panels, r.render(tab))
+	}
+	if r.tabsFn != nil {
+		return r.tabsFn(titles, panels)
+	}
+	if r.options.Email {
+		var blocks string
+		for i, title := range titles {
+			blocks += wrap("p", wrap("strong", title)) + panels[i]
+		}
+		return wrap("div", blocks)
+	}
+	var labels string
+	for _, title := range titles {
+		labels += wrap("label", title)
+	}
+	return fmt.Sprintf("<div class=\"%s\"><div class=\"%s\">%s</div><div class=\"%s\">%s</div></div>",
+		r.class("tabbed-set"), r.class("tabbed-labels"), labels, r.class("tabbed-content"), strings.Join(panels, ""))
+}
+
+func (p *parse) newTabs(pos Pos) *TabsNode {
+	return &TabsNode{NodeType: NodeTabs, Pos: pos}
+}
+
+// NewTabs returns a new group of the given tabs.
+func NewTabs(tabs ...*TabNode) *TabsNode {
+	return &TabsNode{NodeType: NodeTabs, Tabs: tabs}
+}
+
+// TabNode represents a single tab, with its title and content.
+type TabNode struct {
+	NodeType
+	Pos
+	Title string
+	Nodes []Node
+}
+
+// Render returns the html representation of TabNode
+func (n *TabNode) Render() string {
+	return n.html(newRenderer(nil, nil))
+}
+
+func (n *TabNode) html(r *renderer) string {
+	return fmt.Sprintf("<div class=\"%s\">%s</div>", r.class("tabbed-block"), r.renderAll(n.Nodes))
+}
+
+func (p *parse) newTab(pos Pos, title string) *TabNode {
+	return &TabNode{NodeType: NodeTab, Pos: pos, Title: p.text(title)}
+}
+
+// NewTab returns a new tab with the given title that holds the given nodes.
+// the given title is html-escaped.
+func NewTab(title string, nodes ...Node) *TabNode {
+	return &TabNode{NodeType: NodeTab, Title: htmlEscaper.Replace(title), Nodes: nodes}
+}
+
 // CheckboxNode represents checked and unchecked checkbox tag.
 // Used in task lists.
 type CheckboxNode struct {
@@ -517,89 +1166,353 @@ type CheckboxNode struct {
 
 // Render returns the html representation of checked and unchecked CheckBox.
 func (n *CheckboxNode) Render() string {
+	return n.html(newRenderer(nil, nil))
+}
+
+func (n *CheckboxNode) html(r *renderer) string {
+	if r.options.Email {
+		if n.Checked {
+			return "☑ "
+		}
+		return "☐ "
+	}
 	s := "<input type=\"checkbox\""
-	if n.Checked {
+	if n.Checked && r.options.VoidStyle == VoidXHTML {
+		s += " checked=\"checked\""
+	} else if n.Checked {
 		s += " checked"
 	}
-	return s + ">"
+	return r.void(s)
 }
 
 func (p *parse) newCheckbox(pos Pos, checked bool) *CheckboxNode {
 	return &CheckboxNode{NodeType: NodeCheckbox, Pos: pos, Checked: checked}
 }
 
+// NewCheckbox returns a new checked or unchecked checkbox.
+func NewCheckbox(checked bool) *CheckboxNode {
+	return &CheckboxNode{NodeType: NodeCheckbox, Checked: checked}
+}
+
+// RubyNode represents a ruby annotation(e.g: furigana) of its base text.
+type RubyNode struct {
+	NodeType
+	Pos
+	Text  string
+	Nodes []Node
+}
+
+// Render returns the html representation of RubyNode
+func (n *RubyNode) Render() string {
+	return n.html(newRenderer(nil, nil))
+}
+
+func (n *RubyNode) html(r *renderer) string {
+	if r.options.Email {
+		return r.renderAll(n.Nodes) + "(" + n.Text + ")"
+	}
+	return wrap("ruby", r.renderAll(n.Nodes)+wrap("rt", n.Text))
+}
+
+func (p *parse) newRuby(pos Pos, text string, nodes ...Node) *RubyNode {
+	return &RubyNode{NodeType: NodeRuby, Pos: pos, Text: p.content(text), Nodes: nodes}
+}
+
+// NewRuby returns a new ruby annotation of the given nodes.
+// the given text is html-escaped.
+func NewRuby(text string, nodes ...Node) *RubyNode {
+	return &RubyNode{NodeType: NodeRuby, Text: htmlEscaper.Replace(text), Nodes: nodes}
+}
+
 // Wrap text with specific tag.
 func wrap(tag, body string) string {
 	return fmt.Sprintf("<%[1]s>%s</%[1]s>", tag, body)
 }
 
+// plainText returns the text of the given nodes, including their children.
+func plainText(nodes []Node) (s string) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *TextNode:
+			s += n.Text
+		case *EmphasisNode:
+			s += plainText(n.Nodes)
+		case *LinkNode:
+			s += plainText(n.Nodes)
+		case *RubyNode:
+			s += plainText(n.Nodes)
+		}
+	}
+	return
+}
+
 // Group all text configuration in one place(escaping, smartypants, etc..)
 func (p *parse) text(input string) string {
-	opts := p.root().options
+	return p.escapeText(p.typography(input, false), true)
+}
+
+// content is like text, but it's used for element content, where the
+// quotes are kept literal if the LiteralQuotes option is set. the Symbols
+// and Replacements options are applied only to content.
+func (p *parse) content(input string) string {
+	return p.escapeText(p.typography(input, true), !p.root().options.LiteralQuotes)
+}
+
+// href returns the escaped url of a link or an image. the text options
+// aren't applied to urls.
+func (p *parse) href(input string) string {
+	return p.escapeText(p.url(input), true)
+}
+
+// enter marks the text that is parsed until the returned function is
+// called as part of the given zone.
+func (p *parse) enter(zone Zone) func() {
+	root := p.root()
+	prev := root.zones
+	root.zones |= zone
+	return func() { root.zones = prev }
+}
+
+// typography applies the text options(Symbols, Replacements, Smartypants
+// and Fractions) to the given input, unless it's in a zone that isn't
+// enabled by Options.TypographerZones. inline html tags(and comments) are
+// kept as is, also in ZoneHTML.
+func (p *parse) typography(input string, content bool) string {
+	root := p.root()
+	opts := root.options
+	content = content && (opts.Symbols != 0 || len(opts.Replacements) > 0)
+	if !content && !opts.Smartypants && !opts.Fractions || root.zones&^opts.TypographerZones != 0 {
+		return input
+	}
+	var tags []string
+	if !opts.DisabledInlines[NodeHTML] {
+		input, tags = maskTags(input)
+	}
+	if content {
+		if opts.Symbols != 0 {
+			input = symbols(input, opts.Symbols)
+		}
+		for _, r := range opts.Replacements {
+			input = r.replace(input)
+		}
+	}
 	if opts.Smartypants {
-		input = smartypants(input)
+		input = smartypants(input, opts.Locale, opts.FullWidth)
 	}
 	if opts.Fractions {
 		input = smartyfractions(input)
 	}
-	return escape(input)
+	return unmaskTags(input, tags)
+}
+
+// escapeText escapes the given input. quotes are escaped only if quotes
+// is true.
+func (p *parse) escapeText(input string, quotes bool) string {
+	opts := p.root().options
+	s := escape(input, quotes)
+	// escape() keeps the inline html tags as is
+	if opts.DisabledInlines[NodeHTML] {
+		s = strings.NewReplacer("<", "&lt;", ">", "&gt;").Replace(s)
+	} else if opts.TagFilter {
+		s = reTagFilter.ReplaceAllString(s, "&lt;$1")
+	}
+	return s
+}
+
+// url returns the given url, or an empty string if the Sanitize option
+// is set and the url has an unsafe scheme(e.g: "javascript:").
+func (p *parse) url(s string) string {
+	if !p.root().options.Sanitize {
+		return s
+	}
+	// browsers ignore control characters and whitespaces in the scheme
+	scheme := strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, html.UnescapeString(s))
+	for _, prefix := range []string{"javascript:", "vbscript:", "file:", "data:"} {
+		if strings.HasPrefix(scheme, prefix) && !reSafeData.MatchString(scheme) {
+			return ""
+		}
+	}
+	return s
+}
+
+// shortenURL returns the text of an autolink that is longer than n
+// characters: its host and path, cut to n characters with an ellipsis.
+func shortenURL(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if u, err := url.Parse(s); err == nil && u.Host != "" {
+		s = strings.TrimPrefix(u.Host, "www.") + strings.TrimSuffix(u.Path, "/")
+	}
+	if r := []rune(s); len(r) > n {
+		s = string(r[:n-1]) + "…"
+	}
+	return s
 }
 
+// htmlEscaper escapes all special characters, used for text that built programmatically.
+var htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;", "'", "&#39;")
+
+// contentEscaper escapes element content, without the quotes(LiteralQuotes).
+var contentEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
 // Helper escaper
-func escape(str string) (cpy string) {
-	emp := regexp.MustCompile(`&\w+;`)
+func escape(str string, quotes bool) string {
+	var b strings.Builder
+	// html tags can't be matched after the last '>'
+	last := strings.LastIndexByte(str, '>')
 	for i := 0; i < len(str); i++ {
 		switch s := str[i]; s {
 		case '>':
-			cpy += "&gt;"
+			b.WriteString("&gt;")
 		case '"':
-			cpy += "&quot;"
+			if quotes {
+				b.WriteString("&quot;")
+			} else {
+				b.WriteByte('"')
+			}
 		case '\'':
-			cpy += "&#39;"
+			if quotes {
+				b.WriteString("&#39;")
+			} else {
+				b.WriteByte('\'')
+			}
 		case '<':
-			if res := reHTML.tag.FindString(str[i:]); res != "" {
-				cpy += res
+			var res string
+			if i < last {
+				res = reHTML.tag.FindString(str[i:])
+			}
+			if res != "" {
+				b.WriteString(res)
 				i += len(res) - 1
 			} else {
-				cpy += "&lt;"
+				b.WriteString("&lt;")
 			}
 		case '&':
-			if res := emp.FindString(str[i:]); res != "" {
-				cpy += res
+			if res := reEntityRef.FindString(str[i:]); res != "" {
+				b.WriteString(res)
 				i += len(res) - 1
 			} else {
-				cpy += "&amp;"
+				b.WriteString("&amp;")
 			}
 		default:
-			cpy += str[i : i+1]
+			b.WriteByte(s)
 		}
 	}
-	return
+	return b.String()
+}
+
+// quotes holds the quotation marks used by the smartypants transformation.
+type quotes struct {
+	openSingle, closeSingle, openDouble, closeDouble string
+}
+
+// Quotation marks by locale. English quotes are used for unknown locales.
+var localeQuotes = map[string]quotes{
+	"en": {"\u2018", "\u2019", "\u201c", "\u201d"},
+	"fr": {"\u2039", "\u203a", "\u00ab", "\u00bb"},
+	"de": {"\u201a", "\u2018", "\u201e", "\u201c"},
+	"ja": {"\u300e", "\u300f", "\u300c", "\u300d"},
+}
+
+// quotesFor returns the quotation marks of the given locale, and reports
+// whether the locale is known. it accepts both language tags("de") and
+// regional variants("de-AT", "de_CH").
+func quotesFor(locale string) (quotes, bool) {
+	lang := strings.ToLower(locale)
+	if i := strings.IndexAny(lang, "-_"); i != -1 {
+		lang = lang[:i]
+	}
+	if q, ok := localeQuotes[lang]; ok {
+		return q, true
+	}
+	return localeQuotes["en"], false
 }
 
 // Smartypants transformation helper, translate from marked.js
-func smartypants(text string) string {
+func smartypants(text, locale string, fullWidth bool) string {
+	q, _ := quotesFor(locale)
+	if fullWidth {
+		text = strings.NewReplacer("\uff02", "\"", "\uff07", "'").Replace(text)
+		text = fullWidthDashes(text)
+	}
 	// em-dashes, en-dashes, ellipses
 	re := strings.NewReplacer("---", "\u2014", "--", "\u2013", "...", "\u2026")
 	text = re.Replace(text)
-	// opening singles
-	text = regexp.MustCompile("(^|[-\u2014/(\\[{\"\\s])'").ReplaceAllString(text, "$1\u2018")
-	// closing singles & apostrophes
-	text = strings.Replace(text, "'", "\u2019", -1)
-	// opening doubles
-	text = regexp.MustCompile("(^|[-\u2014/(\\[{\u2018\\s])\"").ReplaceAllString(text, "$1\u201c")
-	// closing doubles
-	text = strings.Replace(text, "\"", "\u201d", -1)
-	return text
+	// apostrophes(but not quotes next to a CJK character)
+	text = reApostrophe.ReplaceAllStringFunc(text, func(s string) string {
+		first, _ := utf8.DecodeRuneInString(s)
+		last, _ := utf8.DecodeLastRuneInString(s)
+		if isCJK(first) || isCJK(last) {
+			return s
+		}
+		return strings.Replace(s, "'", "\u2019", 1)
+	})
+	// singles
+	text = smartquote(text, '\'', q.openSingle, q.closeSingle, "-\u2014/([{\"")
+	// doubles
+	return smartquote(text, '"', q.openDouble, q.closeDouble, "-\u2014/([{"+q.openSingle)
+}
+
+// smartquote replaces the quote c in text with the open or the close mark.
+// a quote is opening at the start of the text or after a space or one of the
+// given characters. CJK text is not separated by spaces, so quotes that
+// follow a CJK character alternate between opening and closing. masked
+// html tags(see maskTags) are skipped, so the quotes next to them follow
+// the text around the tags.
+func smartquote(text string, c rune, open, close, after string) string {
+	var b strings.Builder
+	b.Grow(len(text))
+	var prev rune
+	var inside bool
+	for _, r := range text {
+		if r != c {
+			b.WriteRune(r)
+			if string(r) != tagMask {
+				prev = r
+			}
+			continue
+		}
+		if prev == 0 || unicode.IsSpace(prev) || strings.ContainsRune(after, prev) || isCJK(prev) && !inside {
+			b.WriteString(open)
+			inside = true
+		} else {
+			b.WriteString(close)
+			inside = false
+		}
+		prev = r
+	}
+	return b.String()
+}
+
+// fullWidthDashes replaces the dashes("--" and "---") next to a CJK
+// character with a full-width dash(――).
+func fullWidthDashes(text string) string {
+	var b strings.Builder
+	var last int
+	for _, m := range reDashes.FindAllStringIndex(text, -1) {
+		prev, _ := utf8.DecodeLastRuneInString(text[:m[0]])
+		next, _ := utf8.DecodeRuneInString(text[m[1]:])
+		if isCJK(prev) || isCJK(next) {
+			b.WriteString(text[last:m[0]] + "\u2015\u2015")
+			last = m[1]
+		}
+	}
+	b.WriteString(text[last:])
+	return b.String()
 }
 
 // Smartyfractions transformation helper.
 func smartyfractions(text string) string {
-	re := regexp.MustCompile(`(\d+)(/\d+)(/\d+|)`)
-	return re.ReplaceAllStringFunc(text, func(str string) string {
+	return reFraction.ReplaceAllStringFunc(text, func(str string) string {
 		var match []string
 		// If it's date like
-		if match = re.FindStringSubmatch(str); match[3] != "" {
+		if match = reFraction.FindStringSubmatch(str); match[3] != "" {
 			return str
 		}
 		switch n := match[1] + match[2]; n {
@@ -612,3 +1525,84 @@ func smartyfractions(text string) string {
 		}
 	})
 }
+
+// symbolReplacements holds the replacements of each symbol. the longer
+// patterns come first, so "<->" isn't replaced as "<-", and the patterns
+// of disabled symbols are kept as is.
+var symbolReplacements = []struct {
+	symbol Symbols
+	old    []string
+	new    string
+}{
+	{SymbolLeftRightArrow, []string{"<->"}, "\u2194"},
+	{SymbolRightArrow, []string{"->"}, "\u2192"},
+	{SymbolLeftArrow, []string{"<-"}, "\u2190"},
+	{SymbolCopyright, []string{"(c)", "(C)"}, "\u00a9"},
+	{SymbolTrademark, []string{"(tm)", "(TM)", "(Tm)", "(tM)"}, "\u2122"},
+	{SymbolRegistered, []string{"(r)", "(R)"}, "\u00ae"},
+	{SymbolPlusMinus, []string{"+-"}, "\u00b1"},
+}
+
+// symbols applies the given symbol replacements to text.
+func symbols(text string, set Symbols) string {
+	var pairs []string
+	for _, r := range symbolReplacements {
+		for _, old := range r.old {
+			if set&r.symbol != 0 {
+				pairs = append(pairs, old, r.new)
+			} else {
+				pairs = append(pairs, old, old)
+			}
+		}
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// tagMask replaces the inline html tags in masked text.
+const tagMask = "\ufffc"
+
+// maskTags replaces the inline html tags(and comments) of text with
+// tagMask, so the text options don't change them, and returns the
+// replaced tags. tagMask characters of the text are replaced as well.
+func maskTags(text string) (string, []string) {
+	var b strings.Builder
+	var tags []string
+	// html tags can't be matched after the last '>'
+	last, start := strings.LastIndexByte(text, '>'), 0
+	for i := 0; i < len(text); i++ {
+		var tag string
+		switch {
+		case text[i] == '<' && i < last:
+			tag = reHTML.tag.FindString(text[i:])
+		case text[i] == tagMask[0] && strings.HasPrefix(text[i:], tagMask):
+			tag = tagMask
+		}
+		if tag != "" {
+			b.WriteString(text[start:i] + tagMask)
+			tags = append(tags, tag)
+			i += len(tag) - 1
+			start = i + 1
+		}
+	}
+	if tags == nil {
+		return text, nil
+	}
+	b.WriteString(text[start:])
+	return b.String(), tags
+}
+
+// unmaskTags restores the tags that were replaced by maskTags.
+func unmaskTags(text string, tags []string) string {
+	if len(tags) == 0 {
+		return text
+	}
+	parts := strings.SplitN(text, tagMask, len(tags)+1)
+	var b strings.Builder
+	for i, part := range parts {
+		if i > 0 {
+			b.WriteString(tags[i-1])
+		}
+		b.WriteString(part)
+	}
+	return b.String()
+}
@@ -1,50 +1,165 @@
 package mark
 
 import (
-	"regexp"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
 	"strings"
+	"time"
 	"unicode"
 	"unicode/utf8"
 )
 
 // parse holds the state of the parser.
 type parse struct {
-	Nodes     []Node
-	lex       Lexer
-	options   *Options
-	tr        *parse
-	output    string
-	peekCount int
-	token     [3]item                 // three-token lookahead for parser
-	links     map[string]*DefLinkNode // Deflink parsing, used RefLinks
-	renderFn  map[NodeType]RenderFn   // Custom overridden fns
+	Nodes       []Node
+	lex         Lexer
+	options     *Options
+	tr          *parse
+	peekCount   int
+	token       [3]item                      // three-token lookahead for parser
+	links       map[string]*DefLinkNode      // Deflink parsing, used RefLinks
+	renderFn    map[NodeType]ContextRenderFn // Custom overridden fns
+	input       string                       // Raw input, used to calculate source positions
+	line, col   int                          // Position of the input in the root input
+	spans       map[Node]Span                // Source positions of block nodes
+	headingFn   HeadingFn                    // Custom heading render fn
+	tabsFn      TabsFn                       // Custom tabs render fn
+	shortcodes  map[string]shortcode         // Shortcode handlers, by name
+	frontMatter string                       // Raw front matter of the input
+	source      string                       // Input before prepare, used by DocumentNode.ToggleTask
+	tabLines    map[int]string               // Source lines with expanded tabs, by line number, used by setSpan
+	lexTime     time.Duration                // Time spent in the lexers, used by Options.Metrics
+	depth       int                          // Nesting depth of container blocks, used by Options.MaxDepth
+	quotes      int                          // Nesting depth of blockquotes, used by BlockQuoteNode.Depth
+	zones       Zone                         // Zones of the text that is parsed, used by Options.TypographerZones
+	inHTML      bool                         // The input is the content of a markdown html block(see parseHTML)
 }
 
 // Return new parser
 func newParse(input string, opts *Options) *parse {
-	return &parse{
-		lex:      lex(input),
+	p := &parse{
+		input:    input,
+		line:     1,
+		col:      1,
 		options:  opts,
 		links:    make(map[string]*DefLinkNode),
-		renderFn: make(map[NodeType]RenderFn),
+		renderFn: make(map[NodeType]ContextRenderFn),
 	}
+	p.lex = p.wrap(lex(input, opts), false)
+	return p
+}
+
+// reset prepares the root parser for a new input. its options, render
+// functions and buffers are kept. the links and the spans are replaced
+// with new maps, since they may be shared with a returned Document.
+func (p *parse) reset(input string) {
+	// the lexer is reused, unless it's wrapped(e.g: by Options.Trace)
+	if l, ok := p.lex.(*lexer); ok {
+		l.reset(input)
+	} else {
+		p.lex = p.wrap(lex(input, p.options), false)
+	}
+	p.input, p.Nodes, p.peekCount, p.token = input, nil, 0, [3]item{}
+	p.links, p.spans = make(map[string]*DefLinkNode), nil
+	p.frontMatter, p.source, p.tabLines, p.lexTime = "", "", nil, 0
+}
+
+// wrap wraps the given lexer with a timedLexer if metrics are enabled, and
+// with a tracedLexer if tracing is enabled.
+func (p *parse) wrap(l Lexer, inline bool) Lexer {
+	root := p.root()
+	if root.options.Metrics != nil {
+		l = &timedLexer{l, &root.lexTime}
+	}
+	if root.options.Trace != nil {
+		l = &tracedLexer{l, p, inline}
+	}
+	return l
+}
+
+// tracef writes a parser decision to the trace writer, if it's enabled.
+func (p *parse) tracef(pos Pos, format string, args ...interface{}) {
+	if w := p.root().options.Trace; w != nil {
+		line, col := p.position(pos)
+		fmt.Fprintf(w, "parse %d:%d %s\n", line, col, fmt.Sprintf(format, args...))
+	}
+}
+
+// tracedLexer writes the items of the wrapped lexer to the trace writer.
+// inline items are traced with their offset in the text of the block.
+type tracedLexer struct {
+	Lexer
+	p      *parse
+	inline bool
+}
+
+func (l *tracedLexer) nextItem() item {
+	it := l.Lexer.nextItem()
+	w := l.p.root().options.Trace
+	if l.inline {
+		fmt.Fprintf(w, "inline +%d %s %q\n", it.pos, it.typ, it.val)
+	} else {
+		line, col := l.p.position(it.pos)
+		fmt.Fprintf(w, "lex %d:%d %s %q\n", line, col, it.typ, it.val)
+	}
+	return it
+}
+
+// timedLexer adds the time spent in the wrapped lexer to d.
+type timedLexer struct {
+	Lexer
+	d *time.Duration
+}
+
+func (l *timedLexer) nextItem() item {
+	start := time.Now()
+	it := l.Lexer.nextItem()
+	*l.d += time.Since(start)
+	return it
 }
 
 // parse convert the raw text to Nodeparse.
 func (p *parse) parse() {
-Loop:
+	p.parseContext(context.Background())
+}
+
+// parseContext is like parse, but it stops between blocks if the context is done.
+func (p *parse) parseContext(ctx context.Context) error {
+	if max := p.root().options.maxDepth(); max > 0 && p.depth >= max {
+		if strings.TrimSpace(p.input) != "" {
+			p.tracef(0, "depth %d exceeds MaxDepth, literal text", p.depth)
+			p.append(p.newLiteralBlock(0, Pos(len(p.input))))
+		}
+		return nil
+	}
+	for n := p.parseBlock(); n != nil; n = p.parseBlock() {
+		p.append(n)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseBlock parses the next block node. it returns nil at the end of the input.
+func (p *parse) parseBlock() Node {
 	for {
 		var n Node
-		switch t := p.peek(); t.typ {
-		case itemEOF, itemError:
-			break Loop
+		t := p.peek()
+		switch t.typ {
+		case itemEOF:
+			return nil
+		case itemError:
+			panic(fmt.Errorf("lexer error at %d: %s", t.pos, t.val))
 		case itemNewLine:
 			p.next()
 		case itemHr:
 			n = p.newHr(p.next().pos)
 		case itemHTML:
 			t = p.next()
-			n = p.newHTML(t.pos, t.val)
+			n = p.parseHTML(t)
 		case itemDefLink:
 			n = p.parseDefLink()
 		case itemHeading, itemLHeading:
@@ -57,10 +172,30 @@ Loop:
 			n = p.parseTable()
 		case itemBlockQuote:
 			n = p.parseBlockQuote()
+		case itemDetails:
+			n = p.parseDetails()
+		case itemDiv:
+			n = p.parseDiv()
+		case itemRaw:
+			t = p.next()
+			n = p.newRaw(t.pos, t.val)
+		case itemTemplate:
+			t = p.next()
+			n = p.newTemplate(t.pos, t.val)
+		case itemLiteral:
+			t = p.next()
+			tmp := p.newParagraph(t.pos)
+			tmp.Nodes = append(tmp.Nodes, p.newLiteralNode(t.pos, t.val, "unclosed code fence"))
+			n = tmp
+		case itemShortcode:
+			n = p.parseShortcode(p.next(), false)
+		case itemTabs:
+			n = p.parseTabs()
 		case itemIndent:
 			space := p.next()
 			// If it isn't followed by itemText
 			if p.peek().typ != itemText {
+				p.tracef(space.pos, "skip Indent")
 				continue
 			}
 			p.backup2(space)
@@ -72,9 +207,95 @@ Loop:
 			n = tmp
 		}
 		if n != nil {
-			p.append(n)
+			end := p.peek().pos
+			p.tracef(t.pos, "block %s", t.typ)
+			if p.root().options.DisabledBlocks[n.Type()] {
+				p.tracef(t.pos, "block %s is disabled, literal text", t.typ)
+				n = p.newLiteralBlock(t.pos, end)
+			}
+			p.setSpan(n, t.pos, end)
+			return n
+		}
+	}
+}
+
+// newLiteralBlock returns a paragraph that holds the source of the
+// block between start and end as a literal text.
+func (p *parse) newLiteralBlock(start, end Pos) *ParagraphNode {
+	if int(end) > len(p.input) {
+		end = Pos(len(p.input))
+	}
+	n := p.newParagraph(start)
+	n.Nodes = append(n.Nodes, p.newLiteral(start, strings.TrimRight(p.input[start:end], " \n")))
+	return n
+}
+
+// newSubParse returns a parser for nested blocks(e.g: list-item, blockquote).
+// pos is the position of the first character of the input in the current parser.
+func (p *parse) newSubParse(input string, pos Pos) *parse {
+	tr := &parse{input: input, tr: p, depth: p.depth + 1, quotes: p.quotes, inHTML: p.inHTML}
+	tr.line, tr.col = p.position(pos)
+	tr.lex = tr.wrap(lex(input, p.root().options), false)
+	return tr
+}
+
+// position returns the line and the column of the given offset
+// in the root input. both are 1-based.
+func (p *parse) position(pos Pos) (line, col int) {
+	if int(pos) > len(p.input) {
+		pos = Pos(len(p.input))
+	}
+	s := p.input[:pos]
+	line = p.line + strings.Count(s, "\n")
+	col = p.col + len(s) - (strings.LastIndex(s, "\n") + 1)
+	return
+}
+
+// setSpan stores the source position of the given node. the trailing
+// whitespaces between start and end aren't part of the node.
+func (p *parse) setSpan(n Node, start, end Pos) {
+	if int(end) > len(p.input) {
+		end = Pos(len(p.input))
+	}
+	for end > start && strings.ContainsAny(p.input[end-1:end], " \n") {
+		end--
+	}
+	if end <= start {
+		end = start + 1
+	}
+	root := p.root()
+	if root.spans == nil {
+		root.spans = make(map[Node]Span)
+	}
+	var span Span
+	span.StartLine, span.StartCol = p.position(start)
+	span.EndLine, span.EndCol = p.position(end - 1)
+	span.StartCol = root.sourceCol(span.StartLine, span.StartCol)
+	span.EndCol = root.sourceCol(span.EndLine, span.EndCol)
+	root.spans[n] = span
+}
+
+// sourceCol maps a column of the given line in the input to its column in
+// the source, before its tabs were expanded by prepare.
+func (p *parse) sourceCol(line, col int) int {
+	if !strings.Contains(p.source, "\t") {
+		return col
+	}
+	if p.tabLines == nil {
+		p.tabLines = make(map[int]string)
+		src, input := p.source, p.input
+		for n := 1; src != "" && input != ""; n++ {
+			a, b := firstLine(src), firstLine(input)
+			if a != b && strings.Contains(a, "\t") {
+				p.tabLines[n] = a
+			}
+			src, input = src[len(a):], input[len(b):]
 		}
 	}
+	if s, ok := p.tabLines[line]; ok {
+		return tabCol(s, col)
+	}
+	return col
 }
 
 // Root getter
@@ -85,21 +306,82 @@ func (p *parse) root() *parse {
 	return p.tr.root()
 }
 
-// Render parse nodes to the wanted output
-func (p *parse) render() {
-	var output string
-	for i, node := range p.Nodes {
-		// If there's a custom render function, use it instead.
-		if fn, ok := p.renderFn[node.Type()]; ok {
-			output = fn(node)
-		} else {
-			output = node.Render()
+// renderTo writes the given nodes to w, block by block, and returns the
+// mapping of the rendered blocks. it stops between blocks if the context is done.
+// it doesn't modify the parser, so it's safe to call it concurrently.
+func (p *parse) renderTo(ctx context.Context, w io.Writer, nodes []Node) (n int64, mappings []Mapping, err error) {
+	r := p.renderer()
+	r.ctx = ctx
+	for i, node := range nodes {
+		output, sep := r.block(node)
+		if span, ok := r.spans[node]; ok && output != "" {
+			start := int(n)
+			mappings = append(mappings, Mapping{node, span, start, start + len(output)})
+		}
+		if output != "" && i != len(nodes)-1 {
+			output += sep
+		}
+		c, err := io.WriteString(w, output)
+		n += int64(c)
+		if err != nil {
+			return n, mappings, err
+		}
+		if err := ctx.Err(); err != nil {
+			return n, mappings, err
+		}
+	}
+	return n, mappings, nil
+}
+
+// block renders a top-level node, and formats its output with the output
+// options. sep is the separator between its output and the next one.
+func (r *renderer) block(node Node) (output, sep string) {
+	output = r.render(node)
+	// a new-line after an inline element(e.g: a top-level <span>) is
+	// rendered as a space, and it's kept in compact output
+	sep = "\n"
+	if r.options.Indent != "" {
+		output = indentOutput(output, r.options.Indent)
+	} else if r.options.Compact {
+		var block bool
+		if output, block = compactOutput(output); block {
+			sep = ""
+		}
+	}
+	if r.options.Entities != EntitiesAsIs {
+		output = entityOutput(output, r.options.Entities)
+	}
+	return
+}
+
+// document returns the parsed nodes and the document-scoped data.
+func (p *parse) document() *DocumentNode {
+	doc := &DocumentNode{NodeType: NodeDocument, Nodes: p.Nodes, FrontMatter: p.frontMatter, Links: p.links, spans: p.spans, source: p.source}
+	for _, n := range Selection(p.Nodes).Select(NodeRefLink, NodeRefImage) {
+		ref := n.(*RefNode)
+		if name := strings.ToLower(ref.Ref); p.links[name] == nil && p.options.Links[name] == nil {
+			doc.Diagnostics = append(doc.Diagnostics, Diagnostic{ref.Pos, fmt.Sprintf("undefined reference %q", ref.Ref)})
 		}
-		p.output += output
-		if output != "" && i != len(p.Nodes)-1 {
-			p.output += "\n"
+	}
+	for _, n := range Selection(p.Nodes).Select(NodeDefLink) {
+		l := n.(*DefLinkNode)
+		if s, ok := p.options.Links[l.Name]; ok && p.links[l.Name] == l && (s.Href != l.Href || s.Title != l.Title) {
+			doc.Diagnostics = append(doc.Diagnostics, Diagnostic{l.Pos, fmt.Sprintf("reference %q conflicts with a shared definition", l.Name)})
 		}
 	}
+	for _, n := range Selection(p.Nodes).Select(NodeLiteral) {
+		l := n.(*LiteralNode)
+		doc.Diagnostics = append(doc.Diagnostics, Diagnostic{l.Pos, l.Reason})
+	}
+	return doc
+}
+
+// renderer returns a renderer with the parser options and render functions.
+func (p *parse) renderer() *renderer {
+	r := newRenderer(p.options, p.renderFn)
+	r.spans = p.root().spans
+	r.headingFn, r.tabsFn, r.shortcodes = p.headingFn, p.tabsFn, p.shortcodes
+	return r
 }
 
 // append new node to nodes-list
@@ -142,44 +424,63 @@ func (p *parse) backup2(t1 item) {
 // parseText
 func (p *parse) parseText(input string) (nodes []Node) {
 	// Trim whitespaces that not a line-break
-	input = regexp.MustCompile(`(?m)^ +| +(\n|$)`).ReplaceAllStringFunc(input, func(s string) string {
+	input = reTrimSpaces.ReplaceAllStringFunc(input, func(s string) string {
 		if reBr.MatchString(s) {
 			return s
 		}
 		return strings.Replace(s, " ", "", -1)
 	})
-	l := lexInline(input)
-	for token := range l.items {
+	if p.root().options.JoinCJKLines {
+		input = joinCJKLines(input)
+	}
+	l := p.wrap(lexInline(input, p.root().options), true)
+	for token := l.nextItem(); token.typ != itemEOF; token = l.nextItem() {
 		var node Node
 		switch token.typ {
+		case itemError:
+			panic(fmt.Errorf("inline lexer error at %d: %s", token.pos, token.val))
 		case itemBr:
 			node = p.newBr(token.pos)
 		case itemStrong, itemItalic, itemStrike, itemCode:
+			// code spans aren't nested, the backticks inside them are text
+			if token.typ == itemCode && p.root().zones&ZoneCode != 0 {
+				node = p.newText(token.pos, token.val)
+				break
+			}
 			node = p.parseEmphasis(token.typ, token.pos, token.val)
 		case itemLink, itemAutoLink, itemGfmLink:
 			var title, href string
 			var text []Node
 			if token.typ == itemLink {
-				match := reLink.FindStringSubmatch(token.val)
-				text = p.parseText(match[1])
-				href, title = match[2], match[3]
+				src, dest := splitLink(token.val)
+				match := reLinkDest.FindStringSubmatch(dest)
+				text = p.parseText(src)
+				href, title = match[1], match[2]
 			} else {
-				var match []string
 				if token.typ == itemGfmLink {
-					match = reGfmLink.FindStringSubmatch(token.val)
+					href = token.val
 				} else {
-					match = reAutoLink.FindStringSubmatch(token.val)
+					href = reAutoLink.FindStringSubmatch(token.val)[1]
 				}
-				href = match[1]
-				text = append(text, p.newText(token.pos, match[1]))
+				exit := p.enter(ZoneURLs)
+				if n := p.root().options.AutolinkLength; n > 0 {
+					text = append(text, p.newText(token.pos, shortenURL(href, n)))
+				} else {
+					text = append(text, p.newText(token.pos, href))
+				}
+				exit()
 			}
 			node = p.newLink(token.pos, title, href, text...)
 		case itemImage:
-			match := reImage.FindStringSubmatch(token.val)
-			node = p.newImage(token.pos, match[3], match[2], match[1])
+			alt, dest := splitLink(token.val)
+			match := reLinkDest.FindStringSubmatch(dest)
+			node = p.newImage(token.pos, match[2], match[1], alt)
 		case itemRefLink, itemRefImage:
-			match := reRefLink.FindStringSubmatch(token.val)
-			text, ref := match[1], match[2]
+			text, label := splitLink(token.val)
+			var ref string
+			if match := reRefLabel.FindStringSubmatch(label); match != nil {
+				ref = match[1]
+			}
 			if ref == "" {
 				ref = text
 			}
@@ -189,30 +490,121 @@ func (p *parse) parseText(input string) (nodes []Node) {
 				node = p.newRefImage(token.typ, token.pos, token.val, ref, text)
 			}
 		case itemHTML:
-			node = p.newHTML(token.pos, token.val)
+			exit := p.enter(ZoneHTML)
+			node = p.newHTML(token.pos, p.typography(token.val, true))
+			exit()
+		case itemTemplate:
+			node = p.newTemplate(token.pos, token.val)
+		case itemRuby:
+			if !p.root().options.Ruby {
+				nodes = append(nodes, p.parseRubyText(token.val)...)
+				continue
+			}
+			match := reRuby.FindStringSubmatch(token.val)
+			base, text := match[1]+match[3], match[2]+match[4]
+			node = p.newRuby(token.pos, text, p.parseText(base)...)
+		case itemShortcode:
+			node = p.parseShortcode(token, true)
+		case itemCustomInline:
+			node = p.newCustomInline(token.pos, token.val)
+		case itemEmoji:
+			name := reEmoji.FindStringSubmatch(token.val)[1]
+			if src, ok := p.root().options.Emoji[name]; ok {
+				node = p.newEmoji(token.pos, name, src)
+			} else {
+				node = p.newText(token.pos, token.val)
+			}
 		default:
+			if p.root().options.HardWrap && strings.Contains(token.val, "\n") {
+				nodes = append(nodes, p.parseLines(token)...)
+				continue
+			}
 			node = p.newText(token.pos, token.val)
 		}
+		if p.root().options.DisabledInlines[node.Type()] {
+			node = p.newLiteral(token.pos, token.val)
+		}
 		nodes = append(nodes, node)
 	}
 	return nodes
 }
 
+// joinCJKLines removes the soft line breaks between two CJK characters.
+func joinCJKLines(input string) (s string) {
+	lines := strings.Split(input, "\n")
+	for i, line := range lines {
+		if i > 0 {
+			prev, _ := utf8.DecodeLastRuneInString(lines[i-1])
+			next, _ := utf8.DecodeRuneInString(line)
+			if !isCJK(prev) || !isCJK(next) {
+				s += "\n"
+			}
+		}
+		s += line
+	}
+	return
+}
+
+// isCJK tests if the given rune is a Chinese or Japanese character,
+// including the CJK and the full-width punctuation.
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana) ||
+		r >= 0x3000 && r <= 0x303F || r >= 0xFF00 && r <= 0xFFEF
+}
+
+// parseLines returns the lines of the given text separated by line-breaks,
+// used when the HardWrap option is set.
+func (p *parse) parseLines(token item) (nodes []Node) {
+	for i, line := range strings.Split(token.val, "\n") {
+		if i > 0 {
+			nodes = append(nodes, p.newBr(token.pos))
+		}
+		if line != "" {
+			nodes = append(nodes, p.newText(token.pos, line))
+		}
+	}
+	return
+}
+
+// parseRubyText parses a ruby annotation as a regular text, used
+// when the Ruby option is off.
+func (p *parse) parseRubyText(val string) []Node {
+	i := 1
+	if val[0] == '[' {
+		i = strings.Index(val, "]{") + 1
+	}
+	return append(p.parseText(val[:i]), p.parseText(val[i:])...)
+}
+
 // parse inline emphasis
 func (p *parse) parseEmphasis(typ itemType, pos Pos, val string) *EmphasisNode {
-	var re *regexp.Regexp
+	var match []string
 	switch typ {
-	case itemStrike:
-		re = reStrike
+	// the lexer may extend underscore emphasis past the first closing
+	// delimiter, so the delimiters are trimmed instead of matched.
 	case itemStrong:
-		re = reStrong
-	case itemCode:
-		re = reCode
+		match = []string{val, val[2 : len(val)-2]}
 	case itemItalic:
-		re = reItalic
+		match = []string{val, val[1 : len(val)-1]}
+	case itemStrike:
+		match = reStrike.FindStringSubmatch(val)
+	case itemCode:
+		// spans that are closed by a run of the same length keep the
+		// backticks inside them.
+		n := len(val) - len(strings.TrimLeft(val, "`"))
+		if len(val) > 2*n && val[len(val)-n-1] != '`' && strings.Count(val[len(val)-n:], "`") == n && strings.TrimSpace(val[n:len(val)-n]) != "" {
+			match = []string{val, strings.TrimSpace(val[n : len(val)-n])}
+		} else {
+			match = reCode.FindStringSubmatch(val)
+		}
+	}
+	if typ == itemCode {
+		defer p.enter(ZoneCode)()
 	}
 	node := p.newEmphasis(pos, typ)
-	match := re.FindStringSubmatch(val)
+	if typ == itemStrong || typ == itemItalic {
+		node.Delim = val[:(len(val)-len(match[1]))/2]
+	}
 	text := match[len(match)-1]
 	if text == "" {
 		text = match[1]
@@ -224,12 +616,13 @@ func (p *parse) parseEmphasis(typ itemType, pos Pos, val string) *EmphasisNode {
 // parse heading block
 func (p *parse) parseHeading() (node *HeadingNode) {
 	token := p.next()
-	level := 1
+	level, setext := 1, false
 	var text string
 	if token.typ == itemHeading {
 		match := reHeading.FindStringSubmatch(token.val)
 		level, text = len(match[1]), match[2]
 	} else {
+		setext = true
 		match := reLHeading.FindStringSubmatch(token.val)
 		// using equal signs for first-level, and dashes for second-level.
 		text = match[1]
@@ -238,6 +631,7 @@ func (p *parse) parseHeading() (node *HeadingNode) {
 		}
 	}
 	node = p.newHeading(token.pos, level, text)
+	node.Setext = setext
 	node.Nodes = p.parseText(text)
 	return
 }
@@ -258,40 +652,191 @@ func (p *parse) parseDefLink() *DefLinkNode {
 
 // parse codeBlock
 func (p *parse) parseCodeBlock() *CodeNode {
-	var lang, text string
+	var lang, text, fence string
 	token := p.next()
 	if token.typ == itemGfmCodeBlock {
 		codeStart := reGfmCode.FindStringSubmatch(token.val)
-		lang = codeStart[3]
+		lang, fence = codeStart[3], codeStart[2]
 		text = token.val[len(codeStart[0]):]
 	} else {
 		text = reCodeBlock.trim(token.val, "")
 	}
-	return p.newCode(token.pos, lang, text)
+	if w := p.root().options.TabWidth; w > 0 {
+		text = expandTabs(text, w)
+	}
+	code := p.newCode(token.pos, lang, text)
+	code.Fence, code.Indented = fence, fence == ""
+	return code
 }
 
 func (p *parse) parseBlockQuote() (n *BlockQuoteNode) {
 	token := p.next()
 	// replacer
-	re := regexp.MustCompile(`(?m)^ *> ?`)
+	re := reQuotePrefix
 	raw := re.ReplaceAllString(token.val, "")
+	n = p.newBlockQuote(token.pos)
+	n.Depth = p.quotes + 1
+	if m := reAlert.FindStringSubmatch(raw); m != nil && p.root().options.Alerts {
+		n.Alert = strings.ToLower(m[1])
+		raw = raw[len(m[0]):]
+	}
 	// TODO(a8m): doesn't work right now with defLink(inside the blockQuote)
-	tr := &parse{lex: lex(raw), tr: p}
+	tr := p.newSubParse(raw, token.pos+Pos(len(re.FindString(token.val))))
+	tr.quotes = n.Depth
 	tr.parse()
-	n = p.newBlockQuote(token.pos)
 	n.Nodes = tr.Nodes
 	return
 }
 
+// parse html block. the content of blocks whose opening tag has a
+// markdown="1" attribute is parsed as markdown, if MarkdownInHTML is set.
+// the blocks it holds are parsed the same way, and the markdown="1"
+// attributes of the other html it holds are removed.
+func (p *parse) parseHTML(token item) *HTMLNode {
+	n := p.newHTML(token.pos, token.val)
+	m := reHTML.item.FindStringSubmatch(token.val)
+	if !p.root().options.MarkdownInHTML || m == nil || !reMarkdownAttr.MatchString(m[0]) {
+		return n
+	}
+	inner := token.val[len(m[0]):]
+	end := strings.LastIndex(inner, "</"+m[1])
+	if end == -1 {
+		return n
+	}
+	n.Open, n.Close = m[0], strings.TrimSpace(inner[end:])
+	// the content usually starts on the next line
+	start := 0
+	if strings.HasPrefix(inner, "\n") {
+		start = 1
+	}
+	tr := p.newSubParse(inner[start:end], token.pos+Pos(len(m[0])+start))
+	tr.inHTML = true
+	tr.parse()
+	n.Nodes = tr.Nodes
+	return n
+}
+
+// parse details block
+func (p *parse) parseDetails() *DetailsNode {
+	token := p.next()
+	lines := strings.SplitAfter(strings.TrimRight(token.val, "\n"), "\n")
+	n := p.newDetails(token.pos)
+	n.Summary = p.parseText(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(lines[0]), ":::details")))
+	if last := len(lines) - 1; last > 0 && closesContainer(lines[last]) {
+		lines = lines[:last]
+	}
+	tr := p.newSubParse(strings.Join(lines[1:], ""), token.pos+Pos(len(lines[0])))
+	tr.parse()
+	n.Nodes = tr.Nodes
+	return n
+}
+
+// parse fenced div. the attributes of its opening fence are split into
+// the id, the classes and the rest of the attributes.
+func (p *parse) parseDiv() *DivNode {
+	token := p.next()
+	lines := strings.SplitAfter(strings.TrimRight(token.val, "\n"), "\n")
+	n := p.newDiv(token.pos)
+	m := reDiv.FindStringSubmatch(lines[0])
+	if m[2] != "" {
+		n.Classes = append(n.Classes, m[2])
+	}
+	for _, attr := range reDivAttr.FindAllStringSubmatch(m[1], -1) {
+		key, value := attr[3], attr[4]
+		if len(value) > 1 && (value[0] == '"' || value[0] == '\'') {
+			value = value[1 : len(value)-1]
+		}
+		switch {
+		case attr[1] == "#":
+			n.ID = attr[2]
+		case attr[1] == ".":
+			n.Classes = append(n.Classes, attr[2])
+		case key == "id":
+			n.ID = value
+		case key == "class":
+			n.Classes = append(n.Classes, strings.Fields(value)...)
+		default:
+			n.Attrs[key] = value
+		}
+	}
+	if last := len(lines) - 1; last > 0 && closesContainer(lines[last]) {
+		lines = lines[:last]
+	}
+	tr := p.newSubParse(strings.Join(lines[1:], ""), token.pos+Pos(len(lines[0])))
+	tr.parse()
+	n.Nodes = tr.Nodes
+	return n
+}
+
+// parse shortcode. the inner content of block shortcodes is parsed as
+// blocks, and the inner content of inline shortcodes as text.
+func (p *parse) parseShortcode(token item, inline bool) *ShortcodeNode {
+	m := reShortcode.FindStringSubmatch(token.val)
+	n := p.newShortcode(token.pos, m[3], token.val)
+	pos := 0
+	for _, arg := range reShortcodeArg.FindAllStringSubmatch(strings.TrimSuffix(m[4], "/"), -1) {
+		key, value := arg[1], arg[2]
+		if key == "" {
+			key = strconv.Itoa(pos)
+			pos++
+		}
+		if len(value) > 1 && value[0] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		n.Args[key] = value
+	}
+	inner := token.val[len(m[0]):]
+	if loc := shortcodeEnd(inner, m[3]); loc != nil {
+		n.Inner = inner[:loc[0]]
+	}
+	if inline {
+		n.Nodes = p.parseText(n.Inner)
+	} else {
+		tr := p.newSubParse(n.Inner, token.pos+Pos(len(m[0])))
+		tr.parse()
+		n.Nodes = tr.Nodes
+	}
+	return n
+}
+
+// parse group of tabs
+func (p *parse) parseTabs() *TabsNode {
+	token := p.next()
+	tabs := p.newTabs(token.pos)
+	for s, pos := token.val, token.pos; ; {
+		m := reTab.FindStringSubmatch(s)
+		if m == nil {
+			break
+		}
+		tab := p.newTab(pos, m[1])
+		start := pos + Pos(len(m[0])-len(m[2]))
+		tr := p.newSubParse(trimIndent(m[2], 4), start)
+		tr.parse()
+		tab.Nodes = tr.Nodes
+		tabs.append(tab)
+		p.setSpan(tab, pos, start+Pos(len(m[2])))
+		rest := strings.TrimLeft(s[len(m[0]):], " \n")
+		pos += Pos(len(s) - len(rest))
+		s = rest
+	}
+	return tabs
+}
+
 // parse list
 func (p *parse) parseList() *ListNode {
 	token := p.next()
-	list := p.newList(token.pos, isDigit(token.val))
+	start, _ := strconv.Atoi(strings.TrimSuffix(token.val, "."))
+	list := p.newList(token.pos, isDigit(token.val), start)
+	if !list.Ordered {
+		list.Bullet = token.val
+	}
 Loop:
 	for {
 		switch token = p.peek(); token.typ {
 		case itemLooseItem, itemListItem:
-			list.append(p.parseListItem())
+			item := p.parseListItem()
+			list.append(item)
+			p.setSpan(item, token.pos, p.peek().pos)
 		default:
 			break Loop
 		}
@@ -304,11 +849,25 @@ func (p *parse) parseListItem() *ListItemNode {
 	token := p.next()
 	item := p.newListItem(token.pos)
 	token.val = strings.TrimSpace(token.val)
+	start := token.pos + Pos(len(reList.marker.FindString(p.input[token.pos:])))
+	// task items hold a checkbox followed by the text of their first line.
+	// the rest of the lines(e.g: nested lists) are parsed as blocks, and the
+	// first line is replaced with a new-line to keep their positions.
 	if p.isTaskItem(token.val) {
-		item.Nodes = p.parseTaskItem(token)
-		return item
+		line := token.val
+		if i := strings.IndexByte(line, '\n'); i != -1 {
+			line = line[:i]
+		}
+		item.append(p.newCheckbox(token.pos, token.val[1] == 'x'))
+		item.Nodes = append(item.Nodes, p.parseText(strings.TrimSpace(line[3:]))...)
+		token.val = token.val[len(line):]
+	}
+	tr := p.newSubParse(token.val, start)
+	if p.root().options.NoListCode {
+		l := lex(token.val, p.root().options)
+		l.noCode = true
+		tr.lex = tr.wrap(l, false)
 	}
-	tr := &parse{lex: lex(token.val), tr: p}
 	tr.parse()
 	for _, node := range tr.Nodes {
 		// wrap with paragraph only when it's a loose item
@@ -321,13 +880,6 @@ func (p *parse) parseListItem() *ListItemNode {
 	return item
 }
 
-// parseTaskItem parses list item as a task item.
-func (p *parse) parseTaskItem(token item) []Node {
-	checkbox := p.newCheckbox(token.pos, token.val[1] == 'x')
-	token.val = strings.TrimSpace(token.val[3:])
-	return append([]Node{checkbox}, p.parseText(token.val)...)
-}
-
 // isTaskItem tests if the given string is list task item.
 func (p *parse) isTaskItem(s string) bool {
 	if len(s) < 5 || s[0] != '[' || (s[1] != 'x' && s[1] != ' ') || s[2] != ']' {
@@ -375,12 +927,67 @@ Loop:
 	// Tranform to nodes
 	table.append(p.parseCells(Header, rows.Header, rows.Align))
 	// Table body
-	for _, row := range rows.Cells {
-		table.append(p.parseCells(Data, row, rows.Align))
+	if p.root().options.MultilineTables {
+		rows.Cells = joinRows(rows.Cells)
+	}
+	// Data rows are padded or truncated to the number of header cells.
+	// with LiteralErrors, the extra cells are kept as literal text.
+	extra := make(map[int]*LiteralNode)
+	for i, row := range rows.Cells {
+		if len(row) > len(rows.Header) && len(rows.Header) > 0 {
+			if p.root().options.LiteralErrors {
+				var src string
+				for _, cell := range row[len(rows.Header):] {
+					src += " | " + cell.val
+				}
+				reason := fmt.Sprintf("table row has %d cells, expected %d", len(row), len(rows.Header))
+				extra[i] = p.newLiteralNode(table.Pos, src, reason)
+			}
+			row = row[:len(rows.Header)]
+		}
+		for len(row) < len(rows.Header) {
+			row = append(row, item{itemTableCell, rows.Header[len(row)].pos, ""})
+		}
+		rows.Cells[i] = row
+	}
+	for i, row := range rows.Cells {
+		r := p.parseCells(Data, row, rows.Align)
+		if l, ok := extra[i]; ok {
+			last := r.Cells[len(r.Cells)-1]
+			last.Nodes = append(last.Nodes, l)
+		}
+		table.append(r)
 	}
 	return table
 }
 
+// joinRows joins rows that end with a backslash cell("| a | b |\") with
+// the row that follows them, cell by cell, separated by a new-line.
+func joinRows(rows [][]item) (res [][]item) {
+	var joined bool
+	for _, row := range rows {
+		var next bool
+		if n := len(row); n > 0 && row[n-1].val == "\\" {
+			row, next = row[:n-1], true
+		}
+		if joined {
+			last := res[len(res)-1]
+			for i, cell := range row {
+				if i < len(last) {
+					last[i].val += "\n" + cell.val
+				} else {
+					last = append(last, cell)
+				}
+			}
+			res[len(res)-1] = last
+		} else {
+			res = append(res, row)
+		}
+		joined = next
+	}
+	return
+}
+
 // parse cells and return new row
 func (p *parse) parseCells(kind int, items []item, align []AlignType) *RowNode {
 	var row *RowNode
@@ -388,13 +995,35 @@ func (p *parse) parseCells(kind int, items []item, align []AlignType) *RowNode {
 		if i == 0 {
 			row = p.newRow(item.pos)
 		}
-		cell := p.newCell(item.pos, kind, align[i])
-		cell.Nodes = p.parseText(item.val)
+		// Rows may have more cells than the alignment row
+		var typ AlignType
+		if i < len(align) {
+			typ = align[i]
+		}
+		cell := p.newCell(item.pos, kind, typ)
+		if p.root().options.MultilineTables {
+			cell.Nodes = p.parseCellBlocks(item)
+		} else {
+			cell.Nodes = p.parseText(item.val)
+		}
 		row.append(cell)
 	}
 	return row
 }
 
+// parseCellBlocks parses the cell content as blocks. a cell that contains
+// only one paragraph is parsed as inline text.
+func (p *parse) parseCellBlocks(cell item) []Node {
+	tr := p.newSubParse(strings.TrimSpace(cell.val), cell.pos)
+	tr.parse()
+	if len(tr.Nodes) == 1 {
+		if n, ok := tr.Nodes[0].(*ParagraphNode); ok {
+			return n.Nodes
+		}
+	}
+	return tr.Nodes
+}
+
 // Used to consume lines(itemText) for a continues paragraphs
 func (p *parse) scanLines() (s string) {
 	for {
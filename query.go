@@ -0,0 +1,222 @@
+package mark
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Selection is a list of nodes returned by a query.
+type Selection []Node
+
+// Children returns the direct children of the given node.
+func Children(n Node) (nodes []Node) {
+	switch n := n.(type) {
+	case *ParagraphNode:
+		return n.Nodes
+	case *EmphasisNode:
+		return n.Nodes
+	case *HeadingNode:
+		return n.Nodes
+	case *LinkNode:
+		return n.Nodes
+	case *RefNode:
+		return n.Nodes
+	case *RubyNode:
+		return n.Nodes
+	case *TabNode:
+		return n.Nodes
+	case *TabsNode:
+		for _, tab := range n.Tabs {
+			nodes = append(nodes, tab)
+		}
+	case *DocumentNode:
+		return n.Nodes
+	case *HTMLNode:
+		return n.Nodes
+	case *ShortcodeNode:
+		return n.Nodes
+	case *DivNode:
+		return n.Nodes
+	case *DetailsNode:
+		return append(append([]Node{}, n.Summary...), n.Nodes...)
+	case *ListItemNode:
+		return n.Nodes
+	case *CellNode:
+		return n.Nodes
+	case *BlockQuoteNode:
+		return n.Nodes
+	case *ListNode:
+		for _, item := range n.Items {
+			nodes = append(nodes, item)
+		}
+	case *TableNode:
+		for _, row := range n.Rows {
+			nodes = append(nodes, row)
+		}
+	case *RowNode:
+		for _, cell := range n.Cells {
+			nodes = append(nodes, cell)
+		}
+	}
+	return
+}
+
+// Walk traverses the given node and its descendants in depth-first order.
+// if fn returns false, the children of the current node are skipped.
+func Walk(n Node, fn func(Node) bool) {
+	if !fn(n) {
+		return
+	}
+	for _, child := range Children(n) {
+		Walk(child, fn)
+	}
+}
+
+// Find returns all nodes in the document that match the given function.
+func (d *DocumentNode) Find(fn func(Node) bool) Selection {
+	return Selection(d.Nodes).Find(fn)
+}
+
+// Select returns all nodes in the document with the given types.
+func (d *DocumentNode) Select(types ...NodeType) Selection {
+	return Selection(d.Nodes).Select(types...)
+}
+
+// Find returns all nodes in the selection(including descendants)
+// that match the given function.
+func (s Selection) Find(fn func(Node) bool) (res Selection) {
+	for _, n := range s {
+		Walk(n, func(n Node) bool {
+			if fn(n) {
+				res = append(res, n)
+			}
+			return true
+		})
+	}
+	return
+}
+
+// Select returns all nodes in the selection(including descendants)
+// with the given types.
+func (s Selection) Select(types ...NodeType) Selection {
+	return s.Find(func(n Node) bool {
+		for _, typ := range types {
+			if n.Type() == typ {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Filter returns the nodes in the selection that match the given function.
+// unlike Find, it doesn't look into descendants.
+func (s Selection) Filter(fn func(Node) bool) (res Selection) {
+	for _, n := range s {
+		if fn(n) {
+			res = append(res, n)
+		}
+	}
+	return
+}
+
+// WithLevel returns the headings in the selection with one of the given levels.
+func (s Selection) WithLevel(levels ...int) Selection {
+	return s.Filter(func(n Node) bool {
+		if h, ok := n.(*HeadingNode); ok {
+			for _, level := range levels {
+				if h.Level == level {
+					return true
+				}
+			}
+		}
+		return false
+	})
+}
+
+// First returns the first node in the selection, or nil if it's empty.
+func (s Selection) First() Node {
+	if len(s) == 0 {
+		return nil
+	}
+	return s[0]
+}
+
+// Task is a task list item of a document.
+type Task struct {
+	Item     *ListItemNode
+	Checkbox *CheckboxNode
+	Span     Span // source position of the item, if it was parsed
+}
+
+// Checked reports whether the task is checked.
+func (t Task) Checked() bool {
+	return t.Checkbox.Checked
+}
+
+// Tasks returns the task list items of the document, in document order.
+func (d *DocumentNode) Tasks() (tasks []Task) {
+	for _, n := range d.Select(NodeListItem) {
+		item := n.(*ListItemNode)
+		if len(item.Nodes) == 0 {
+			continue
+		}
+		if c, ok := item.Nodes[0].(*CheckboxNode); ok {
+			tasks = append(tasks, Task{item, c, d.spans[item]})
+		}
+	}
+	return
+}
+
+// ToggleTask flips the checked state of the i-th task of the document, and
+// returns the markdown of the updated document. if the document was parsed,
+// only the checkbox of the task is changed in its input, that is kept as is
+// otherwise. documents that were built programmatically are serialized
+// using Markdown.
+func (d *DocumentNode) ToggleTask(i int) (string, error) {
+	tasks := d.Tasks()
+	if i < 0 || i >= len(tasks) {
+		return "", fmt.Errorf("mark: task %d out of range [0, %d)", i, len(tasks))
+	}
+	tasks[i].Checkbox.Checked = !tasks[i].Checkbox.Checked
+	if src, ok := d.toggleSource(tasks[i]); ok {
+		d.source = src
+		return src, nil
+	}
+	return d.Markdown(), nil
+}
+
+// toggleSource returns the input of the document with the checkbox of the
+// given task set to its checked state. it reports false if the checkbox
+// isn't found at the position of the task.
+func (d *DocumentNode) toggleSource(t Task) (string, bool) {
+	if d.source == "" || t.Span.StartLine == 0 {
+		return "", false
+	}
+	start := 0
+	for n := 1; n < t.Span.StartLine; n++ {
+		i := strings.IndexByte(d.source[start:], '\n')
+		if i == -1 {
+			return "", false
+		}
+		start += i + 1
+	}
+	line := firstLine(d.source[start:])
+	i := t.Span.StartCol - 1
+	if i < 0 || i >= len(line) {
+		return "", false
+	}
+	// the checkbox follows the list marker
+	j := strings.IndexByte(line[i:], '[')
+	if j == -1 || strings.Trim(line[i:i+j], "-*+.)0123456789 \t") != "" {
+		return "", false
+	}
+	if i += j; i+3 > len(line) || !strings.ContainsRune(" xX", rune(line[i+1])) || line[i+2] != ']' {
+		return "", false
+	}
+	mark := " "
+	if t.Checkbox.Checked {
+		mark = "x"
+	}
+	return d.source[:start+i+1] + mark + d.source[start+i+2:], true
+}
@@ -0,0 +1,118 @@
+package mark
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelect(t *testing.T) {
+	doc := Parse("# One\n## Two\ntext [a](a)\n\n- [b](b)\n- c\n\n> ## Three\n\n|[c](c)|d|\n|-|-|\n|e|f|", nil)
+	headings := doc.Select(NodeHeading).WithLevel(2)
+	if len(headings) != 2 {
+		t.Fatalf("Select: got %d headings, expected 2", len(headings))
+	}
+	for i, text := range []string{"Two", "Three"} {
+		if h := headings[i].(*HeadingNode); h.Text != text {
+			t.Errorf("Select: got heading %q, expected %q", h.Text, text)
+		}
+	}
+	var hrefs []string
+	for _, n := range doc.Select(NodeLink) {
+		hrefs = append(hrefs, n.(*LinkNode).Href)
+	}
+	if len(hrefs) != 3 || hrefs[0] != "a" || hrefs[1] != "b" || hrefs[2] != "c" {
+		t.Errorf("Select: got links %v, expected [a b c]", hrefs)
+	}
+	if n := doc.Select(NodeImage).First(); n != nil {
+		t.Errorf("First: got %v, expected nil", n)
+	}
+}
+
+func TestFind(t *testing.T) {
+	doc := Parse("foo __bar__ baz\n\n- _bar_", nil)
+	nodes := doc.Find(func(n Node) bool {
+		text, ok := n.(*TextNode)
+		return ok && text.Text == "bar"
+	})
+	if len(nodes) != 2 {
+		t.Errorf("Find: got %d nodes, expected 2", len(nodes))
+	}
+	var count int
+	Walk(doc.Nodes[0], func(n Node) bool {
+		count++
+		return n.Type() != NodeEmphasis
+	})
+	// paragraph, 2 texts and emphasis(without its child)
+	if count != 4 {
+		t.Errorf("Walk: got %d nodes, expected 4", count)
+	}
+}
+
+func TestClone(t *testing.T) {
+	input := "# Title\n\nfoo __bar__ [baz](x)\n\n- [ ] a\n- b\n\n> q\n\n|a|b|\n|-|-|\n|c|d|"
+	doc := Parse(input, nil)
+	cp := doc.Clone()
+	for _, n := range cp.Select(NodeText) {
+		n.(*TextNode).Text = "x"
+	}
+	cp.Select(NodeCheckbox).First().(*CheckboxNode).Checked = true
+	if actual, expected := FromDocument(doc, nil).Render(), Render(input); actual != expected {
+		t.Errorf("Clone: original was modified, got\n\t%+v\nexpected\n\t%+v", actual, expected)
+	}
+	if actual := FromDocument(cp, nil).Render(); !strings.Contains(actual, "<h1 id=\"title\">x</h1>") {
+		t.Errorf("Clone: got\n\t%+v\nexpected modified heading", actual)
+	}
+}
+
+func TestTasks(t *testing.T) {
+	doc := Parse("# Todo\n\n- [ ] one\n- [x] two\n    - [ ] nested\n- plain", nil)
+	tasks := doc.Tasks()
+	expected := []struct {
+		checked bool
+		span    string
+	}{
+		{false, "3:1-3:9"},
+		{true, "4:1-5:16"},
+		{false, "5:5-5:16"},
+	}
+	if len(tasks) != len(expected) {
+		t.Fatalf("Tasks: got %d tasks, expected %d", len(tasks), len(expected))
+	}
+	for i, e := range expected {
+		if tasks[i].Checked() != e.checked || tasks[i].Span.String() != e.span {
+			t.Errorf("task %d: got checked=%v span=%s, expected checked=%v span=%s", i, tasks[i].Checked(), tasks[i].Span, e.checked, e.span)
+		}
+	}
+	md, err := doc.ToggleTask(0)
+	if want := "# Todo\n\n- [x] one\n- [x] two\n    - [ ] nested\n- plain"; err != nil || md != want {
+		t.Errorf("ToggleTask: got\n%s(%v)\nexpected\n%s", md, err, want)
+	}
+	md, err = doc.ToggleTask(2)
+	if want := "# Todo\n\n- [x] one\n- [x] two\n    - [x] nested\n- plain"; err != nil || md != want {
+		t.Errorf("ToggleTask: got\n%s(%v)\nexpected\n%s", md, err, want)
+	}
+	if _, err := doc.ToggleTask(3); err == nil {
+		t.Error("ToggleTask: expected an error for out of range task")
+	}
+	// only the checkbox is changed in the input
+	cases := []struct {
+		input    string
+		task     int
+		expected string
+	}{
+		{"> - [ ] a *b*\n> 1.  [x]  c", 1, "> - [ ] a *b*\n> 1.  [ ]  c"},
+		{"-\t[ ] a\n\t- [ ] b\n", 1, "-\t[ ] a\n\t- [x] b\n"},
+		{"---\ntitle: x\n---\n\n+ [ ] a", 0, "---\ntitle: x\n---\n\n+ [x] a"},
+	}
+	for _, c := range cases {
+		md, err := Parse(c.input, &Options{FrontMatter: true}).ToggleTask(c.task)
+		if err != nil || md != c.expected {
+			t.Errorf("%q: got\n\t%q(%v)\nexpected\n\t%q", c.input, md, err, c.expected)
+		}
+	}
+	// documents without input are serialized
+	built := NewDocument(NewList(false, NewListItem(NewCheckbox(false), NewText("a"))))
+	if md, err := built.ToggleTask(0); err != nil || md != "- [x] a" {
+		t.Errorf("ToggleTask: got\n%s(%v)\nexpected\n%s", md, err, "- [x] a")
+	}
+}
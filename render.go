@@ -0,0 +1,259 @@
+package mark
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// renderer holds the options and the render functions that used while
+// rendering a tree of nodes, so they apply to nested nodes as well.
+type renderer struct {
+	options  *Options
+	renderFn map[NodeType]ContextRenderFn
+	spans    map[Node]Span
+	ctx      context.Context // passed to the ContextRenderFns
+
+	headingFn  HeadingFn
+	tabsFn     TabsFn
+	shortcodes map[string]shortcode
+}
+
+// htmlNode is implemented by nodes that render their children.
+type htmlNode interface {
+	html(r *renderer) string
+}
+
+// Return new renderer. nil options means the default options.
+func newRenderer(opts *Options, fns map[NodeType]ContextRenderFn) *renderer {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	return &renderer{options: opts, renderFn: fns, ctx: context.Background()}
+}
+
+// render returns the html representation of the given node.
+// if there's a custom render function for its type, use it instead.
+func (r *renderer) render(n Node) (s string) {
+	if fn, ok := r.renderFn[n.Type()]; ok {
+		s = fn(r.ctx, n)
+	} else if h, ok := n.(htmlNode); ok {
+		s = h.html(r)
+	} else {
+		s = n.Render()
+	}
+	// raw regions are emitted verbatim
+	if n.Type() == NodeRaw {
+		return
+	}
+	if class, ok := r.options.Classes[n.Type()]; ok {
+		s = addClass(s, htmlEscaper.Replace(r.class(class)))
+	}
+	if style, ok := r.options.Styles[n.Type()]; ok {
+		s = setAttrs(s, map[string]string{"style": style})
+	}
+	if r.options.Attributer != nil {
+		s = setAttrs(s, r.options.Attributer(n))
+	}
+	if span, ok := r.spans[n]; ok && r.options.SourcePos {
+		s = addAttr(s, fmt.Sprintf("data-sourcepos=\"%s\"", span))
+	}
+	return
+}
+
+// class returns the given space-separated class names, prefixed
+// with the ClassPrefix option.
+func (r *renderer) class(names string) string {
+	if r.options.ClassPrefix == "" {
+		return names
+	}
+	fields := strings.Fields(names)
+	for i, name := range fields {
+		fields[i] = r.options.ClassPrefix + name
+	}
+	return strings.Join(fields, " ")
+}
+
+// void closes the given opening tag of void element(without its ">")
+// with the style of the VoidStyle option.
+func (r *renderer) void(tag string) string {
+	switch r.options.VoidStyle {
+	case VoidSlash:
+		return tag + "/>"
+	case VoidSpaceSlash, VoidXHTML:
+		return tag + " />"
+	}
+	return tag + ">"
+}
+
+// renderAll renders the given nodes and concatenates the results.
+func (r *renderer) renderAll(nodes []Node) string {
+	var b strings.Builder
+	for _, node := range nodes {
+		b.WriteString(r.render(node))
+	}
+	return b.String()
+}
+
+// Span holds the source position of a node. lines and columns are 1-based,
+// and the end position is inclusive.
+type Span struct {
+	StartLine, StartCol int
+	EndLine, EndCol     int
+}
+
+// String returns the span in the "startline:col-endline:col" format.
+func (s Span) String() string {
+	return fmt.Sprintf("%d:%d-%d:%d", s.StartLine, s.StartCol, s.EndLine, s.EndCol)
+}
+
+// Mapping maps a rendered top-level block to its source position
+// and to its byte range(Start inclusive, End exclusive) in the output.
+type Mapping struct {
+	Node       Node
+	Span       Span
+	Start, End int
+}
+
+// attr escapes the given attribute value for a double quoted attribute.
+// character references are kept as is, and the rest of the special
+// characters(e.g: quotes and the "<" of raw html tags) are escaped. with the
+// StrictAttrs option, values that can't be escaped safely are dropped.
+func (r *renderer) attr(value string) string {
+	if r.options.StrictAttrs && !safeAttr(value) {
+		return ""
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '&':
+			if ref := reCharRef.FindString(value[i:]); ref != "" {
+				b.WriteString(ref)
+				i += len(ref) - 1
+			} else {
+				b.WriteString("&amp;")
+			}
+		case '<':
+			b.WriteString("&lt;")
+		case '>':
+			b.WriteString("&gt;")
+		case '"':
+			b.WriteString("&quot;")
+		case '\'':
+			b.WriteString("&#39;")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// urlAttr is like attr, but it's used for urls, where the control
+// characters are percent-encoded.
+func (r *renderer) urlAttr(value string) string {
+	if r.options.BaseURL != "" {
+		value = r.absURL(value)
+	}
+	value = r.attr(value)
+	if strings.IndexFunc(value, isControl) == -1 {
+		return value
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; isControl(rune(c)) {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// absURL resolves the given relative url(html-escaped) against the
+// BaseURL option. absolute and invalid urls are returned as is.
+func (r *renderer) absURL(value string) string {
+	base, err := url.Parse(r.options.BaseURL)
+	if err != nil || value == "" {
+		return value
+	}
+	u, err := url.Parse(html.UnescapeString(value))
+	if err != nil || u.IsAbs() {
+		return value
+	}
+	return htmlEscaper.Replace(base.ResolveReference(u).String())
+}
+
+// isAbsURL tests if the given string is an absolute url, with a scheme and a host.
+func isAbsURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.IsAbs() && u.Host != ""
+}
+
+// safeAttr tests if the given attribute value can be escaped safely. values
+// that hold raw html markup, control characters or invalid utf-8 can't.
+func safeAttr(value string) bool {
+	return utf8.ValidString(value) && !strings.ContainsAny(value, "<>\"'") &&
+		strings.IndexFunc(value, func(c rune) bool { return isControl(c) && c != '\t' && c != '\n' }) == -1
+}
+
+// isControl tests if c is an ascii control character.
+func isControl(c rune) bool {
+	return c < ' ' || c == 0x7f
+}
+
+// addClass adds the given class to the first html tag in s. if the tag
+// already has a class attribute, the class is added to it.
+func addClass(s, class string) string {
+	end := strings.IndexByte(s, '>')
+	if !strings.HasPrefix(s, "<") || end == -1 {
+		return s
+	}
+	if i := strings.Index(s[:end], " class=\""); i != -1 {
+		i += len(" class=\"")
+		return s[:i] + class + " " + s[i:]
+	}
+	return addAttr(s, "class=\""+class+"\"")
+}
+
+// setAttrs sets the given attributes on the first html tag in s, in sorted
+// order. existing attributes are replaced, except class that is merged.
+func setAttrs(s string, attrs map[string]string) string {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	// addAttr inserts the attributes after the tag name
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	for _, name := range names {
+		value := htmlEscaper.Replace(attrs[name])
+		if name == "class" {
+			s = addClass(s, value)
+			continue
+		}
+		end := strings.IndexByte(s, '>')
+		if i := strings.Index(s[:end+1], " "+name+"=\""); end != -1 && i != -1 {
+			i += len(name) + 3
+			j := i + strings.IndexByte(s[i:], '"')
+			s = s[:i] + value + s[j:]
+		} else {
+			s = addAttr(s, name+"=\""+value+"\"")
+		}
+	}
+	return s
+}
+
+// addAttr adds the given attribute to the first html tag in s.
+func addAttr(s, attr string) string {
+	if !strings.HasPrefix(s, "<") {
+		return s
+	}
+	i := strings.IndexAny(s, " />")
+	if i == -1 {
+		return s
+	}
+	return s[:i] + " " + attr + s[i:]
+}
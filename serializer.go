@@ -0,0 +1,96 @@
+package mark
+
+import (
+	"html"
+	"strings"
+)
+
+// serializer holds the block and inline functions of a plain-text output
+// format(e.g: BBCode, Telegram), and implements the parts that are shared
+// between the formats. block returns fallback(node) for the nodes it doesn't
+// handle, and inline skips them.
+type serializer struct {
+	block  func(Node) string
+	inline func([]Node) string
+	// cellSep separates the cells of a table row.
+	cellSep string
+}
+
+// blocks returns the representation of the given nodes. block nodes are
+// separated by a blank line.
+func (s serializer) blocks(nodes []Node) string {
+	var blocks []string
+	for _, node := range nodes {
+		if b := s.block(node); b != "" {
+			blocks = append(blocks, b)
+		}
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+// fallback returns the representation of a node that the block function
+// doesn't handle. container nodes are rendered using their children, inline
+// nodes using the inline function, and unknown nodes are dropped.
+func (s serializer) fallback(node Node) string {
+	switch n := node.(type) {
+	case *DocumentNode:
+		return s.blocks(n.Nodes)
+	case *TabNode:
+		return s.blocks(n.Nodes)
+	case *ListItemNode:
+		return s.listItem(n)
+	case *RowNode:
+		return s.row(n)
+	case *CellNode:
+		return s.inline(n.Nodes)
+	}
+	if isInline(node) {
+		return s.inline([]Node{node})
+	}
+	return ""
+}
+
+// listItem returns the content of list item. its blocks are separated by
+// a line break.
+func (s serializer) listItem(item *ListItemNode) string {
+	var blocks []string
+	var inline []Node
+	for _, node := range item.Nodes {
+		if isInline(node) {
+			inline = append(inline, node)
+			continue
+		}
+		if len(inline) > 0 {
+			blocks, inline = append(blocks, strings.TrimRight(s.inline(inline), "\n")), nil
+		}
+		blocks = append(blocks, s.block(node))
+	}
+	if len(inline) > 0 {
+		blocks = append(blocks, s.inline(inline))
+	}
+	return strings.Join(blocks, "\n")
+}
+
+// table returns the rows of table, separated by a line break.
+func (s serializer) table(n *TableNode) string {
+	var rows []string
+	for _, row := range n.Rows {
+		rows = append(rows, s.row(row))
+	}
+	return strings.Join(rows, "\n")
+}
+
+// row returns the cells of table row, separated by cellSep.
+func (s serializer) row(row *RowNode) string {
+	var cells []string
+	for _, cell := range row.Cells {
+		cells = append(cells, s.inline(cell.Nodes))
+	}
+	return strings.Join(cells, s.cellSep)
+}
+
+// codeText returns the unescaped text of code block, without its leading
+// and trailing line breaks.
+func codeText(n *CodeNode) string {
+	return html.UnescapeString(strings.TrimSuffix(strings.TrimPrefix(n.Text, "\n"), "\n"))
+}
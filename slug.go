@@ -0,0 +1,51 @@
+package mark
+
+import (
+	"strings"
+	"unicode"
+)
+
+// slug returns the GitHub-style slug of the given text: it's lower-cased,
+// the spaces become "-", and the characters other than letters, digits,
+// "-" and "_" are removed. with translit, Latin and Cyrillic letters are
+// transliterated to ASCII first.
+func slug(text string, translit bool) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(text) {
+		if s, ok := transliterations[r]; ok && translit {
+			b.WriteString(s)
+			continue
+		}
+		switch {
+		case unicode.IsSpace(r):
+			b.WriteByte('-')
+		case r == '-' || unicode.In(r, unicode.L, unicode.M, unicode.N, unicode.Pc):
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// transliterations maps lower-case Latin and Cyrillic letters to ASCII.
+var transliterations = func() map[rune]string {
+	m := make(map[rune]string)
+	for letters, ascii := range map[string]string{
+		// Latin
+		"àáâãäåāăą": "a", "æ": "ae", "çćĉċč": "c", "ďđð": "d", "èéêëēĕėęě": "e",
+		"ĝğġģ": "g", "ĥħ": "h", "ìíîïĩīĭįı": "i", "ĳ": "ij", "ĵ": "j", "ķ": "k",
+		"ĺļľŀł": "l", "ñńņňŉ": "n", "òóôõöøōŏő": "o", "œ": "oe", "ŕŗř": "r",
+		"śŝşšș": "s", "ß": "ss", "ţťŧț": "t", "þ": "th", "ùúûüũūŭůűų": "u",
+		"ŵ": "w", "ýÿŷ": "y", "źżž": "z",
+		// Cyrillic
+		"а": "a", "б": "b", "в": "v", "гґ": "g", "д": "d", "её": "e", "є": "ye",
+		"ж": "zh", "з": "z", "иі": "i", "ї": "yi", "й": "y", "к": "k", "л": "l",
+		"м": "m", "н": "n", "о": "o", "п": "p", "р": "r", "с": "s", "т": "t",
+		"у": "u", "ф": "f", "х": "kh", "ц": "ts", "ч": "ch", "ш": "sh", "щ": "shch",
+		"ъь": "", "ы": "y", "э": "e", "ю": "yu", "я": "ya",
+	} {
+		for _, r := range letters {
+			m[r] = ascii
+		}
+	}
+	return m
+}()
@@ -0,0 +1,30 @@
+package mark
+
+import "testing"
+
+func TestSlugs(t *testing.T) {
+	cases := []struct {
+		input    string
+		style    SlugStyle
+		expected string
+	}{
+		{"# Café au lait", SlugASCII, "caf-au-lait"},
+		{"# Café au lait", SlugUnicode, "café-au-lait"},
+		{"# Café au lait", SlugTransliterate, "cafe-au-lait"},
+		{"# Привет, мир!", SlugUnicode, "привет-мир"},
+		{"# Привет, мир!", SlugTransliterate, "privet-mir"},
+		{"# 日本語の見出し", SlugUnicode, "日本語の見出し"},
+		{"# 日本語の見出し", SlugTransliterate, "日本語の見出し"},
+		{"## *Straße* & `code_x` -- [link](/a)", SlugUnicode, "straße--code_x----link"},
+		{"## *Straße* & `code_x` -- [link](/a)", SlugTransliterate, "strasse--code_x----link"},
+	}
+	for _, c := range cases {
+		doc := Parse(c.input, &Options{Slugs: c.style})
+		if actual := doc.Nodes[0].(*HeadingNode).ID(); actual != c.expected {
+			t.Errorf("%s(%d): got\n\t%+v\nexpected\n\t%+v", c.input, c.style, actual, c.expected)
+		}
+	}
+	if err := (&Options{Slugs: 3}).Validate(); err == nil {
+		t.Errorf("Validate: expected an error for unknown Slugs")
+	}
+}
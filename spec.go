@@ -0,0 +1,58 @@
+package mark
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// SpecExample is an example of the CommonMark spec, as it appears
+// in its spec.json file.
+type SpecExample struct {
+	Example   int    `json:"example"`
+	Section   string `json:"section"`
+	Markdown  string `json:"markdown"`
+	HTML      string `json:"html"`
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+}
+
+// SpecResult is the result of rendering a spec example.
+type SpecResult struct {
+	SpecExample
+	Output string // the rendered output, or the error message
+	Pass   bool
+}
+
+// RunSpec loads the examples of the CommonMark spec from r(spec.json of
+// any version), renders them with the given options and reports the result
+// of each one. the outputs are compared after removing the new-lines around
+// tags and the void element slashes(<br /> and <br>), since mark doesn't
+// emit them.
+func RunSpec(r io.Reader, opts *Options) ([]SpecResult, error) {
+	var examples []SpecExample
+	if err := json.NewDecoder(r).Decode(&examples); err != nil {
+		return nil, err
+	}
+	results := make([]SpecResult, len(examples))
+	for i, e := range examples {
+		res := SpecResult{SpecExample: e}
+		if s, err := New(e.Markdown, opts).RenderE(); err != nil {
+			res.Output = err.Error()
+		} else {
+			res.Output = s
+			res.Pass = specNormalize(s) == specNormalize(e.HTML)
+		}
+		results[i] = res
+	}
+	return results, nil
+}
+
+// specNormalizer removes the formatting differences between mark outputs
+// and the spec outputs, that don't change the rendered html.
+var specNormalizer = strings.NewReplacer(">\n", ">", "\n<", "<")
+
+// specNormalize returns the normalized form of the given output.
+func specNormalize(s string) string {
+	return specNormalizer.Replace(strings.Replace(strings.TrimSpace(s), " />", ">", -1))
+}
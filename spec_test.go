@@ -0,0 +1,31 @@
+package mark
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRunSpec(t *testing.T) {
+	spec := `[
+	{"markdown": "foo *bar*\n", "html": "<p>foo <em>bar</em></p>\n", "example": 1, "start_line": 1, "end_line": 5, "section": "Emphasis"},
+	{"markdown": "foo  \nbar\n", "html": "<p>foo<br />\nbar</p>\n", "example": 2, "start_line": 6, "end_line": 10, "section": "Hard line breaks"},
+	{"markdown": "***\n", "html": "<hr />\n", "example": 3, "start_line": 11, "end_line": 15, "section": "Thematic breaks"},
+	{"markdown": "- foo\n", "html": "<ol>\n<li>foo</li>\n</ol>\n", "example": 4, "start_line": 16, "end_line": 20, "section": "Lists"}
+]`
+	results, err := RunSpec(strings.NewReader(spec), nil)
+	if err != nil {
+		t.Fatalf("RunSpec: unexpected error: %v", err)
+	}
+	pass := []bool{true, true, true, false}
+	if len(results) != len(pass) {
+		t.Fatalf("RunSpec: got %d results, expected %d", len(results), len(pass))
+	}
+	for i, res := range results {
+		if res.Pass != pass[i] {
+			t.Errorf("example %d(%s): got\n\t%+v\nexpected\n\t%+v", res.Example, res.Section, res.Output, res.HTML)
+		}
+	}
+	if _, err := RunSpec(strings.NewReader("{"), nil); err == nil {
+		t.Error("RunSpec: expected an error for invalid json")
+	}
+}
@@ -0,0 +1,190 @@
+package mark
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// Stream renders markdown that arrives in chunks, e.g: over a network
+// connection. the input is split into groups of blocks at blank lines, and
+// each group is rendered and written to w once the first line that follows
+// the blank line arrives. until then, the group is buffered whole, so an
+// input without blank lines is written only by Finish. constructs that can
+// contain blank lines(fenced code, html blocks, details, raw regions and
+// shortcodes) are buffered until they're closed, and a list is buffered
+// until a line that doesn't continue it, since its items are rendered
+// together(e.g: the items of a loose list are wrapped with paragraphs).
+//
+// Note: reference links are resolved only against the link definitions
+// that were already rendered.
+type Stream struct {
+	w     io.Writer
+	opts  *Options
+	buf   []byte                  // input that wasn't rendered yet
+	line  int                     // line of the start of buf
+	scan  int                     // end of the scanned lines of buf
+	blank bool                    // the last scanned line is blank
+	open  func(line string) bool  // reports if the line closes the open construct
+	sep   string                  // separator between the last output and the next one
+	links map[string]*DefLinkNode // link definitions of the rendered input
+	err   error
+}
+
+// NewStream returns a stream that writes the rendered input to w.
+func NewStream(w io.Writer, opts *Options) *Stream {
+	opts = opts.merge()
+	links := make(map[string]*DefLinkNode)
+	for name, l := range opts.Links {
+		links[name] = l
+	}
+	return &Stream{w: w, opts: opts, line: 1, links: links}
+}
+
+// Feed adds a chunk of the input, and writes the output of the blocks that
+// were completed by it. it returns the error of the writer, and once it
+// failed, the next calls return the same error.
+func (s *Stream) Feed(chunk []byte) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.buf = append(s.buf, chunk...)
+	if end := s.split(); end > 0 {
+		s.flush(end)
+	}
+	return s.err
+}
+
+// Finish renders and writes the rest of the input.
+func (s *Stream) Finish() error {
+	if s.err == nil && len(s.buf) > 0 {
+		s.flush(len(s.buf))
+	}
+	return s.err
+}
+
+// split scans the complete lines of the buffer, and returns the end of the
+// blocks that can be rendered, or 0 if there are no such blocks.
+func (s *Stream) split() (end int) {
+	for {
+		i := bytes.IndexByte(s.buf[s.scan:], '\n')
+		if i == -1 {
+			return
+		}
+		pos, line := s.scan, string(s.buf[s.scan:s.scan+i+1])
+		s.scan += i + 1
+		switch {
+		case s.open != nil:
+			if s.open(line) {
+				s.open = nil
+			}
+		case strings.TrimSpace(line) == "":
+			s.blank = true
+			continue
+		default:
+			if s.blank && pos > 0 && s.boundary(line) {
+				end = pos
+			}
+			s.open = s.opener(line, pos)
+		}
+		s.blank = false
+	}
+}
+
+// boundary tests if the given line, that follows a blank line, starts a new
+// group of blocks. indented lines, list items and tabs may continue the
+// previous blocks.
+func (s *Stream) boundary(line string) bool {
+	switch {
+	case line[0] == ' ' || line[0] == '\t':
+		return false
+	case reList.item.MatchString(line):
+		return false
+	case s.opts.Tabs && strings.HasPrefix(line, "==="):
+		return false
+	}
+	return true
+}
+
+// opener returns the closing test of the construct that is opened by the
+// given line, or nil if it doesn't open one.
+func (s *Stream) opener(line string, pos int) func(string) bool {
+	until := func(end string) func(string) bool {
+		return func(line string) bool { return strings.Contains(line, end) }
+	}
+	if m := reGfmCode.FindStringSubmatch(line); m != nil {
+		fence := m[2]
+		return func(line string) bool { return closesFence(line, fence) }
+	}
+	if strings.HasPrefix(line, "<!--") && !strings.Contains(line[4:], "-->") {
+		return until("-->")
+	}
+	if m := reHTML.item.FindStringSubmatch(line); m != nil && !reHTML.span.MatchString(m[1]) && !strings.HasSuffix(m[0], "/>") {
+		if s.opts.MarkdownInHTML && reMarkdownAttr.MatchString(m[0]) {
+			if closed := tagCloser(m[1]); !closed(line) {
+				return closed
+			}
+			return nil
+		}
+		end := "</" + m[1]
+		if m[1] == reHTML.CDATA_OPEN {
+			end = reHTML.CDATA_CLOSE
+		}
+		if !strings.Contains(line[len(m[0]):], end) {
+			return until(end)
+		}
+	}
+	if opensContainer(line, s.opts) {
+		closed := containerCloser(s.opts)
+		closed(line)
+		return closed
+	}
+	// the longest delimiter wins, like in lexRaw
+	var open string
+	for delim := range s.opts.RawDelims {
+		if strings.HasPrefix(line, delim) && len(delim) > len(open) {
+			open = delim
+		}
+	}
+	if end := s.opts.RawDelims[open]; open != "" && !strings.Contains(line[len(open):], end) {
+		return until(end)
+	}
+	if m := reShortcode.FindStringSubmatch(line); s.opts.Shortcodes && m != nil && m[2] == "" && !strings.HasSuffix(m[4], "/") {
+		if name := m[3]; shortcodeEnd(line, name) == nil {
+			return func(line string) bool { return shortcodeEnd(line, name) != nil }
+		}
+	}
+	if s.opts.FrontMatter && s.line == 1 && pos == 0 && line == "---\n" {
+		return func(line string) bool { return line == "---\n" }
+	}
+	return nil
+}
+
+// flush renders the first end bytes of the buffer, and writes their output.
+func (s *Stream) flush(end int) {
+	input := string(s.buf[:end])
+	s.buf = s.buf[:copy(s.buf, s.buf[end:])]
+	s.scan -= end
+	opts := *s.opts
+	opts.Links = s.links
+	// the front matter is only at the start of the input
+	opts.FrontMatter = s.opts.FrontMatter && s.line == 1
+	m := New(input, &opts)
+	m.parse.line = s.line
+	s.line += strings.Count(input, "\n")
+	m.parse.parse()
+	for name, l := range m.links {
+		if _, ok := s.links[name]; !ok {
+			s.links[name] = l
+		}
+	}
+	var b strings.Builder
+	r := m.renderer()
+	for _, node := range m.Nodes {
+		if output, sep := r.block(node); output != "" {
+			b.WriteString(s.sep + output)
+			s.sep = sep
+		}
+	}
+	_, s.err = io.WriteString(s.w, b.String())
+}
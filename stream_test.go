@@ -0,0 +1,88 @@
+package mark
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	cases := []struct {
+		input string
+		opts  *Options
+	}{
+		{"# Title\n\nfoo *bar*\nbaz\n\n---\n\n> quote\n\n[ref]: /url\n\nsee [ref]", &Options{SourcePos: true}},
+		{"```go\nfunc() {\n\n\n}\n```\n\nafter the code\n\n    indented\n\n    code", &Options{SourcePos: true}},
+		{"- a\n\n- b\n\n  nested paragraph\n\n1. c\n2. d\n\ntext", &Options{SourcePos: true}},
+		{"<div>\n*not parsed*\n</div>\n\nend", nil},
+		{"::: note\n\n```\n:::\n```\n\n:::\n\nend", &Options{Divs: true}},
+		{":::: {#a .note}\n\nfoo\n\n::: tip\n\nbar\n\n:::\n\nbaz\n\n::::\n\nend", &Options{Divs: true}},
+		{"<div markdown=\"1\">\n\n<div markdown=\"1\">\n\n*x*\n\n</div>\n\n*y*\n\n</div>\n\nend", &Options{MarkdownInHTML: true}},
+		{":::details Summary\n\nfoo\n\n:::\n\n{% raw %}\n\n*raw*\n\n{% endraw %}\n\nbar", &Options{Details: true, RawDelims: map[string]string{"{% raw %}": "{% endraw %}"}}},
+	}
+	for _, c := range cases {
+		input, opts := c.input, c.opts
+		expected := New(input, opts).Render()
+		for _, size := range []int{1, 3, len(input)} {
+			var b strings.Builder
+			s := NewStream(&b, opts)
+			for i := 0; i < len(input); i += size {
+				end := i + size
+				if end > len(input) {
+					end = len(input)
+				}
+				if err := s.Feed([]byte(input[i:end])); err != nil {
+					t.Fatalf("Feed: unexpected error: %v", err)
+				}
+			}
+			if err := s.Finish(); err != nil {
+				t.Fatalf("Finish: unexpected error: %v", err)
+			}
+			if actual := b.String(); actual != expected {
+				t.Errorf("%q(%d): got\n\t%+v\nexpected\n\t%+v", input, size, actual, expected)
+			}
+		}
+	}
+}
+
+func TestStreamPartial(t *testing.T) {
+	var b strings.Builder
+	s := NewStream(&b, nil)
+	s.Feed([]byte("foo\n\nbar"))
+	if actual := b.String(); actual != "" {
+		t.Errorf("Feed: got\n\t%+v\nexpected no output before the next block", actual)
+	}
+	s.Feed([]byte("\n\n```\ncode\n\nmore"))
+	if expected := "<p>foo</p>\n<p>bar</p>"; b.String() != expected {
+		t.Errorf("Feed: got\n\t%+v\nexpected\n\t%+v", b.String(), expected)
+	}
+	s.Finish()
+	if expected := "<p>foo</p>\n<p>bar</p>\n<pre><code>\ncode\n\nmore</code></pre>"; b.String() != expected {
+		t.Errorf("Finish: got\n\t%+v\nexpected\n\t%+v", b.String(), expected)
+	}
+	// a list is written as a whole
+	b.Reset()
+	s = NewStream(&b, nil)
+	s.Feed([]byte("- a\n\n- b\n\n  c\n\n"))
+	if actual := b.String(); actual != "" {
+		t.Errorf("Feed: got\n\t%+v\nexpected no output before the end of the list", actual)
+	}
+	s.Feed([]byte("d\n"))
+	if expected := "<ul>\n<li><p>a</p></li>\n<li><p>b</p><p>c</p></li>\n</ul>"; b.String() != expected {
+		t.Errorf("Feed: got\n\t%+v\nexpected\n\t%+v", b.String(), expected)
+	}
+}
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) { return 0, errors.New("closed") }
+
+func TestStreamError(t *testing.T) {
+	s := NewStream(errWriter{}, nil)
+	if err := s.Feed([]byte("foo\n\nbar\n")); err == nil || err.Error() != "closed" {
+		t.Errorf("Feed: got error %v, expected closed", err)
+	}
+	if err := s.Finish(); err == nil {
+		t.Error("Finish: expected the error of the writer")
+	}
+}
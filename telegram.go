@@ -0,0 +1,142 @@
+package mark
+
+import (
+	"html"
+	"strconv"
+	"strings"
+)
+
+// Telegram returns the representation of the given nodes in the HTML subset
+// that is accepted by the Telegram Bot API(parse_mode=HTML). constructs
+// without a matching tag are downgraded, e.g: headings are rendered as bold
+// lines, list items as "•" lines and images as links. block nodes are
+// separated by a blank line.
+func Telegram(nodes ...Node) string {
+	return tgSerializer().blocks(nodes)
+}
+
+// Telegram returns the Telegram representation of the document.
+func (d *DocumentNode) Telegram() string {
+	return Telegram(d.Nodes...)
+}
+
+// tgSerializer returns the Telegram serializer.
+func tgSerializer() serializer {
+	return serializer{block: tgBlock, inline: tgInline, cellSep: " | "}
+}
+
+// tgBlock returns the Telegram representation of a block node.
+func tgBlock(node Node) string {
+	switch n := node.(type) {
+	case *ParagraphNode:
+		return tgInline(n.Nodes)
+	case *HeadingNode:
+		return "<b>" + tgInline(n.Nodes) + "</b>"
+	case *HrNode:
+		return "———"
+	case *CodeNode:
+		text := tgEscaper.Replace(codeText(n))
+		if n.Lang != "" {
+			return "<pre><code class=\"language-" + tgEscape(n.Lang) + "\">" + text + "</code></pre>"
+		}
+		return "<pre>" + text + "</pre>"
+	case *DefLinkNode:
+		return ""
+	case *ListNode:
+		return tgList(n)
+	case *TableNode:
+		return tgSerializer().table(n)
+	case *BlockQuoteNode:
+		return "<blockquote>" + Telegram(n.Nodes...) + "</blockquote>"
+	case *DetailsNode:
+		return "<b>" + tgInline(n.Summary) + "</b>\n" + Telegram(n.Nodes...)
+	case *DivNode:
+		return Telegram(n.Nodes...)
+	case *TabsNode:
+		var tabs []string
+		for _, tab := range n.Tabs {
+			tabs = append(tabs, "<b>"+tgEscape(tab.Title)+"</b>\n"+Telegram(tab.Nodes...))
+		}
+		return strings.Join(tabs, "\n\n")
+	case *HTMLNode:
+		if n.Open != "" {
+			return Telegram(n.Nodes...)
+		}
+		return tgEscaper.Replace(strings.TrimRight(n.Src, "\n"))
+	case *RawNode:
+		return n.Text
+	case *ShortcodeNode:
+		return tgEscaper.Replace(mdShortcode(n))
+	default:
+		return tgSerializer().fallback(node)
+	}
+}
+
+// tgInline returns the Telegram representation of inline nodes.
+func tgInline(nodes []Node) (s string) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *TextNode:
+			s += tgEscape(n.Text)
+		case *LiteralNode:
+			s += tgEscape(n.Text)
+		case *BrNode:
+			s += "\n"
+		case *EmphasisNode:
+			tag := map[itemType]string{itemStrong: "b", itemItalic: "i", itemStrike: "s", itemCode: "code"}[n.Style]
+			s += "<" + tag + ">" + tgInline(n.Nodes) + "</" + tag + ">"
+		case *LinkNode:
+			s += "<a href=\"" + tgEscape(n.Href) + "\">" + tgInline(n.Nodes) + "</a>"
+		case *ImageNode:
+			alt := tgEscape(n.Alt)
+			if alt == "" {
+				alt = "image"
+			}
+			s += "<a href=\"" + tgEscape(n.Src) + "\">" + alt + "</a>"
+		case *RefNode:
+			s += tgInline([]Node{n.resolve()})
+		case *EmojiNode:
+			s += ":" + n.Name + ":"
+		case *RubyNode:
+			s += tgInline(n.Nodes) + "(" + tgEscape(n.Text) + ")"
+		case *CheckboxNode:
+			if n.Checked {
+				s += "☑ "
+			} else {
+				s += "☐ "
+			}
+		case *HTMLNode:
+			s += tgEscaper.Replace(n.Src)
+		case *CustomInlineNode:
+			s += tgEscaper.Replace(n.Match[0])
+		case *RawNode:
+			s += n.Text
+		case *ShortcodeNode:
+			s += tgEscaper.Replace(mdShortcode(n))
+		}
+	}
+	return
+}
+
+// tgList returns the Telegram representation of list. each item starts
+// with a bullet or its number, and nested blocks are indented.
+func tgList(n *ListNode) string {
+	items := make([]string, len(n.Items))
+	for i, item := range n.Items {
+		marker := "• "
+		if n.Ordered {
+			marker = strconv.Itoa(n.start()+i) + ". "
+		}
+		items[i] = mdPrefix(tgSerializer().listItem(item), marker, "    ")
+	}
+	return strings.Join(items, "\n")
+}
+
+// tgEscaper escapes the characters that Telegram requires to be escaped.
+// Telegram supports only the &lt;, &gt;, &amp; and &quot; named entities.
+var tgEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;")
+
+// tgEscape re-escapes html-escaped text with the Telegram entities.
+func tgEscape(s string) string {
+	return tgEscaper.Replace(html.UnescapeString(s))
+}
@@ -0,0 +1,41 @@
+package mark
+
+import "testing"
+
+func TestTelegram(t *testing.T) {
+	cases := map[string]string{
+		"# Title\n\nfoo **bar** _baz_ ~~qux~~ `a<b`":  "<b>Title</b>\n\nfoo <b>bar</b> <i>baz</i> <s>qux</s> <code>a&lt;b</code>",
+		"[x](http://a.com?b=1&c=2) ![y](/z.png)":      "<a href=\"http://a.com?b=1&amp;c=2\">x</a> <a href=\"/z.png\">y</a>",
+		"- foo\n- [x] bar\n\nlist:\n\n1. one\n2. two": "• foo\n• ☑ bar\n\nlist:\n\n1. one\n2. two",
+		"```go\nif a < b {}\n```":                     "<pre><code class=\"language-go\">if a &lt; b {}</code></pre>",
+		"> quote\n\n***\n\nit's":                      "<blockquote>quote</blockquote>\n\n———\n\nit's",
+		"<div>foo</div>":                              "&lt;div&gt;foo&lt;/div&gt;",
+		"a | b\n--|--\n1 | 2":                         "a | b\n1 | 2",
+		"foo  \nbar":                                  "foo\nbar",
+		"[ref][r]\n\n[r]: /r":                         "<a href=\"/r\">ref</a>",
+	}
+	for input, expected := range cases {
+		if actual := Parse(input, nil).Telegram(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+}
+
+func TestTelegramNodes(t *testing.T) {
+	cases := []struct {
+		node     Node
+		expected string
+	}{
+		{NewListItem(NewText("foo"), NewParagraph(NewText("bar"))), "foo\nbar"},
+		{NewRow(NewCell(Data, None, NewText("a")), NewCell(Data, None, NewText("b"))), "a | b"},
+		{NewTab("t", NewParagraph(NewText("foo"))), "foo"},
+		{NewDocument(NewParagraph(NewText("a")), NewHr()), "a\n\n———"},
+		{NewParagraph(NewText("a"), NewShortcode("x", nil, ""), userNode{NodeType: 100}), "a{{&lt; x /&gt;}}"},
+		{userNode{NodeType: 100}, ""},
+	}
+	for _, c := range cases {
+		if actual := Telegram(c.node); actual != c.expected {
+			t.Errorf("%T: got\n\t%+v\nexpected\n\t%+v", c.node, actual, c.expected)
+		}
+	}
+}
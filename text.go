@@ -0,0 +1,117 @@
+package mark
+
+import (
+	"html"
+	"strconv"
+	"strings"
+)
+
+// Text returns the plain text of the given nodes, without markup, e.g: for
+// search indexes or text emails. list items start with "- " or their number,
+// table cells are separated by tabs, images are replaced with their alt text
+// and raw html is dropped. block nodes are separated by a blank line.
+func Text(nodes ...Node) string {
+	return txSerializer().blocks(nodes)
+}
+
+// Text returns the plain text of the document.
+func (d *DocumentNode) Text() string {
+	return Text(d.Nodes...)
+}
+
+// txSerializer returns the plain text serializer.
+func txSerializer() serializer {
+	return serializer{block: txBlock, inline: txInline, cellSep: "\t"}
+}
+
+// txBlock returns the plain text of a block node.
+func txBlock(node Node) string {
+	switch n := node.(type) {
+	case *ParagraphNode:
+		return txInline(n.Nodes)
+	case *HeadingNode:
+		return txInline(n.Nodes)
+	case *HrNode, *DefLinkNode:
+		return ""
+	case *HTMLNode:
+		return Text(n.Nodes...)
+	case *CodeNode:
+		return codeText(n)
+	case *ListNode:
+		return txList(n)
+	case *TableNode:
+		return txSerializer().table(n)
+	case *BlockQuoteNode:
+		return Text(n.Nodes...)
+	case *DetailsNode:
+		return txInline(n.Summary) + "\n\n" + Text(n.Nodes...)
+	case *DivNode:
+		return Text(n.Nodes...)
+	case *TabsNode:
+		var tabs []string
+		for _, tab := range n.Tabs {
+			tabs = append(tabs, html.UnescapeString(tab.Title)+"\n\n"+Text(tab.Nodes...))
+		}
+		return strings.Join(tabs, "\n\n")
+	case *RawNode:
+		return n.Text
+	case *ShortcodeNode:
+		return Text(n.Nodes...)
+	default:
+		return txSerializer().fallback(node)
+	}
+}
+
+// txInline returns the plain text of inline nodes.
+func txInline(nodes []Node) (s string) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *TextNode:
+			// the inline html tags are kept in the text
+			s += html.UnescapeString(reOutTag.ReplaceAllString(n.Text, ""))
+		case *LiteralNode:
+			s += html.UnescapeString(n.Text)
+		case *BrNode:
+			s += "\n"
+		case *EmphasisNode:
+			s += txInline(n.Nodes)
+		case *LinkNode:
+			s += txInline(n.Nodes)
+		case *ImageNode:
+			s += html.UnescapeString(n.Alt)
+		case *RefNode:
+			s += txInline([]Node{n.resolve()})
+		case *EmojiNode:
+			s += ":" + n.Name + ":"
+		case *RubyNode:
+			s += txInline(n.Nodes) + "(" + html.UnescapeString(n.Text) + ")"
+		case *CheckboxNode:
+			if n.Checked {
+				s += "[x] "
+			} else {
+				s += "[ ] "
+			}
+		case *CustomInlineNode:
+			s += n.Match[0]
+		case *RawNode:
+			s += n.Text
+		case *ShortcodeNode:
+			s += txInline(n.Nodes)
+		}
+	}
+	return
+}
+
+// txList returns the plain text of list. each item starts with "- " or
+// its number, and nested blocks are indented.
+func txList(n *ListNode) string {
+	items := make([]string, len(n.Items))
+	for i, item := range n.Items {
+		marker := "- "
+		if n.Ordered {
+			marker = strconv.Itoa(n.start()+i) + ". "
+		}
+		items[i] = mdPrefix(txSerializer().listItem(item), marker, strings.Repeat(" ", len(marker)))
+	}
+	return strings.Join(items, "\n")
+}
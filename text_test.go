@@ -0,0 +1,39 @@
+package mark
+
+import "testing"
+
+func TestText(t *testing.T) {
+	cases := map[string]string{
+		"# Title\n\nfoo **bar** _baz_ `a<b` &amp;":   "Title\n\nfoo bar baz a<b &",
+		"[x](http://a.com) ![alt](/z.png) <b>c</b>":  "x alt c",
+		"- foo\n- [x] bar\n\ntext\n\n3. one\n4. two": "- foo\n- [x] bar\n\ntext\n\n3. one\n4. two",
+		"```go\nif a < b {}\n```\n\n***":             "if a < b {}",
+		"> quote\n\n<div>html</div>\n\nit's":         "quote\n\nit's",
+		"a | b\n--|--\n1 | 2":                        "a\tb\n1\t2",
+		"foo  \nbar\n\n[ref][r]\n\n[r]: /r":          "foo\nbar\n\nref",
+	}
+	for input, expected := range cases {
+		if actual := Parse(input, nil).Text(); actual != expected {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%+v", input, actual, expected)
+		}
+	}
+}
+
+func TestTextNodes(t *testing.T) {
+	cases := []struct {
+		node     Node
+		expected string
+	}{
+		{NewListItem(NewText("foo"), NewParagraph(NewText("bar"))), "foo\nbar"},
+		{NewRow(NewCell(Data, None, NewText("a")), NewCell(Data, None, NewText("b"))), "a\tb"},
+		{NewTab("t", NewParagraph(NewText("foo"))), "foo"},
+		{NewDocument(NewParagraph(NewText("a")), NewHr()), "a"},
+		{NewParagraph(NewText("a"), NewShortcode("x", nil, "", NewText("b")), userNode{NodeType: 100}), "ab"},
+		{userNode{NodeType: 100}, ""},
+	}
+	for _, c := range cases {
+		if actual := Text(c.node); actual != c.expected {
+			t.Errorf("%T: got\n\t%+v\nexpected\n\t%+v", c.node, actual, c.expected)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !wasm
+
+package mark
+
+import "runtime"
+
+// defaultWorkers returns the default number of RenderAll workers.
+func defaultWorkers() int {
+	return runtime.NumCPU()
+}
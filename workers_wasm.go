@@ -0,0 +1,7 @@
+package mark
+
+// defaultWorkers returns the default number of RenderAll workers. wasm
+// runs on a single thread, so the inputs are rendered sequentially.
+func defaultWorkers() int {
+	return 1
+}